@@ -126,6 +126,11 @@ func (a *App) stopModules() {
 	a.Invoke(func(m modules) error {
 		slog.Info("stopping modules", slog.Int("count", len(m.Modules)))
 		cancelFuncs := make([]context.CancelFunc, 0, len(m.Modules))
+
+		// stop in the reverse order of the start, e.g. kitweb stops serving before the stores close
+		sortModules(m.Modules)
+		slices.Reverse(m.Modules)
+
 		for _, mod := range m.Modules {
 			timeoutCtx, cancelFunc := context.WithTimeout(context.Background(), a.config.HooksMaxLifetime)
 			cancelFuncs = append(cancelFuncs, cancelFunc)
@@ -277,6 +282,9 @@ func (a *App) startModules() {
 	a.Invoke(func(m modules) {
 		slog.Info("starting modules", slog.Int("count", len(m.Modules)))
 		cancelFuncs := make([]context.CancelFunc, 0, len(m.Modules))
+
+		sortModules(m.Modules)
+
 		for _, mod := range m.Modules {
 			timeoutCtx, cancelFunc := context.WithTimeout(context.Background(), a.config.HooksMaxLifetime)
 			cancelFuncs = append(cancelFuncs, cancelFunc)
@@ -292,6 +300,22 @@ func (a *App) startModules() {
 	})
 }
 
+// sortModules sorts the modules for high (number) priority first, the modules that are not
+// Configurable have a priority of 0.
+func sortModules(mods []Mod) {
+	priority := func(mod Mod) int {
+		if configurable, ok := mod.(Configurable); ok {
+			return int(configurable.Priority())
+		}
+
+		return 0
+	}
+
+	slices.SortStableFunc(mods, func(a, b Mod) int {
+		return priority(b) - priority(a)
+	})
+}
+
 func (a *App) configureModules() {
 	a.Invoke(func(m configurables) {
 		slog.Info("configuring modules", slog.Int("count", len(m.Configurables)))
@@ -0,0 +1,159 @@
+package kitcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"reflect"
+)
+
+var (
+	ErrUnexpectedType = errors.New("kitcache: unexpected value type")
+)
+
+type (
+	// Codec converts the values of a Cache to bytes, so they can be kept by a store outside
+	// the process.
+	Codec interface {
+		Marshal(v any) ([]byte, error)
+
+		// Unmarshal decodes data into v, a pointer to the value.
+		Unmarshal(data []byte, v any) error
+
+		kitcat.Nameable
+	}
+
+	JSONCodec     struct{}
+	GobCodec      struct{}
+	MsgpackCodec  struct{}
+	ProtobufCodec struct{}
+)
+
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (JSONCodec) Name() string                       { return "json" }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (GobCodec) Name() string { return "gob" }
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MsgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+func (MsgpackCodec) Name() string                       { return "msgpack" }
+
+// Marshal expects a proto.Message, the cached type is usually a pointer to a generated struct.
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a proto.Message", ErrUnexpectedType, v)
+	}
+
+	return proto.Marshal(message)
+}
+
+// Unmarshal accepts a proto.Message, or a pointer to a nil proto.Message pointer which is allocated.
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	if message, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, message)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+		return fmt.Errorf("%w: %T is not a proto.Message", ErrUnexpectedType, v)
+	}
+
+	rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+
+	message, ok := rv.Elem().Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T is not a proto.Message", ErrUnexpectedType, v)
+	}
+
+	return proto.Unmarshal(data, message)
+}
+
+func (ProtobufCodec) Name() string { return "protobuf" }
+
+// the first byte of an encoded value tells if the rest is compressed
+const (
+	encodingRaw byte = iota
+	encodingGzip
+)
+
+// encoder encodes the values of a Cache with a codec, values of at least compressThreshold bytes
+// are compressed when compressThreshold is positive.
+type encoder struct {
+	codec             Codec
+	compressThreshold int
+}
+
+func (e encoder) encode(v any) ([]byte, error) {
+	data, err := e.codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal value with %s codec: %w", e.codec.Name(), err)
+	}
+
+	if e.compressThreshold <= 0 || len(data) < e.compressThreshold {
+		return append([]byte{encodingRaw}, data...), nil
+	}
+
+	buf := bytes.NewBuffer([]byte{encodingGzip})
+
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("unable to compress value: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("unable to compress value: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (e encoder) decode(data []byte, v any) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: empty encoded value", ErrUnexpectedType)
+	}
+
+	payload := data[1:]
+
+	switch data[0] {
+	case encodingRaw:
+	case encodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("unable to decompress value: %w", err)
+		}
+
+		if payload, err = io.ReadAll(r); err != nil {
+			return fmt.Errorf("unable to decompress value: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown encoding %d", ErrUnexpectedType, data[0])
+	}
+
+	if err := e.codec.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("unable to unmarshal value with %s codec: %w", e.codec.Name(), err)
+	}
+
+	return nil
+}
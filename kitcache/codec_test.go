@@ -0,0 +1,63 @@
+package kitcache
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"strings"
+	"testing"
+)
+
+type testCodecValue struct {
+	Name string
+	Tags []string
+}
+
+func TestCache_Codec(t *testing.T) {
+	ctx := context.Background()
+
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}, MsgpackCodec{}} {
+		t.Run("stores the values as bytes with the "+codec.Name()+" codec", func(t *testing.T) {
+			store := newTestInMemoryStore(t)
+			cache := NewCache[testCodecValue](store, WithCodec(codec), WithCompression(64))
+
+			small := testCodecValue{Name: "small", Tags: []string{"a"}}
+			large := testCodecValue{Name: strings.Repeat("large", 100), Tags: []string{"b"}}
+
+			require.NoError(t, cache.SetMany(ctx, map[string]testCodecValue{"small": small, "large": large}, nil))
+			store.Cache.Wait()
+
+			stored, err := store.Get(ctx, "large")
+			require.NoError(t, err)
+			require.IsType(t, []byte{}, stored)
+			require.Equal(t, encodingGzip, stored.([]byte)[0])
+			require.Less(t, len(stored.([]byte)), len(large.Name))
+
+			values, err := cache.GetMany(ctx, []string{"small", "large"})
+			require.NoError(t, err)
+			require.Equal(t, map[string]testCodecValue{"small": small, "large": large}, values)
+		})
+	}
+
+	t.Run("stores proto messages with the protobuf codec", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+		cache := NewCache[*wrapperspb.StringValue](store, WithCodec(ProtobufCodec{}))
+
+		require.NoError(t, cache.Set(ctx, "key", wrapperspb.String("value"), nil))
+		store.Cache.Wait()
+
+		value, err := cache.Get(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, proto.Equal(wrapperspb.String("value"), value))
+	})
+
+	t.Run("returns an error instead of panicking on bytes without codec", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+		require.NoError(t, store.Set(ctx, "key", []byte("value"), nil))
+		store.Cache.Wait()
+
+		_, err := NewCache[testCodecValue](store).Get(ctx, "key")
+		require.ErrorIs(t, err, ErrUnexpectedType)
+	})
+}
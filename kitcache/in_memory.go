@@ -1,17 +1,36 @@
 package kitcache
 
 import (
+	"context"
 	"fmt"
 	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/z"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/spf13/viper"
 	"go.uber.org/dig"
+	"hash/fnv"
+	"reflect"
+	"sync"
+	"time"
 )
 
 type InMemoryStoreConfig struct {
 	NumCounters int64 `cfg:"num_counters"`
+
+	// MaxCost is the maximum total cost of the values, in bytes with the default Cost
 	MaxCost     int64 `cfg:"max_cost"`
 	BufferItems int64 `cfg:"buffer_items"`
+
+	// ReadYourWrites makes the writes wait for ristretto to apply them, so a Get right after a Set
+	// sees the value. Ristretto applies the writes asynchronously otherwise, and may drop a write
+	// following another one on a new key.
+	ReadYourWrites bool `cfg:"read_your_writes"`
+
+	// Metrics enables the counters returned by InMemoryStore.Stats
+	Metrics bool `cfg:"metrics"`
+
+	// Cost returns the cost of a value, the approximate size in bytes of the value when nil
+	Cost func(value any) int64 // manually configurable
 }
 
 func (i *InMemoryStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
@@ -20,6 +39,8 @@ func (i *InMemoryStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
 	viper.SetDefault(prefix+".num_counters", 1e7)
 	viper.SetDefault(prefix+".max_cost", 1<<30)
 	viper.SetDefault(prefix+".buffer_items", 64)
+	viper.SetDefault(prefix+".read_your_writes", true)
+	viper.SetDefault(prefix+".metrics", true)
 
 	return kitcat.ConfigUnmarshalHandler(prefix, i, "unable to unmarshal in memory store config: %w")
 }
@@ -28,10 +49,23 @@ func init() {
 	kitcat.RegisterConfig(new(InMemoryStoreConfig))
 }
 
+// InMemoryStore is a Store backed by ristretto, the values are kept as Go values.
+// The atomic operations lock the key so they are not interleaved with other writes on it.
 type InMemoryStore struct {
 	Cache *ristretto.Cache
+
+	config *InMemoryStoreConfig
+	cost   func(value any) int64
+
+	locks [inMemoryStoreLocks]sync.Mutex
+
+	// index keeps the keys and their tags for InvalidateTags and DeletePrefix
+	index *inMemoryIndex
 }
 
+// inMemoryStoreLocks is the number of mutexes shared by the keys
+const inMemoryStoreLocks = 64
+
 type InMemoryStoreParams struct {
 	dig.In
 
@@ -46,23 +80,47 @@ func NewInMemoryStore(params InMemoryStoreParams) (*InMemoryStore, error) {
 		NumCounters: params.Config.NumCounters,
 		MaxCost:     params.Config.MaxCost,
 		BufferItems: params.Config.BufferItems,
+		Metrics:     params.Config.Metrics,
 	}
 
 	if params.RistrettoConfig != nil {
-		ristrettoConfig = params.RistrettoConfig
+		// copied as the callbacks are wrapped to maintain the index
+		config := *params.RistrettoConfig
+		ristrettoConfig = &config
 	}
 
+	if ristrettoConfig.KeyToHash == nil {
+		ristrettoConfig.KeyToHash = z.KeyToHash
+	}
+
+	store := &InMemoryStore{
+		config: params.Config,
+		cost:   params.Config.Cost,
+		index:  newInMemoryIndex(ristrettoConfig.KeyToHash),
+	}
+
+	if store.cost == nil {
+		store.cost = sizeOf
+	}
+
+	getCache := func() *ristretto.Cache { return store.Cache }
+
+	ristrettoConfig.OnEvict = store.index.onRemoved(getCache, ristrettoConfig.OnEvict)
+	ristrettoConfig.OnReject = store.index.onRemoved(getCache, ristrettoConfig.OnReject)
+
 	cache, err := ristretto.NewCache(ristrettoConfig)
 
 	if err != nil {
 		return nil, fmt.Errorf("error while creating in memory cache: %w", err)
 	}
 
-	return &InMemoryStore{Cache: cache}, nil
+	store.Cache = cache
+
+	return store, nil
 }
 
-func (i InMemoryStore) Get(s string) (any, error) {
-	a, ok := i.Cache.Get(s)
+func (i *InMemoryStore) Get(_ context.Context, key string) (any, error) {
+	a, ok := i.Cache.Get(key)
 	if !ok {
 		return a, ErrNotFound
 	}
@@ -70,42 +128,308 @@ func (i InMemoryStore) Get(s string) (any, error) {
 	return a, nil
 }
 
-func (i InMemoryStore) Set(s string, a any, options *SetOptions) error {
-	var ok bool
-	if options.TTL != nil {
-		ok = i.Cache.SetWithTTL(s, a, 1, *options.TTL)
-	} else {
-		ok = i.Cache.Set(s, a, 1)
+func (i *InMemoryStore) GetMany(_ context.Context, keys []string) (map[string]any, error) {
+	values := make(map[string]any, len(keys))
+	for _, key := range keys {
+		if value, ok := i.Cache.Get(key); ok {
+			values[key] = value
+		}
 	}
 
-	if !ok {
-		return ErrUnableToSet
+	return values, nil
+}
+
+func (i *InMemoryStore) Set(_ context.Context, key string, value any, options *SetOptions) error {
+	unlock := i.lock(key)
+	defer unlock()
+
+	if err := i.set(key, value, options.ttl(), false); err != nil {
+		return err
 	}
 
+	i.index.set(key, options.tags())
+
 	return nil
 }
 
-func (i InMemoryStore) Del(s string) error {
-	i.Cache.Del(s)
+func (i *InMemoryStore) SetMany(ctx context.Context, values map[string]any, options *SetOptions) error {
+	for key, value := range values {
+		if err := i.Set(ctx, key, value, options); err != nil {
+			return fmt.Errorf("unable to set key %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (i *InMemoryStore) SetNX(_ context.Context, key string, value any, options *SetOptions) (bool, error) {
+	unlock := i.lock(key)
+	defer unlock()
+
+	if _, ok := i.Cache.Get(key); ok {
+		return false, nil
+	}
+
+	if err := i.set(key, value, options.ttl(), true); err != nil {
+		return false, err
+	}
+
+	i.index.set(key, options.tags())
+
+	return true, nil
+}
+
+func (i *InMemoryStore) Del(_ context.Context, key string) error {
+	unlock := i.lock(key)
+	defer unlock()
+
+	i.Cache.Del(key)
+	i.index.remove(key)
 
 	return nil
 }
 
-func (i InMemoryStore) Update(s string, a any, option *UpdateOption) error {
-	var ok bool
-	if option.TTL != nil {
-		ok = i.Cache.SetWithTTL(s, a, 1, *option.TTL)
-	} else {
-		ok = i.Cache.Set(s, a, 1)
+func (i *InMemoryStore) DelMany(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		_ = i.Del(ctx, key)
 	}
 
+	return nil
+}
+
+// Update replaces the value of an existing key, keeping its TTL unless UpdateOption.TTL is set.
+// It returns ErrNotFound if the key is missing.
+func (i *InMemoryStore) Update(_ context.Context, key string, value any, option *UpdateOption) error {
+	unlock := i.lock(key)
+	defer unlock()
+
+	ttl, ok := i.Cache.GetTTL(key)
 	if !ok {
+		return ErrNotFound
+	}
+
+	if option != nil && option.TTL != nil {
+		ttl = *option.TTL
+	}
+
+	if err := i.set(key, value, ttl, false); err != nil {
 		return ErrUnableToUpdate
 	}
 
+	i.index.touch(key)
+
 	return nil
 }
 
-func (i InMemoryStore) Name() string {
+func (i *InMemoryStore) Increment(_ context.Context, key string, delta int64) (int64, error) {
+	unlock := i.lock(key)
+	defer unlock()
+
+	var (
+		current int64
+		ttl     time.Duration
+	)
+
+	if value, ok := i.Cache.Get(key); ok {
+		integer, ok := toInt64(value)
+		if !ok {
+			return 0, ErrNotAnInteger
+		}
+
+		current = integer
+		ttl, _ = i.Cache.GetTTL(key)
+	}
+
+	current += delta
+	if err := i.set(key, current, ttl, true); err != nil {
+		return 0, err
+	}
+
+	i.index.touch(key)
+
+	return current, nil
+}
+
+func (i *InMemoryStore) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	return i.Increment(ctx, key, -delta)
+}
+
+func (i *InMemoryStore) CompareAndSwap(_ context.Context, key string, oldValue, newValue any, options *SetOptions) (bool, error) {
+	unlock := i.lock(key)
+	defer unlock()
+
+	current, ok := i.Cache.Get(key)
+	if !ok || !reflect.DeepEqual(current, oldValue) {
+		return false, nil
+	}
+
+	if err := i.set(key, newValue, options.ttl(), true); err != nil {
+		return false, err
+	}
+
+	i.index.set(key, options.tags())
+
+	return true, nil
+}
+
+func (i *InMemoryStore) TTL(_ context.Context, key string) (time.Duration, error) {
+	ttl, ok := i.Cache.GetTTL(key)
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	return ttl, nil
+}
+
+func (i *InMemoryStore) Expire(_ context.Context, key string, ttl time.Duration) error {
+	unlock := i.lock(key)
+	defer unlock()
+
+	value, ok := i.Cache.Get(key)
+	if !ok {
+		return ErrNotFound
+	}
+
+	return i.set(key, value, ttl, true)
+}
+
+func (i *InMemoryStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	return i.DelMany(ctx, i.index.withTags(tags))
+}
+
+func (i *InMemoryStore) DeletePrefix(ctx context.Context, prefix string) error {
+	return i.DelMany(ctx, i.index.withPrefix(prefix))
+}
+
+// OnStop stops the goroutines of ristretto, the store must not be used afterward.
+func (i *InMemoryStore) OnStop(_ context.Context) error {
+	i.index.clear()
+	i.Cache.Close()
+
+	return nil
+}
+
+func (i *InMemoryStore) Name() string {
 	return "in_memory"
 }
+
+// Stats returns the counters of ristretto, they are zero unless InMemoryStoreConfig.Metrics is set.
+func (i *InMemoryStore) Stats() Stats {
+	metrics := i.Cache.Metrics
+
+	return Stats{
+		Hits:         metrics.Hits(),
+		Misses:       metrics.Misses(),
+		KeysAdded:    metrics.KeysAdded(),
+		KeysUpdated:  metrics.KeysUpdated(),
+		KeysEvicted:  metrics.KeysEvicted(),
+		SetsDropped:  metrics.SetsDropped(),
+		SetsRejected: metrics.SetsRejected(),
+		Cost:         int64(metrics.CostAdded() - metrics.CostEvicted()),
+		MaxCost:      i.Cache.MaxCost(),
+	}
+}
+
+// set sets the key with the cost of the value, and waits for ristretto to apply it when wait or
+// InMemoryStoreConfig.ReadYourWrites is set, so the next read of the key sees it.
+func (i *InMemoryStore) set(key string, value any, ttl time.Duration, wait bool) error {
+	if !i.Cache.SetWithTTL(key, value, i.cost(value), ttl) {
+		return ErrUnableToSet
+	}
+
+	if wait || i.config.ReadYourWrites {
+		i.Cache.Wait()
+	}
+
+	return nil
+}
+
+// lock locks the mutex shared by the key and returns the function to unlock it.
+func (i *InMemoryStore) lock(key string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	mu := &i.locks[h.Sum32()%inMemoryStoreLocks]
+	mu.Lock()
+
+	return mu.Unlock
+}
+
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// sizeOf returns the approximate size in bytes of a value, following its pointers, slices and maps.
+func sizeOf(value any) int64 {
+	if value == nil {
+		return 0
+	}
+
+	return sizeOfValue(reflect.ValueOf(value), make(map[uintptr]bool))
+}
+
+func sizeOfValue(v reflect.Value, seen map[uintptr]bool) int64 {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.Pointer()] {
+			return int64(v.Type().Size())
+		}
+
+		seen[v.Pointer()] = true
+
+		return int64(v.Type().Size()) + sizeOfValue(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return int64(v.Type().Size())
+		}
+
+		return int64(v.Type().Size()) + sizeOfValue(v.Elem(), seen)
+	case reflect.String:
+		return int64(v.Type().Size()) + int64(v.Len())
+	case reflect.Slice:
+		size := int64(v.Type().Size())
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return size + int64(v.Cap())
+		}
+
+		for j := 0; j < v.Len(); j++ {
+			size += sizeOfValue(v.Index(j), seen)
+		}
+
+		return size + int64(v.Cap()-v.Len())*int64(v.Type().Elem().Size())
+	case reflect.Array:
+		size := int64(0)
+		for j := 0; j < v.Len(); j++ {
+			size += sizeOfValue(v.Index(j), seen)
+		}
+
+		return size
+	case reflect.Map:
+		size := int64(v.Type().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			size += sizeOfValue(iter.Key(), seen) + sizeOfValue(iter.Value(), seen)
+		}
+
+		return size
+	case reflect.Struct:
+		size := int64(0)
+		for j := 0; j < v.NumField(); j++ {
+			size += sizeOfValue(v.Field(j), seen)
+		}
+
+		// the padding between the fields
+		return max(size, int64(v.Type().Size()))
+	default:
+		return int64(v.Type().Size())
+	}
+}
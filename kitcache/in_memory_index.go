@@ -0,0 +1,155 @@
+package kitcache
+
+import (
+	"github.com/dgraph-io/ristretto"
+	"strings"
+	"sync"
+)
+
+// inMemoryIndex keeps the keys and the tags of an InMemoryStore, as ristretto only keeps the hashes
+// of the keys. The keys evicted, expired or rejected by ristretto are removed through its callbacks.
+type inMemoryIndex struct {
+	mu sync.Mutex
+
+	keyToHash func(key any) (uint64, uint64)
+
+	// keys are the keys by hash
+	keys map[uint64]string
+
+	// tags are the keys by tag, keyTags the tags by key
+	tags    map[string]map[string]struct{}
+	keyTags map[string][]string
+
+	// cleared is set once the cache is cleared, its callbacks must not read the cache then
+	cleared bool
+}
+
+func newInMemoryIndex(keyToHash func(key any) (uint64, uint64)) *inMemoryIndex {
+	return &inMemoryIndex{
+		keyToHash: keyToHash,
+		keys:      make(map[uint64]string),
+		tags:      make(map[string]map[string]struct{}),
+		keyTags:   make(map[string][]string),
+	}
+}
+
+// set adds the key with its tags, replacing its previous tags.
+func (x *inMemoryIndex) set(key string, tags []string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeTags(key)
+	x.keys[x.hash(key)] = key
+
+	if len(tags) == 0 {
+		return
+	}
+
+	x.keyTags[key] = tags
+	for _, tag := range tags {
+		if x.tags[tag] == nil {
+			x.tags[tag] = make(map[string]struct{})
+		}
+
+		x.tags[tag][key] = struct{}{}
+	}
+}
+
+// touch adds the key, keeping its tags.
+func (x *inMemoryIndex) touch(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.keys[x.hash(key)] = key
+}
+
+func (x *inMemoryIndex) remove(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeTags(key)
+	delete(x.keys, x.hash(key))
+}
+
+// withTags returns the keys tagged with any of the tags.
+func (x *inMemoryIndex) withTags(tags []string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var keys []string
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		for key := range x.tags[tag] {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
+
+// withPrefix returns the keys starting with the prefix.
+func (x *inMemoryIndex) withPrefix(prefix string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var keys []string
+	for _, key := range x.keys {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func (x *inMemoryIndex) removeTags(key string) {
+	for _, tag := range x.keyTags[key] {
+		delete(x.tags[tag], key)
+		if len(x.tags[tag]) == 0 {
+			delete(x.tags, tag)
+		}
+	}
+
+	delete(x.keyTags, key)
+}
+
+func (x *inMemoryIndex) hash(key string) uint64 {
+	hash, _ := x.keyToHash(key)
+
+	return hash
+}
+
+// clear removes every key, it must be called before clearing the cache as ristretto calls
+// the callbacks while holding the locks of the cache.
+func (x *inMemoryIndex) clear() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.keys = make(map[uint64]string)
+	x.tags = make(map[string]map[string]struct{})
+	x.keyTags = make(map[string][]string)
+	x.cleared = true
+}
+
+// onRemoved returns a ristretto callback removing the item from the index before calling next,
+// unless the key was set again in the meantime.
+func (x *inMemoryIndex) onRemoved(cache func() *ristretto.Cache, next func(item *ristretto.Item)) func(item *ristretto.Item) {
+	return func(item *ristretto.Item) {
+		x.mu.Lock()
+		if key, ok := x.keys[item.Key]; ok && !x.cleared {
+			if _, exists := cache().GetTTL(key); !exists {
+				x.removeTags(key)
+				delete(x.keys, item.Key)
+			}
+		}
+		x.mu.Unlock()
+
+		if next != nil {
+			next(item)
+		}
+	}
+}
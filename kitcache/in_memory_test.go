@@ -0,0 +1,208 @@
+package kitcache
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestInMemoryStore(t *testing.T) *InMemoryStore {
+	store, err := NewInMemoryStore(InMemoryStoreParams{
+		Config: &InMemoryStoreConfig{NumCounters: 1000, MaxCost: 1 << 20, BufferItems: 64},
+	})
+	require.NoError(t, err)
+
+	return store
+}
+
+func TestInMemoryStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("sets the key only if absent", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+
+		ok, err := store.SetNX(ctx, "key", "first", nil)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = store.SetNX(ctx, "key", "second", nil)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		value, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "first", value)
+	})
+
+	t.Run("increments concurrently", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := store.Increment(ctx, "counter", 2)
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		value, err := store.Decrement(ctx, "counter", 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(99), value)
+
+		require.NoError(t, store.Set(ctx, "string", "value", nil))
+		store.Cache.Wait()
+
+		_, err = store.Increment(ctx, "string", 1)
+		require.ErrorIs(t, err, ErrNotAnInteger)
+	})
+
+	t.Run("swaps the value only if it matches", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+
+		ok, err := store.CompareAndSwap(ctx, "key", "old", "new", nil)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, err = store.SetNX(ctx, "key", "old", nil)
+		require.NoError(t, err)
+
+		ok, err = store.CompareAndSwap(ctx, "key", "other", "new", nil)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		ok, err = store.CompareAndSwap(ctx, "key", "old", "new", nil)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		value, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "new", value)
+	})
+
+	t.Run("manages the TTL", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+
+		_, err := store.TTL(ctx, "key")
+		require.ErrorIs(t, err, ErrNotFound)
+		require.ErrorIs(t, store.Expire(ctx, "key", time.Minute), ErrNotFound)
+
+		_, err = store.SetNX(ctx, "key", "value", nil)
+		require.NoError(t, err)
+
+		ttl, err := store.TTL(ctx, "key")
+		require.NoError(t, err)
+		require.Zero(t, ttl)
+
+		require.NoError(t, store.Expire(ctx, "key", time.Minute))
+
+		ttl, err = store.TTL(ctx, "key")
+		require.NoError(t, err)
+		require.InDelta(t, time.Minute, ttl, float64(time.Second))
+	})
+
+	t.Run("gets and deletes many keys", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+
+		require.NoError(t, store.SetMany(ctx, map[string]any{"a": 1, "b": 2}, nil))
+		store.Cache.Wait()
+
+		values, err := store.GetMany(ctx, []string{"a", "b", "c"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"a": 1, "b": 2}, values)
+
+		require.NoError(t, store.DelMany(ctx, []string{"a", "b"}))
+
+		values, err = store.GetMany(ctx, []string{"a", "b"})
+		require.NoError(t, err)
+		require.Empty(t, values)
+	})
+
+	t.Run("invalidates the keys of a tag and of a prefix", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+
+		require.NoError(t, store.Set(ctx, "product:42:page", "page", NewSetOptions().WithTags("product:42")))
+		require.NoError(t, store.Set(ctx, "product:42:price", 10, NewSetOptions().WithTags("product:42")))
+		require.NoError(t, store.Set(ctx, "product:43:page", "page", NewSetOptions().WithTags("product:43")))
+		require.NoError(t, store.Set(ctx, "user:1", "user", nil))
+		store.Cache.Wait()
+
+		require.NoError(t, store.InvalidateTags(ctx, "product:42"))
+
+		values, err := store.GetMany(ctx, []string{"product:42:page", "product:42:price", "product:43:page", "user:1"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"product:43:page": "page", "user:1": "user"}, values)
+
+		require.NoError(t, store.DeletePrefix(ctx, "product:"))
+
+		values, err = store.GetMany(ctx, []string{"product:43:page", "user:1"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"user:1": "user"}, values)
+	})
+
+	t.Run("removes the expired keys from the index", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+
+		require.NoError(t, store.Set(ctx, "key", "value", NewSetOptions().WithTTL(time.Millisecond).WithTags("tag")))
+		store.Cache.Wait()
+
+		// ristretto removes the expired keys every 2.5 seconds
+		require.Eventually(t, func() bool {
+			return len(store.index.withTags([]string{"tag"})) == 0
+		}, 10*time.Second, 100*time.Millisecond)
+
+		require.Empty(t, store.index.withPrefix(""))
+	})
+
+	t.Run("updates only an existing key and keeps its TTL", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+		cache := NewCache[string](store)
+
+		_, err := cache.Update(ctx, "key", "value", nil)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, cache.Set(ctx, "key", "old", NewSetOptions().WithTTL(time.Minute)))
+		store.Cache.Wait()
+
+		value, err := cache.Update(ctx, "key", "new", nil)
+		require.NoError(t, err)
+		require.Equal(t, "new", value)
+
+		ttl, err := cache.TTL(ctx, "key")
+		require.NoError(t, err)
+		require.InDelta(t, time.Minute, ttl, float64(time.Second))
+	})
+
+	t.Run("reads its writes and counts the cost in bytes", func(t *testing.T) {
+		store, err := NewInMemoryStore(InMemoryStoreParams{
+			Config: &InMemoryStoreConfig{
+				NumCounters: 1000, MaxCost: 1 << 20, BufferItems: 64, ReadYourWrites: true, Metrics: true,
+			},
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			require.NoError(t, store.Set(ctx, key, strings.Repeat("a", 1000), nil))
+
+			_, err := store.Get(ctx, key)
+			require.NoError(t, err)
+		}
+
+		_, err = store.Get(ctx, "missing")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		stats := store.Stats()
+		require.Equal(t, uint64(100), stats.Hits)
+		require.Equal(t, uint64(1), stats.Misses)
+		require.Equal(t, uint64(100), stats.KeysAdded)
+		require.GreaterOrEqual(t, stats.Cost, int64(100*1000))
+		require.Equal(t, int64(1<<20), stats.MaxCost)
+	})
+}
@@ -1,10 +1,13 @@
 package kitcache
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitdi"
 	"go.uber.org/dig"
+	"golang.org/x/sync/singleflight"
 	"time"
 )
 
@@ -12,57 +15,153 @@ var (
 	ErrNotFound       = errors.New("kitcache: key not found")
 	ErrUnableToSet    = errors.New("kitcache: unable to set key")
 	ErrUnableToUpdate = errors.New("kitcache: unable to update key")
+	ErrNotAnInteger   = errors.New("kitcache: value is not an integer")
+	ErrNotSupported   = errors.New("kitcache: operation not supported by the store")
 )
 
 type (
+	// Store is the interface implemented by the cache backends. The operations on a single key
+	// (SetNX, Increment, Decrement, CompareAndSwap) are atomic, the bulk operations are not.
 	Store interface {
-		// Get returns the value associated with the key parameter.
-		Get(string) (any, error)
+		// Get returns the value associated with the key, or ErrNotFound.
+		Get(ctx context.Context, key string) (any, error)
 
-		// Set adds the key-value pair to the Map or updates the value if it's
-		// already present. The key-value pair is passed as a pointer to an
-		// item object.
-		Set(string, any, *SetOptions) error
+		// GetMany returns the values associated with the keys, the keys not found are
+		// missing from the map.
+		GetMany(ctx context.Context, keys []string) (map[string]any, error)
 
-		// Del deletes the key-value pair from the Map.
-		Del(string) error
+		// Set adds the key-value pair or replaces the value if the key is already present.
+		Set(ctx context.Context, key string, value any, opts *SetOptions) error
 
-		// Update attempts to update the key with a new value and returns true if
-		// successful.
-		Update(string, any, *UpdateOption) error
+		// SetMany calls Set for each key-value pair with the same options.
+		SetMany(ctx context.Context, values map[string]any, opts *SetOptions) error
+
+		// SetNX adds the key-value pair only if the key is absent, it returns false if
+		// the key is already present.
+		SetNX(ctx context.Context, key string, value any, opts *SetOptions) (bool, error)
+
+		// Del deletes the key, deleting a missing key is not an error.
+		Del(ctx context.Context, key string) error
+
+		// DelMany deletes the keys.
+		DelMany(ctx context.Context, keys []string) error
+
+		// Update replaces the value of an existing key, it returns ErrNotFound if the key is missing.
+		Update(ctx context.Context, key string, value any, opts *UpdateOption) error
+
+		// Increment adds delta to the integer value of the key and returns the new value.
+		// A missing key is set to delta, a value that is not an integer returns ErrNotAnInteger.
+		// The TTL of the key is kept.
+		Increment(ctx context.Context, key string, delta int64) (int64, error)
+
+		// Decrement subtracts delta from the integer value of the key, see Increment.
+		Decrement(ctx context.Context, key string, delta int64) (int64, error)
+
+		// CompareAndSwap replaces the value of the key with newValue only if its current value
+		// is oldValue, it returns false if the key is missing or holds another value.
+		CompareAndSwap(ctx context.Context, key string, oldValue, newValue any, opts *SetOptions) (bool, error)
+
+		// TTL returns the remaining time-to-live of the key, 0 if the key never expires,
+		// or ErrNotFound.
+		TTL(ctx context.Context, key string) (time.Duration, error)
+
+		// Expire sets the time-to-live of an existing key, a ttl of 0 removes the expiration.
+		// It returns ErrNotFound if the key is missing.
+		Expire(ctx context.Context, key string, ttl time.Duration) error
+
+		// InvalidateTags deletes the keys set with any of the tags, see SetOptions.Tags.
+		InvalidateTags(ctx context.Context, tags ...string) error
+
+		// DeletePrefix deletes the keys starting with the prefix.
+		DeletePrefix(ctx context.Context, prefix string) error
 
 		kitcat.Nameable
 	}
 
 	// Cache is a convenient wrapper around a Store with generics.
 	Cache[V any] interface {
-		// Get returns the value associated with the key parameter.
-		Get(string) (V, error)
+		// Get returns the value associated with the key, or ErrNotFound.
+		Get(ctx context.Context, key string) (V, error)
+
+		// GetMany returns the values associated with the keys, the keys not found are
+		// missing from the map.
+		GetMany(ctx context.Context, keys []string) (map[string]V, error)
+
+		// Set adds the key-value pair or replaces the value if the key is already present.
+		Set(ctx context.Context, key string, value V, opts *SetOptions) error
+
+		// SetMany calls Set for each key-value pair with the same options.
+		SetMany(ctx context.Context, values map[string]V, opts *SetOptions) error
+
+		// SetNX adds the key-value pair only if the key is absent.
+		SetNX(ctx context.Context, key string, value V, opts *SetOptions) (bool, error)
 
-		// Set adds the key-value pair to the Map or updates the value if it's
-		// already present. The key-value pair is passed as a pointer to an
-		// item object.
-		Set(string, V, *SetOptions) error
+		// Del deletes the key.
+		Del(ctx context.Context, key string) error
 
-		// Del deletes the key-value pair from the Map.
-		Del(string) error
+		// DelMany deletes the keys.
+		DelMany(ctx context.Context, keys []string) error
 
-		// Update attempts to update the key with a new value and returns true if
-		// successful.
-		Update(string, V, *UpdateOption) (V, error)
+		// Update replaces the value of an existing key and returns the value stored, it returns
+		// ErrNotFound if the key is missing.
+		Update(ctx context.Context, key string, value V, opts *UpdateOption) (V, error)
+
+		// CompareAndSwap replaces the value of the key with newValue only if its current value
+		// is oldValue.
+		CompareAndSwap(ctx context.Context, key string, oldValue, newValue V, opts *SetOptions) (bool, error)
+
+		// TTL returns the remaining time-to-live of the key, 0 if the key never expires.
+		TTL(ctx context.Context, key string) (time.Duration, error)
+
+		// Expire sets the time-to-live of an existing key.
+		Expire(ctx context.Context, key string, ttl time.Duration) error
+
+		// InvalidateTags deletes the keys set with any of the tags.
+		InvalidateTags(ctx context.Context, tags ...string) error
+
+		// DeletePrefix deletes the keys starting with the prefix, and the not found results
+		// cached by GetOrLoad for them.
+		DeletePrefix(ctx context.Context, prefix string) error
+
+		// GetOrLoad returns the cached value of the key, or calls the loader and caches its result.
+		// Concurrent calls missing the same key share a single call to the loader.
+		GetOrLoad(ctx context.Context, key string, loader Loader[V], opts *LoadOptions) (V, error)
 
 		kitcat.Nameable
 	}
 
+	// StatsProvider is implemented by the stores exposing their counters, like InMemoryStore.
+	StatsProvider interface {
+		Stats() Stats
+	}
+
+	// Stats are the counters of a store since it is created.
+	Stats struct {
+		Hits         uint64
+		Misses       uint64
+		KeysAdded    uint64
+		KeysUpdated  uint64
+		KeysEvicted  uint64
+		SetsDropped  uint64
+		SetsRejected uint64
+
+		// Cost is the total cost of the values in the store, and MaxCost its limit
+		Cost    int64
+		MaxCost int64
+	}
+
 	// SetOptions is used to pass options to the Set method.
 	SetOptions struct {
 		// TTL is the time-to-live for the key-value pair.
 		TTL *time.Duration
+
+		// Tags replace the tags of the key, the keys of a tag are deleted by Store.InvalidateTags.
+		Tags []string
 	}
 
 	// UpdateOption is used to pass options to the Update method.
 	UpdateOption struct {
-		// TTL is the time-to-live for the key-value pair.
+		// TTL is the time-to-live for the key-value pair, the TTL of the key is kept when nil.
 		TTL *time.Duration
 	}
 
@@ -86,6 +185,30 @@ func (o *SetOptions) WithTTL(ttl time.Duration) *SetOptions {
 	return o
 }
 
+func (o *SetOptions) WithTags(tags ...string) *SetOptions {
+	o.Tags = append(o.Tags, tags...)
+
+	return o
+}
+
+// ttl returns the TTL of the options, 0 means no expiration.
+func (o *SetOptions) ttl() time.Duration {
+	if o == nil || o.TTL == nil {
+		return 0
+	}
+
+	return *o.TTL
+}
+
+// tags returns the tags of the options.
+func (o *SetOptions) tags() []string {
+	if o == nil {
+		return nil
+	}
+
+	return o.Tags
+}
+
 func NewUpdateOption() *UpdateOption {
 	return &UpdateOption{}
 }
@@ -96,37 +219,204 @@ func (o *UpdateOption) WithTTL(ttl time.Duration) *UpdateOption {
 	return o
 }
 
-type storeToCache[T any] struct {
-	cache Store
+type (
+	storeToCache[T any] struct {
+		cache Store
+
+		// encoder converts the values to bytes, the values are given as is to the store when nil
+		encoder *encoder
+
+		// group deduplicates the calls to the loaders of GetOrLoad
+		group *singleflight.Group
+	}
+
+	// CacheOption configures a Cache created by NewCache.
+	CacheOption func(*cacheOptions)
+
+	cacheOptions struct {
+		codec             Codec
+		compressThreshold int
+	}
+)
+
+// WithCodec makes the Cache store its values as bytes encoded by the codec, it is required by
+// the stores keeping the values outside the process.
+func WithCodec(codec Codec) CacheOption {
+	return func(options *cacheOptions) {
+		options.codec = codec
+	}
+}
+
+// WithCompression compresses the encoded values of at least threshold bytes, it requires WithCodec.
+func WithCompression(threshold int) CacheOption {
+	return func(options *cacheOptions) {
+		options.compressThreshold = threshold
+	}
 }
 
-func (c storeToCache[T]) Get(s string) (T, error) {
-	get, err := c.cache.Get(s)
-	if errors.Is(err, ErrNotFound) {
+func NewCache[T any](store Store, opts ...CacheOption) Cache[T] {
+	options := &cacheOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	c := &storeToCache[T]{cache: store, group: new(singleflight.Group)}
+	if options.codec != nil {
+		c.encoder = &encoder{codec: options.codec, compressThreshold: options.compressThreshold}
+	}
+
+	return c
+}
+
+// toStore returns the value given to the store.
+func (c storeToCache[T]) toStore(v T) (any, error) {
+	if c.encoder == nil {
+		return v, nil
+	}
+
+	return c.encoder.encode(v)
+}
+
+// fromStore returns the value of the cache from a value returned by the store.
+func (c storeToCache[T]) fromStore(stored any) (T, error) {
+	var v T
+
+	if c.encoder == nil {
+		v, ok := stored.(T)
+		if !ok {
+			return v, fmt.Errorf("%w: got %T, a codec may be missing", ErrUnexpectedType, stored)
+		}
+
+		return v, nil
+	}
+
+	var data []byte
+	switch stored := stored.(type) {
+	case []byte:
+		data = stored
+	case string:
+		data = []byte(stored)
+	default:
+		return v, fmt.Errorf("%w: got %T instead of bytes", ErrUnexpectedType, stored)
+	}
+
+	err := c.encoder.decode(data, &v)
+
+	return v, err
+}
+
+func (c storeToCache[T]) Get(ctx context.Context, key string) (T, error) {
+	get, err := c.cache.Get(ctx, key)
+	if err != nil {
 		return *new(T), err
 	}
 
-	return get.(T), err
+	return c.fromStore(get)
 }
 
-func (c storeToCache[T]) Set(s string, v T, options *SetOptions) error {
-	return c.cache.Set(s, v, options)
+func (c storeToCache[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	values, err := c.cache.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := make(map[string]T, len(values))
+	for key, value := range values {
+		if typed[key], err = c.fromStore(value); err != nil {
+			return nil, fmt.Errorf("unable to get key %s: %w", key, err)
+		}
+	}
+
+	return typed, nil
+}
+
+func (c storeToCache[T]) Set(ctx context.Context, key string, v T, options *SetOptions) error {
+	stored, err := c.toStore(v)
+	if err != nil {
+		return err
+	}
+
+	return c.cache.Set(ctx, key, stored, options)
 }
 
-func (c storeToCache[T]) Del(u string) error {
-	return c.cache.Del(u)
+func (c storeToCache[T]) SetMany(ctx context.Context, values map[string]T, options *SetOptions) error {
+	untyped := make(map[string]any, len(values))
+	for key, value := range values {
+		stored, err := c.toStore(value)
+		if err != nil {
+			return fmt.Errorf("unable to set key %s: %w", key, err)
+		}
+
+		untyped[key] = stored
+	}
+
+	return c.cache.SetMany(ctx, untyped, options)
 }
 
-func (c storeToCache[T]) Update(s string, v T, option *UpdateOption) (T, error) {
-	newT := new(T)
-	err := c.cache.Update(s, v, option)
-	return *newT, err
+func (c storeToCache[T]) SetNX(ctx context.Context, key string, v T, options *SetOptions) (bool, error) {
+	stored, err := c.toStore(v)
+	if err != nil {
+		return false, err
+	}
+
+	return c.cache.SetNX(ctx, key, stored, options)
 }
 
-func (c storeToCache[T]) Name() string {
-	return c.cache.Name()
+func (c storeToCache[T]) Del(ctx context.Context, key string) error {
+	return c.cache.Del(ctx, key)
 }
 
-func NewCache[T any](store Store) Cache[T] {
-	return &storeToCache[T]{cache: store}
+func (c storeToCache[T]) DelMany(ctx context.Context, keys []string) error {
+	return c.cache.DelMany(ctx, keys)
+}
+
+func (c storeToCache[T]) Update(ctx context.Context, key string, v T, option *UpdateOption) (T, error) {
+	stored, err := c.toStore(v)
+	if err != nil {
+		return *new(T), err
+	}
+
+	if err := c.cache.Update(ctx, key, stored, option); err != nil {
+		return *new(T), err
+	}
+
+	return v, nil
+}
+
+func (c storeToCache[T]) CompareAndSwap(ctx context.Context, key string, oldValue, newValue T, options *SetOptions) (bool, error) {
+	oldStored, err := c.toStore(oldValue)
+	if err != nil {
+		return false, err
+	}
+
+	newStored, err := c.toStore(newValue)
+	if err != nil {
+		return false, err
+	}
+
+	return c.cache.CompareAndSwap(ctx, key, oldStored, newStored, options)
+}
+
+func (c storeToCache[T]) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.cache.TTL(ctx, key)
+}
+
+func (c storeToCache[T]) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return c.cache.Expire(ctx, key, ttl)
+}
+
+func (c storeToCache[T]) InvalidateTags(ctx context.Context, tags ...string) error {
+	return c.cache.InvalidateTags(ctx, tags...)
+}
+
+func (c storeToCache[T]) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := c.cache.DeletePrefix(ctx, prefix); err != nil {
+		return err
+	}
+
+	return c.cache.DeletePrefix(ctx, notFoundKeyPrefix+prefix)
+}
+
+func (c storeToCache[T]) Name() string {
+	return c.cache.Name()
 }
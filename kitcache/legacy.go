@@ -0,0 +1,215 @@
+package kitcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// LegacyStore is the Store interface before the context and the bulk and atomic operations,
+// wrap an implementation with NewStoreFromLegacy to use it as a Store.
+type LegacyStore interface {
+	Get(string) (any, error)
+	Set(string, any, *SetOptions) error
+	Del(string) error
+	Update(string, any, *UpdateOption) error
+
+	kitcat.Nameable
+}
+
+// legacyStoreAdapter implements Store over a LegacyStore. The bulk operations loop over the keys
+// and the atomic operations are only atomic within the process. TTL is not supported as a
+// LegacyStore does not expose it, neither are InvalidateTags and DeletePrefix as it cannot list its keys.
+type legacyStoreAdapter struct {
+	store LegacyStore
+
+	mu sync.Mutex
+}
+
+// NewStoreFromLegacy returns a Store calling the LegacyStore, it can be provided with ProvideStore:
+//
+//	kitcache.ProvideStore(func(s *MyStore) kitcache.Store { return kitcache.NewStoreFromLegacy(s) })
+func NewStoreFromLegacy(store LegacyStore) Store {
+	return &legacyStoreAdapter{store: store}
+}
+
+func (a *legacyStoreAdapter) Get(_ context.Context, key string) (any, error) {
+	return a.store.Get(key)
+}
+
+func (a *legacyStoreAdapter) GetMany(_ context.Context, keys []string) (map[string]any, error) {
+	values := make(map[string]any, len(keys))
+	for _, key := range keys {
+		value, err := a.store.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to get key %s: %w", key, err)
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (a *legacyStoreAdapter) Set(_ context.Context, key string, value any, opts *SetOptions) error {
+	return a.store.Set(key, value, orNewSetOptions(opts))
+}
+
+func (a *legacyStoreAdapter) SetMany(_ context.Context, values map[string]any, opts *SetOptions) error {
+	for key, value := range values {
+		if err := a.store.Set(key, value, orNewSetOptions(opts)); err != nil {
+			return fmt.Errorf("unable to set key %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (a *legacyStoreAdapter) SetNX(_ context.Context, key string, value any, opts *SetOptions) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err := a.store.Get(key)
+	if err == nil {
+		return false, nil
+	}
+
+	if !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+
+	if err := a.store.Set(key, value, orNewSetOptions(opts)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (a *legacyStoreAdapter) Del(_ context.Context, key string) error {
+	return a.store.Del(key)
+}
+
+func (a *legacyStoreAdapter) DelMany(_ context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := a.store.Del(key); err != nil {
+			return fmt.Errorf("unable to delete key %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (a *legacyStoreAdapter) Update(_ context.Context, key string, value any, opts *UpdateOption) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.store.Get(key); err != nil {
+		return err
+	}
+
+	if opts == nil {
+		opts = NewUpdateOption()
+	}
+
+	return a.store.Update(key, value, opts)
+}
+
+// Increment does not keep the TTL of the key, a LegacyStore does not expose it.
+func (a *legacyStoreAdapter) Increment(_ context.Context, key string, delta int64) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var current int64
+
+	value, err := a.store.Get(key)
+	switch {
+	case err == nil:
+		integer, ok := toInt64(value)
+		if !ok {
+			return 0, ErrNotAnInteger
+		}
+
+		current = integer
+	case !errors.Is(err, ErrNotFound):
+		return 0, err
+	}
+
+	current += delta
+	if err := a.store.Set(key, current, NewSetOptions()); err != nil {
+		return 0, err
+	}
+
+	return current, nil
+}
+
+func (a *legacyStoreAdapter) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	return a.Increment(ctx, key, -delta)
+}
+
+func (a *legacyStoreAdapter) CompareAndSwap(_ context.Context, key string, oldValue, newValue any, opts *SetOptions) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current, err := a.store.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if !reflect.DeepEqual(current, oldValue) {
+		return false, nil
+	}
+
+	if err := a.store.Set(key, newValue, orNewSetOptions(opts)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (a *legacyStoreAdapter) TTL(_ context.Context, _ string) (time.Duration, error) {
+	return 0, ErrNotSupported
+}
+
+func (a *legacyStoreAdapter) Expire(_ context.Context, key string, ttl time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	value, err := a.store.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return a.store.Set(key, value, NewSetOptions().WithTTL(ttl))
+}
+
+func (a *legacyStoreAdapter) InvalidateTags(_ context.Context, _ ...string) error {
+	return ErrNotSupported
+}
+
+func (a *legacyStoreAdapter) DeletePrefix(_ context.Context, _ string) error {
+	return ErrNotSupported
+}
+
+func (a *legacyStoreAdapter) Name() string {
+	return a.store.Name()
+}
+
+func orNewSetOptions(opts *SetOptions) *SetOptions {
+	if opts == nil {
+		return NewSetOptions()
+	}
+
+	return opts
+}
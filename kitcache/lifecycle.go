@@ -0,0 +1,56 @@
+package kitcache
+
+import (
+	"context"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitdi"
+	"go.uber.org/dig"
+)
+
+type (
+	// StoreStarter is implemented by the stores connecting to their backend when the
+	// application starts, before the warmers run.
+	StoreStarter interface {
+		OnStart(ctx context.Context) error
+	}
+
+	// StoreStopper is implemented by the stores flushing their values or closing their
+	// connections when the application stops.
+	StoreStopper interface {
+		OnStop(ctx context.Context) error
+	}
+
+	// Warmer fills the current store when the application starts, the kitweb server starts
+	// serving after the warmers returned.
+	Warmer interface {
+		Warmup(ctx context.Context, store Store) error
+		kitcat.Nameable
+	}
+
+	warmers struct {
+		dig.In
+		Warmers []Warmer `group:"kitcache.warmer"`
+	}
+)
+
+func ProvideWarmer(warmer any) *kitdi.Annotation {
+	return kitdi.Annotate(warmer, kitdi.As(new(Warmer)), kitdi.Group("kitcache.warmer"))
+}
+
+// startStore calls the OnStart of the store if it implements StoreStarter.
+func startStore(ctx context.Context, store Store) error {
+	if starter, ok := store.(StoreStarter); ok {
+		return starter.OnStart(ctx)
+	}
+
+	return nil
+}
+
+// stopStore calls the OnStop of the store if it implements StoreStopper.
+func stopStore(ctx context.Context, store Store) error {
+	if stopper, ok := store.(StoreStopper); ok {
+		return stopper.OnStop(ctx)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package kitcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// notFoundKeyPrefix prefixes the keys remembering that a loader returned ErrNotFound
+const notFoundKeyPrefix = "kitcache_not_found:"
+
+type (
+	// Loader returns the value of the key from the source of truth, it returns ErrNotFound
+	// when the value does not exist.
+	Loader[V any] func(ctx context.Context, key string) (V, error)
+
+	// LoadOptions is used to pass options to the GetOrLoad method.
+	LoadOptions struct {
+		// TTL is the time-to-live of the loaded value.
+		TTL *time.Duration
+
+		// StaleTTL is how long the value is still served once TTL is reached, while it is loaded
+		// again in the background. It requires a store supporting Store.TTL.
+		StaleTTL *time.Duration
+
+		// NotFoundTTL is how long an ErrNotFound returned by the loader is cached, the not found
+		// results are not cached when nil.
+		NotFoundTTL *time.Duration
+	}
+)
+
+func NewLoadOptions() *LoadOptions {
+	return &LoadOptions{}
+}
+
+func (o *LoadOptions) WithTTL(ttl time.Duration) *LoadOptions {
+	o.TTL = &ttl
+
+	return o
+}
+
+func (o *LoadOptions) WithStaleTTL(ttl time.Duration) *LoadOptions {
+	o.StaleTTL = &ttl
+
+	return o
+}
+
+func (o *LoadOptions) WithNotFoundTTL(ttl time.Duration) *LoadOptions {
+	o.NotFoundTTL = &ttl
+
+	return o
+}
+
+func (c storeToCache[T]) GetOrLoad(ctx context.Context, key string, loader Loader[T], opts *LoadOptions) (T, error) {
+	if opts == nil {
+		opts = NewLoadOptions()
+	}
+
+	value, err := c.Get(ctx, key)
+	if err == nil {
+		if c.isStale(ctx, key, opts) {
+			go func() {
+				_, _ = c.load(context.WithoutCancel(ctx), key, loader, opts)
+			}()
+		}
+
+		return value, nil
+	}
+
+	if !errors.Is(err, ErrNotFound) {
+		return value, err
+	}
+
+	if opts.NotFoundTTL != nil {
+		if _, err := c.cache.Get(ctx, notFoundKeyPrefix+key); err == nil {
+			return *new(T), ErrNotFound
+		}
+	}
+
+	return c.load(ctx, key, loader, opts)
+}
+
+// load calls the loader once for the concurrent calls on the same key and caches its result.
+// The loader runs without the cancellation of the first caller, shared with the others, each caller
+// stops waiting when its own context is done.
+func (c storeToCache[T]) load(ctx context.Context, key string, loader Loader[T], opts *LoadOptions) (T, error) {
+	callerCtx := ctx
+	ctx = context.WithoutCancel(ctx)
+
+	ch := c.group.DoChan(key, func() (any, error) {
+		value, err := loader(ctx, key)
+		if errors.Is(err, ErrNotFound) && opts.NotFoundTTL != nil {
+			if err := c.cache.Set(ctx, notFoundKeyPrefix+key, true, NewSetOptions().WithTTL(*opts.NotFoundTTL)); err != nil {
+				return value, fmt.Errorf("unable to cache not found key %s: %w", key, err)
+			}
+		}
+
+		if err != nil {
+			return value, err
+		}
+
+		setOptions := NewSetOptions()
+		if opts.TTL != nil {
+			ttl := *opts.TTL
+			if opts.StaleTTL != nil {
+				ttl += *opts.StaleTTL
+			}
+
+			setOptions.WithTTL(ttl)
+		}
+
+		if err := c.Set(ctx, key, value, setOptions); err != nil {
+			return value, fmt.Errorf("unable to cache loaded key %s: %w", key, err)
+		}
+
+		return value, nil
+	})
+
+	select {
+	case <-callerCtx.Done():
+		return *new(T), callerCtx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return *new(T), res.Err
+		}
+
+		return res.Val.(T), nil
+	}
+}
+
+// isStale returns true when the remaining TTL of the key is within LoadOptions.StaleTTL, the value
+// is then kept in the store only to be served while it is loaded again.
+func (c storeToCache[T]) isStale(ctx context.Context, key string, opts *LoadOptions) bool {
+	if opts.TTL == nil || opts.StaleTTL == nil {
+		return false
+	}
+
+	ttl, err := c.cache.TTL(ctx, key)
+	if err != nil || ttl == 0 {
+		return false
+	}
+
+	return ttl <= *opts.StaleTTL
+}
@@ -0,0 +1,132 @@
+package kitcache
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache_GetOrLoad(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("calls the loader once for concurrent misses", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+		cache := NewCache[string](store)
+
+		var calls atomic.Int32
+		loader := func(ctx context.Context, key string) (string, error) {
+			calls.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			return "value", nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := cache.GetOrLoad(ctx, "key", loader, nil)
+				require.NoError(t, err)
+				require.Equal(t, "value", value)
+			}()
+		}
+		wg.Wait()
+		store.Cache.Wait()
+
+		value, err := cache.GetOrLoad(ctx, "key", loader, nil)
+		require.NoError(t, err)
+		require.Equal(t, "value", value)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("does not fail the other callers when the first one is canceled", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+		cache := NewCache[string](store)
+
+		started := make(chan struct{})
+		loader := func(ctx context.Context, key string) (string, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+
+			return "value", nil
+		}
+
+		firstCtx, cancel := context.WithCancel(ctx)
+		firstErr := make(chan error, 1)
+		go func() {
+			_, err := cache.GetOrLoad(firstCtx, "key", loader, nil)
+			firstErr <- err
+		}()
+
+		<-started
+
+		second := make(chan string, 1)
+		go func() {
+			value, err := cache.GetOrLoad(ctx, "key", loader, nil)
+			require.NoError(t, err)
+			second <- value
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+
+		require.ErrorIs(t, <-firstErr, context.Canceled)
+		require.Equal(t, "value", <-second)
+	})
+
+	t.Run("caches the not found results", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+		cache := NewCache[string](store)
+
+		var calls atomic.Int32
+		loader := func(ctx context.Context, key string) (string, error) {
+			calls.Add(1)
+			return "", ErrNotFound
+		}
+
+		opts := NewLoadOptions().WithNotFoundTTL(time.Minute)
+
+		_, err := cache.GetOrLoad(ctx, "key", loader, opts)
+		require.ErrorIs(t, err, ErrNotFound)
+		store.Cache.Wait()
+
+		_, err = cache.GetOrLoad(ctx, "key", loader, opts)
+		require.ErrorIs(t, err, ErrNotFound)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("serves the stale value while loading it again", func(t *testing.T) {
+		store := newTestInMemoryStore(t)
+		cache := NewCache[int](store)
+
+		var calls atomic.Int32
+		loader := func(ctx context.Context, key string) (int, error) {
+			return int(calls.Add(1)), nil
+		}
+
+		opts := NewLoadOptions().WithTTL(20 * time.Millisecond).WithStaleTTL(time.Minute)
+
+		value, err := cache.GetOrLoad(ctx, "key", loader, opts)
+		require.NoError(t, err)
+		require.Equal(t, 1, value)
+		store.Cache.Wait()
+
+		time.Sleep(30 * time.Millisecond)
+
+		value, err = cache.GetOrLoad(ctx, "key", loader, opts)
+		require.NoError(t, err)
+		require.Equal(t, 1, value)
+
+		require.Eventually(t, func() bool {
+			value, err := cache.Get(ctx, "key")
+			return err == nil && value == 2
+		}, time.Second, time.Millisecond)
+	})
+}
@@ -2,11 +2,14 @@ package kitcache
 
 import (
 	"context"
+	"fmt"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitdi"
 	"github.com/kitcat-framework/kitcat/kitslog"
 	"github.com/spf13/viper"
+	"go.uber.org/dig"
 	"log/slog"
+	"time"
 )
 
 type Config struct {
@@ -38,6 +41,7 @@ func Module(config *Config, app *kitcat.App) {
 	}
 
 	app.Provides(
+		kitcat.ModuleAnnotation(mod),
 		kitcat.ProvideConfigurableModule(mod),
 		ProvideStore(NewInMemoryStore),
 	)
@@ -49,26 +53,110 @@ func (m *KitCache) Configure(_ context.Context, app *kitcat.App) error {
 	return nil
 }
 
-func (m *KitCache) Priority() uint8 { return 0 }
+// Priority is above the one of the modules using the store in their Configure, e.g. kitlock,
+// and of kitweb so the warmers run before it starts serving.
+func (m *KitCache) Priority() uint8 { return 1 }
 
-func (m *KitCache) setCurrentStore(a *kitcat.App, s stores) error {
-	implementation, err := kitcat.UseImplementation(kitcat.UseImplementationParams[Store]{
+// OnStart starts the current store, then runs the warmers. A warmer failing is logged, the
+// values it did not set are loaded on demand.
+func (m *KitCache) OnStart(ctx context.Context, app *kitcat.App) error {
+	if err := startStore(ctx, m.CurrentStore); err != nil {
+		return fmt.Errorf("unable to start store %s: %w", m.CurrentStore.Name(), err)
+	}
+
+	app.Invoke(func(w warmers) {
+		m.warmup(ctx, w.Warmers)
+	})
+
+	return nil
+}
+
+func (m *KitCache) warmup(ctx context.Context, warmers []Warmer) {
+	for _, warmer := range warmers {
+		start := time.Now()
+
+		if err := warmer.Warmup(ctx, m.CurrentStore); err != nil {
+			m.logger.Error("unable to warm up cache", slog.String("warmer", warmer.Name()), kitslog.Err(err))
+			continue
+		}
+
+		m.logger.Info("cache warmed up",
+			slog.String("warmer", warmer.Name()),
+			slog.Duration("duration", time.Since(start)))
+	}
+}
+
+func (m *KitCache) OnStop(ctx context.Context, _ *kitcat.App) error {
+	return stopStore(ctx, m.CurrentStore)
+}
+
+type tieredStoreParams struct {
+	dig.In
+
+	Config      *TieredStoreConfig
+	Invalidator Invalidator `optional:"true"`
+}
+
+func (m *KitCache) setCurrentStore(a *kitcat.App, s stores, tiered tieredStoreParams) error {
+	if m.Config.StoreName == "tiered" {
+		store, err := m.newTieredStore(s, tiered)
+		if err != nil {
+			return err
+		}
+
+		m.CurrentStore = store
+	} else {
+		implementation, err := m.useStore(m.Config.StoreName, s)
+		if err != nil {
+			return err
+		}
+
+		m.CurrentStore = implementation
+	}
+
+	m.logger.Info("using cache store", slog.String("store", m.CurrentStore.Name()))
+	a.Provides(kitdi.Annotate(m.CurrentStore, kitdi.As(new(Store))))
+
+	return nil
+}
+
+// newTieredStore returns a TieredStore with the in_memory store as near store.
+func (m *KitCache) newTieredStore(s stores, params tieredStoreParams) (*TieredStore, error) {
+	near, err := m.useStore("in_memory", s)
+	if err != nil {
+		return nil, err
+	}
+
+	far, err := m.useStore(params.Config.FarStoreName, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Invalidator == nil {
+		m.logger.Warn("no cache invalidator provided, the near stores of the other instances are not invalidated")
+	}
+
+	return NewTieredStore(near, far, params.Invalidator, params.Config, m.logger)
+}
+
+func (m *KitCache) useStore(name string, s stores) (Store, error) {
+	store, err := kitcat.UseImplementation(kitcat.UseImplementationParams[Store]{
 		ModuleName:                m.Name(),
 		ImplementationTerminology: "store",
-		ConfigImplementationName:  m.Config.StoreName,
+		ConfigImplementationName:  name,
 		Implementations:           s.Stores,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	m.CurrentStore = implementation
-	m.logger.Info("using cache store", slog.String("sender", m.CurrentStore.Name()))
-	a.Provides(kitdi.Annotate(m.CurrentStore, kitdi.As(new(Store))))
+	if store == nil {
+		return nil, fmt.Errorf("%s: store %q not found", m.Name(), name)
+	}
 
-	return nil
+	return store, nil
 }
 
 func (m *KitCache) Name() string {
-	return "kitmail"
+	return "kitcache"
 }
@@ -0,0 +1,404 @@
+package kitcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/spf13/viper"
+	"log/slog"
+	"time"
+)
+
+type Consistency string
+
+const (
+	// ConsistencyWriteThrough writes the values to the near store after the far store
+	ConsistencyWriteThrough Consistency = "write_through"
+
+	// ConsistencyInvalidate deletes the values from the near store after writing them to the far store,
+	// the next read loads them from the far store
+	ConsistencyInvalidate Consistency = "invalidate"
+)
+
+type TieredStoreConfig struct {
+	// FarStoreName is the name of the shared store, e.g. "redis"
+	FarStoreName string `cfg:"far_store_name"`
+
+	// NearTTL is the maximum time-to-live of the values in the near store, it bounds how long a
+	// value can be stale when an invalidation is lost. The values read from the far store are kept
+	// at most for their remaining TTL in the far store
+	NearTTL time.Duration `cfg:"near_ttl"`
+
+	// Consistency is how the near store is updated on writes, see ConsistencyWriteThrough
+	// and ConsistencyInvalidate
+	Consistency Consistency `cfg:"consistency"`
+}
+
+func (c *TieredStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitcache.config_stores.tiered"
+
+	viper.SetDefault(prefix+".far_store_name", "")
+	viper.SetDefault(prefix+".near_ttl", time.Minute)
+	viper.SetDefault(prefix+".consistency", string(ConsistencyWriteThrough))
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal tiered store config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(TieredStoreConfig))
+}
+
+type (
+	// Invalidation is published by a TieredStore when it writes to the far store, so the other
+	// instances evict the values from their near store.
+	Invalidation struct {
+		// Origin is the ID of the TieredStore publishing the invalidation
+		Origin string `json:"origin"`
+
+		Keys     []string `json:"keys,omitempty"`
+		Prefixes []string `json:"prefixes,omitempty"`
+
+		// All is set when every value must be evicted
+		All bool `json:"all,omitempty"`
+	}
+
+	// Invalidator broadcasts the invalidations to every instance, including the publisher.
+	Invalidator interface {
+		Publish(ctx context.Context, invalidation Invalidation) error
+
+		// Subscribe calls the handler for each invalidation published, until the context is done.
+		Subscribe(ctx context.Context, handler func(ctx context.Context, invalidation Invalidation)) error
+	}
+)
+
+// TieredStore is a Store keeping the values of a shared far store (e.g. redis) in a local near
+// store (e.g. in_memory). The reads are served by the near store, and by the far store on a miss.
+// The writes go to the far store first, then are applied to the near store according to the
+// Consistency, and published to the Invalidator so the other instances evict their near copy.
+//
+// The tags of the values read from the far store are unknown, so InvalidateTags evicts every
+// value of the near stores. The near store keeps the values as returned by the far store or as
+// written, use a Cache created with WithCodec so both are the encoded bytes.
+type TieredStore struct {
+	id          string
+	near        Store
+	far         Store
+	invalidator Invalidator
+	config      *TieredStoreConfig
+	logger      *slog.Logger
+
+	cancelFunc context.CancelFunc
+}
+
+// NewTieredStore returns a TieredStore, the invalidator may be nil for a single instance.
+func NewTieredStore(near, far Store, invalidator Invalidator, config *TieredStoreConfig, logger *slog.Logger) (*TieredStore, error) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	s := &TieredStore{
+		id:          uuid.New().String(),
+		near:        near,
+		far:         far,
+		invalidator: invalidator,
+		config:      config,
+		logger:      logger.With(slog.String("store", "tiered")),
+		cancelFunc:  cancelFunc,
+	}
+
+	if invalidator != nil {
+		if err := invalidator.Subscribe(ctx, s.onInvalidation); err != nil {
+			cancelFunc()
+			return nil, fmt.Errorf("unable to subscribe to invalidations: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *TieredStore) Get(ctx context.Context, key string) (any, error) {
+	if value, err := s.near.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	value, err := s.far.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.setNearFromFar(ctx, key, value)
+
+	return value, nil
+}
+
+func (s *TieredStore) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	values, err := s.near.GetMany(ctx, keys)
+	if err != nil {
+		values = make(map[string]any, len(keys))
+	}
+
+	var missing []string
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	farValues, err := s.far.GetMany(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range farValues {
+		values[key] = value
+		s.setNearFromFar(ctx, key, value)
+	}
+
+	return values, nil
+}
+
+func (s *TieredStore) Set(ctx context.Context, key string, value any, opts *SetOptions) error {
+	if err := s.far.Set(ctx, key, value, opts); err != nil {
+		return err
+	}
+
+	s.written(ctx, key, value, opts)
+
+	return nil
+}
+
+func (s *TieredStore) SetMany(ctx context.Context, values map[string]any, opts *SetOptions) error {
+	if err := s.far.SetMany(ctx, values, opts); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		keys = append(keys, key)
+		s.applyNear(ctx, key, value, opts)
+	}
+
+	s.publish(ctx, Invalidation{Keys: keys})
+
+	return nil
+}
+
+func (s *TieredStore) SetNX(ctx context.Context, key string, value any, opts *SetOptions) (bool, error) {
+	ok, err := s.far.SetNX(ctx, key, value, opts)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	s.written(ctx, key, value, opts)
+
+	return true, nil
+}
+
+func (s *TieredStore) Del(ctx context.Context, key string) error {
+	return s.DelMany(ctx, []string{key})
+}
+
+func (s *TieredStore) DelMany(ctx context.Context, keys []string) error {
+	if err := s.far.DelMany(ctx, keys); err != nil {
+		return err
+	}
+
+	s.evict(ctx, Invalidation{Keys: keys})
+
+	return nil
+}
+
+func (s *TieredStore) Update(ctx context.Context, key string, value any, opts *UpdateOption) error {
+	if err := s.far.Update(ctx, key, value, opts); err != nil {
+		return err
+	}
+
+	s.evict(ctx, Invalidation{Keys: []string{key}})
+
+	return nil
+}
+
+func (s *TieredStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	value, err := s.far.Increment(ctx, key, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	s.evict(ctx, Invalidation{Keys: []string{key}})
+
+	return value, nil
+}
+
+func (s *TieredStore) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	value, err := s.far.Decrement(ctx, key, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	s.evict(ctx, Invalidation{Keys: []string{key}})
+
+	return value, nil
+}
+
+func (s *TieredStore) CompareAndSwap(ctx context.Context, key string, oldValue, newValue any, opts *SetOptions) (bool, error) {
+	ok, err := s.far.CompareAndSwap(ctx, key, oldValue, newValue, opts)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	s.written(ctx, key, newValue, opts)
+
+	return true, nil
+}
+
+func (s *TieredStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.far.TTL(ctx, key)
+}
+
+func (s *TieredStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	if err := s.far.Expire(ctx, key, ttl); err != nil {
+		return err
+	}
+
+	s.evict(ctx, Invalidation{Keys: []string{key}})
+
+	return nil
+}
+
+func (s *TieredStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	if err := s.far.InvalidateTags(ctx, tags...); err != nil {
+		return err
+	}
+
+	s.evict(ctx, Invalidation{All: true})
+
+	return nil
+}
+
+func (s *TieredStore) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := s.far.DeletePrefix(ctx, prefix); err != nil {
+		return err
+	}
+
+	s.evict(ctx, Invalidation{Prefixes: []string{prefix}})
+
+	return nil
+}
+
+func (s *TieredStore) Name() string {
+	return "tiered"
+}
+
+// Close stops receiving the invalidations.
+func (s *TieredStore) Close() {
+	s.cancelFunc()
+}
+
+// OnStart starts the far store, then the near store.
+func (s *TieredStore) OnStart(ctx context.Context) error {
+	if err := startStore(ctx, s.far); err != nil {
+		return fmt.Errorf("unable to start far store %s: %w", s.far.Name(), err)
+	}
+
+	if err := startStore(ctx, s.near); err != nil {
+		return fmt.Errorf("unable to start near store %s: %w", s.near.Name(), err)
+	}
+
+	return nil
+}
+
+// OnStop stops receiving the invalidations, then stops the near and far stores.
+func (s *TieredStore) OnStop(ctx context.Context) error {
+	s.Close()
+
+	return errors.Join(stopStore(ctx, s.near), stopStore(ctx, s.far))
+}
+
+// written applies a write of the far store to the near store and publishes it.
+func (s *TieredStore) written(ctx context.Context, key string, value any, opts *SetOptions) {
+	s.applyNear(ctx, key, value, opts)
+	s.publish(ctx, Invalidation{Keys: []string{key}})
+}
+
+func (s *TieredStore) applyNear(ctx context.Context, key string, value any, opts *SetOptions) {
+	if s.config.Consistency == ConsistencyInvalidate {
+		s.evictNear(ctx, Invalidation{Keys: []string{key}})
+		return
+	}
+
+	s.setNear(ctx, key, value, opts)
+}
+
+// setNear keeps the value in the near store, for at most TieredStoreConfig.NearTTL.
+func (s *TieredStore) setNear(ctx context.Context, key string, value any, opts *SetOptions) {
+	nearOpts := NewSetOptions().WithTTL(s.config.NearTTL)
+	if ttl := opts.ttl(); ttl > 0 && (ttl < s.config.NearTTL || s.config.NearTTL <= 0) {
+		nearOpts.WithTTL(ttl)
+	}
+
+	nearOpts.WithTags(opts.tags()...)
+
+	if err := s.near.Set(ctx, key, value, nearOpts); err != nil && !errors.Is(err, ErrUnableToSet) {
+		s.logger.Warn("unable to set key in near store", slog.String("key", key), kitslog.Err(err))
+	}
+}
+
+// setNearFromFar keeps a value read from the far store in the near store, for at most its
+// remaining TTL in the far store so the near copy does not outlive it.
+func (s *TieredStore) setNearFromFar(ctx context.Context, key string, value any) {
+	ttl, err := s.far.TTL(ctx, key)
+	if err != nil {
+		return
+	}
+
+	s.setNear(ctx, key, value, NewSetOptions().WithTTL(ttl))
+}
+
+// evict evicts the values from the near store and publishes the invalidation.
+func (s *TieredStore) evict(ctx context.Context, invalidation Invalidation) {
+	s.evictNear(ctx, invalidation)
+	s.publish(ctx, invalidation)
+}
+
+func (s *TieredStore) evictNear(ctx context.Context, invalidation Invalidation) {
+	var err error
+
+	switch {
+	case invalidation.All:
+		err = s.near.DeletePrefix(ctx, "")
+	default:
+		err = s.near.DelMany(ctx, invalidation.Keys)
+		for _, prefix := range invalidation.Prefixes {
+			err = errors.Join(err, s.near.DeletePrefix(ctx, prefix))
+		}
+	}
+
+	if err != nil {
+		s.logger.Warn("unable to evict keys from near store", kitslog.Err(err))
+	}
+}
+
+func (s *TieredStore) publish(ctx context.Context, invalidation Invalidation) {
+	if s.invalidator == nil {
+		return
+	}
+
+	invalidation.Origin = s.id
+	if err := s.invalidator.Publish(ctx, invalidation); err != nil {
+		s.logger.Error("unable to publish invalidation, other instances may serve stale values until the near ttl",
+			kitslog.Err(err))
+	}
+}
+
+func (s *TieredStore) onInvalidation(ctx context.Context, invalidation Invalidation) {
+	if invalidation.Origin == s.id {
+		return
+	}
+
+	s.evictNear(ctx, invalidation)
+}
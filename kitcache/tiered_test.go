@@ -0,0 +1,153 @@
+package kitcache
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testInvalidator broadcasts the invalidations to the handlers of the process.
+type testInvalidator struct {
+	mu       sync.Mutex
+	handlers []func(ctx context.Context, invalidation Invalidation)
+}
+
+func (i *testInvalidator) Publish(ctx context.Context, invalidation Invalidation) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, handler := range i.handlers {
+		handler(ctx, invalidation)
+	}
+
+	return nil
+}
+
+func (i *testInvalidator) Subscribe(_ context.Context, handler func(ctx context.Context, invalidation Invalidation)) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.handlers = append(i.handlers, handler)
+
+	return nil
+}
+
+func newTestTieredStore(t *testing.T, far Store, invalidator Invalidator, consistency Consistency) (*TieredStore, *InMemoryStore) {
+	near := newTestInMemoryStore(t)
+
+	store, err := NewTieredStore(near, far, invalidator, &TieredStoreConfig{
+		NearTTL:     time.Minute,
+		Consistency: consistency,
+	}, slog.Default())
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	return store, near
+}
+
+func TestTieredStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reads through the far store and keeps the value in the near store", func(t *testing.T) {
+		far := newTestInMemoryStore(t)
+		store, near := newTestTieredStore(t, far, nil, ConsistencyWriteThrough)
+
+		_, err := far.SetNX(ctx, "key", "value", nil)
+		require.NoError(t, err)
+
+		value, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "value", value)
+
+		near.Cache.Wait()
+
+		ttl, err := near.TTL(ctx, "key")
+		require.NoError(t, err)
+		require.InDelta(t, time.Minute, ttl, float64(time.Second))
+	})
+
+	t.Run("keeps the value read from the far store at most for its remaining TTL", func(t *testing.T) {
+		far := newTestInMemoryStore(t)
+		store, near := newTestTieredStore(t, far, nil, ConsistencyWriteThrough)
+
+		_, err := far.SetNX(ctx, "key", "value", NewSetOptions().WithTTL(10*time.Second))
+		require.NoError(t, err)
+		_, err = far.SetNX(ctx, "other", "value", NewSetOptions().WithTTL(10*time.Second))
+		require.NoError(t, err)
+
+		_, err = store.Get(ctx, "key")
+		require.NoError(t, err)
+		_, err = store.GetMany(ctx, []string{"other"})
+		require.NoError(t, err)
+
+		near.Cache.Wait()
+
+		for _, key := range []string{"key", "other"} {
+			ttl, err := near.TTL(ctx, key)
+			require.NoError(t, err)
+			require.InDelta(t, 10*time.Second, ttl, float64(time.Second))
+		}
+	})
+
+	t.Run("evicts the near copies of the other instances on write", func(t *testing.T) {
+		far := newTestInMemoryStore(t)
+		invalidator := &testInvalidator{}
+
+		writer, writerNear := newTestTieredStore(t, far, invalidator, ConsistencyWriteThrough)
+		reader, readerNear := newTestTieredStore(t, far, invalidator, ConsistencyWriteThrough)
+
+		_, err := writer.SetNX(ctx, "key", "first", nil)
+		require.NoError(t, err)
+		writerNear.Cache.Wait()
+
+		value, err := reader.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "first", value)
+		readerNear.Cache.Wait()
+
+		ok, err := writer.CompareAndSwap(ctx, "key", "first", "second", nil)
+		require.NoError(t, err)
+		require.True(t, ok)
+		writerNear.Cache.Wait()
+
+		_, err = readerNear.Get(ctx, "key")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		value, err = writerNear.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "second", value)
+
+		value, err = reader.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "second", value)
+	})
+
+	t.Run("only evicts the near copy with the invalidate consistency", func(t *testing.T) {
+		far := newTestInMemoryStore(t)
+		store, near := newTestTieredStore(t, far, nil, ConsistencyInvalidate)
+
+		_, err := store.SetNX(ctx, "key", "value", nil)
+		require.NoError(t, err)
+
+		_, err = near.Get(ctx, "key")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		value, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "value", value)
+	})
+	t.Run("stops the near and far stores", func(t *testing.T) {
+		far := newTestInMemoryStore(t)
+		store, near := newTestTieredStore(t, far, nil, ConsistencyWriteThrough)
+
+		require.NoError(t, store.OnStart(ctx))
+		require.NoError(t, store.Set(ctx, "key", "value", nil))
+		require.NoError(t, store.OnStop(ctx))
+
+		require.ErrorIs(t, near.Set(ctx, "key", "value", nil), ErrUnableToSet)
+		require.ErrorIs(t, far.Set(ctx, "key", "value", nil), ErrUnableToSet)
+	})
+}
@@ -28,7 +28,8 @@ type (
 	//
 	// This is to prevent module that require the exported interface to fail requiring dependency
 	//
-	// The higher the priority is, the sooner the module will be configured.
+	// The higher the priority is, the sooner the module will be configured. A Mod is also started
+	// in that order, and stopped in the reverse one.
 	Configurable interface {
 		Configure(ctx context.Context, app *App) error
 		Priority() uint8
@@ -0,0 +1,95 @@
+package kitevent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat/kitreflect"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// BatchErrors reports the result of each Event of a batch, by index in the batch given to
+// ConsumeBatch. The events without error are processed successfully.
+type BatchErrors map[int]error
+
+func (e BatchErrors) Error() string {
+	indexes := make([]int, 0, len(e))
+	for index := range e {
+		indexes = append(indexes, index)
+	}
+
+	sort.Ints(indexes)
+
+	messages := make([]string, len(indexes))
+	for i, index := range indexes {
+		messages[i] = fmt.Sprintf("%d: %s", index, e[index])
+	}
+
+	return "batch errors: " + strings.Join(messages, ", ")
+}
+
+// IsBatchConsumer returns true if the consumer has a method
+//
+//	ConsumeBatch(ctx context.Context, events []T) error
+//
+// where T is the parameter of its Consume method. Stores supporting batches (the postgres store)
+// call ConsumeBatch with up to ConsumerOptions.BatchSize events instead of Consume, the other
+// stores and Producer.ProduceSync keep calling Consume.
+//
+// ConsumeBatch can return BatchErrors to fail some events only, any other error fails the whole batch.
+// The context does not carry the metadata of the events, see MetadataFromContext.
+func IsBatchConsumer(consumer Consumer) bool {
+	batchFunc := reflect.ValueOf(consumer).MethodByName("ConsumeBatch")
+	if batchFunc.Kind() != reflect.Func {
+		return false
+	}
+
+	if !kitreflect.EnsureInOutLength(batchFunc.Type(), 2, 1) ||
+		!kitreflect.EnsureInIsContext(batchFunc.Type()) ||
+		!kitreflect.EnsureOutIsError(batchFunc.Type()) {
+		return false
+	}
+
+	return batchFunc.Type().In(1) == reflect.SliceOf(consumeEventType(consumer))
+}
+
+// CallBatchConsumer calls ConsumeBatch, the events must be of the type of the Consume parameter
+// (see ConsumerEvent).
+func CallBatchConsumer(ctx context.Context, consumer Consumer, events []Event) error {
+	batch := reflect.MakeSlice(reflect.SliceOf(consumeEventType(consumer)), len(events), len(events))
+	for i, event := range events {
+		batch.Index(i).Set(reflect.ValueOf(event))
+	}
+
+	batchFunc := reflect.ValueOf(consumer).MethodByName("ConsumeBatch")
+	ret := batchFunc.Call([]reflect.Value{reflect.ValueOf(ctx), batch})
+
+	if len(ret) > 0 && !ret[0].IsNil() {
+		return ret[0].Interface().(error)
+	}
+
+	return nil
+}
+
+// BatchItemErrors returns the error of each of the size events of a batch from the error
+// returned by ConsumeBatch.
+func BatchItemErrors(err error, size int) []error {
+	errs := make([]error, size)
+	if err == nil {
+		return errs
+	}
+
+	var batchErrors BatchErrors
+	ok := errors.As(err, &batchErrors)
+	for i := range errs {
+		if ok {
+			errs[i] = batchErrors[i]
+		} else {
+			errs[i] = err
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,43 @@
+package kitevent
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testBatchConsumer struct {
+	testConsumer
+	consumeBatch func(ctx context.Context, events []*testEvent) error
+}
+
+func (c testBatchConsumer) ConsumeBatch(ctx context.Context, events []*testEvent) error {
+	return c.consumeBatch(ctx, events)
+}
+
+func TestBatchConsumer(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	consumer := testBatchConsumer{
+		consumeBatch: func(ctx context.Context, events []*testEvent) error {
+			errs := BatchErrors{}
+			for i, event := range events {
+				if event.ID%2 == 0 {
+					errs[i] = errFailed
+				}
+			}
+
+			return errs
+		},
+	}
+
+	require.True(t, IsBatchConsumer(consumer))
+	require.False(t, IsBatchConsumer(testConsumer{}))
+
+	err := CallBatchConsumer(context.Background(), consumer, []Event{&testEvent{ID: 1}, &testEvent{ID: 2}})
+	require.Equal(t, []error{nil, errFailed}, BatchItemErrors(err, 2))
+
+	require.Equal(t, []error{errFailed, errFailed}, BatchItemErrors(errFailed, 2))
+	require.Equal(t, []error{nil, nil}, BatchItemErrors(nil, 2))
+}
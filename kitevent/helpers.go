@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitreflect"
 	"github.com/kitcat-framework/kitcat/kitslog"
@@ -12,6 +13,24 @@ import (
 	"time"
 )
 
+type ctxKey string
+
+const (
+	ctxKeyMetadata ctxKey = "kitevent.metadata"
+)
+
+// ContextWithMetadata returns a context carrying the metadata of the Event being consumed.
+// Stores call it before calling a Consumer.
+func ContextWithMetadata(ctx context.Context, metadata map[string]any) context.Context {
+	return context.WithValue(ctx, ctxKeyMetadata, metadata)
+}
+
+// MetadataFromContext returns the metadata of the Event being consumed, nil outside a Consumer.
+func MetadataFromContext(ctx context.Context) map[string]any {
+	metadata, _ := ctx.Value(ctxKeyMetadata).(map[string]any)
+	return metadata
+}
+
 func IsHandler(handler kitcat.Nameable) bool {
 	handleFunc := reflect.ValueOf(handler).MethodByName("Consume")
 	if handleFunc.Kind() != reflect.Func {
@@ -60,6 +79,76 @@ func PayloadToEvent(handler Consumer, evt []byte) (Event, error) {
 
 }
 
+// IsEnvelopeConsumer returns true if the Consume method of the consumer receives an *Envelope.
+func IsEnvelopeConsumer(consumer Consumer) bool {
+	return consumeEventType(consumer) == reflect.TypeOf((*Envelope)(nil))
+}
+
+// ConsumerEvent converts the event to the type of the Consume parameter of the consumer.
+// It is used when a consumer is subscribed to events of another type (see Subscriber) or when
+// the event is an *Envelope read from a store. The conversion is done through the JSON
+// representation of the event, upcasted to the version of the consumer (see Upcast).
+func ConsumerEvent(consumer Consumer, event Event, metadata map[string]any) (Event, error) {
+	if reflect.TypeOf(event) == consumeEventType(consumer) {
+		return event, nil
+	}
+
+	envelope, ok := event.(*Envelope)
+	if !ok {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+
+		envelopeMetadata := map[string]any{MetadataKeyVersion: event.EventName().CurrentVersion()}
+		for key, value := range metadata {
+			envelopeMetadata[key] = value
+		}
+
+		envelope = &Envelope{
+			Name:     event.EventName(),
+			Payload:  payload,
+			Metadata: envelopeMetadata,
+		}
+	}
+
+	if IsEnvelopeConsumer(consumer) {
+		return envelope, nil
+	}
+
+	return decodeEnvelope(envelope, consumeEventType(consumer).Elem())
+}
+
+// decodeEnvelope decodes the payload of the envelope in a new value of typ, after upcasting
+// it when the envelope is the same event as typ.
+func decodeEnvelope(envelope *Envelope, typ reflect.Type) (Event, error) {
+	val := reflect.New(typ)
+
+	event, ok := val.Interface().(Event)
+	if !ok {
+		return nil, fmt.Errorf("%s does not implement kitevent.Event", val.Type())
+	}
+
+	payload := envelope.Payload
+	if eventName := event.EventName(); eventName.Name == envelope.Name.Name {
+		var err error
+		payload, err = Upcast(eventName, payload, MetadataVersion(envelope.Metadata), eventName.CurrentVersion())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := json.Unmarshal(payload, val.Interface()); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func consumeEventType(consumer Consumer) reflect.Type {
+	return reflect.ValueOf(consumer).MethodByName("Consume").Type().In(1)
+}
+
 type CallConsumerParams struct {
 	Ctx     context.Context
 	Event   Event
@@ -78,30 +167,60 @@ func CallConsumer(p CallConsumerParams) error {
 }
 
 type LocalCallConsumerParams struct {
-	Ctx           context.Context
-	Event         Event
-	Producer      Producer
-	Opts          *ProducerOptions
-	Consumer      Consumer
-	Logger        *slog.Logger
+	Ctx      context.Context
+	Event    Event
+	Producer Producer
+	Opts     *ProducerOptions
+	Consumer Consumer
+	Logger   *slog.Logger
+
+	// IsProduceSync retries the Consumer immediately instead of producing the Event again, Opts
+	// holds the retry count of the Consumer so it must not be shared with the other Consumers
 	IsProduceSync bool
+
+	// OnDeadLetter is called when the Event will not be processed by the Consumer, it is optional
+	OnDeadLetter func(deadLetter DeadLetter)
 }
 
 func LocalCallHandler(p LocalCallConsumerParams) error {
 	produceAgain := func() error {
+		if p.IsProduceSync {
+			// producing the Event again would call every Consumer of the Event again
+			return LocalCallHandler(p)
+		}
+
 		if p.Consumer.Options().RetryInterval != nil {
 			p.Opts.WithProduceAt(time.Now().Add(*p.Consumer.Options().RetryInterval))
 		}
 
-		if p.IsProduceSync {
-			return p.Producer.ProduceSync(p.Ctx, p.Event, p.Opts)
-		} else {
-			return p.Producer.Produce(p.Ctx, p.Event, p.Opts)
+		return p.Producer.Produce(p.Ctx, p.Event, p.Opts)
+	}
+
+	deadLetter := func(err error) {
+		if p.OnDeadLetter != nil {
+			p.OnDeadLetter(DeadLetter{
+				Event:        p.Event,
+				ConsumerName: p.Consumer.Name(),
+				Err:          err,
+				At:           time.Now(),
+			})
 		}
 	}
 
+	event, err := ConsumerEvent(p.Consumer, p.Event, p.Opts.Metadata)
+	if err != nil {
+		err = fmt.Errorf("unable to convert event for consumer %s: %w", p.Consumer.Name(), err)
+		slog.Error("sending Event to dead letter queue", kitslog.Err(err),
+			slog.String("event_name", p.Event.EventName().Name))
+		deadLetter(err)
+
+		return err
+	}
+
+	ctx := ContextWithMetadata(p.Ctx, p.Opts.Metadata)
+
 	handleFunc := reflect.ValueOf(p.Consumer).MethodByName("Consume")
-	ret := handleFunc.Call([]reflect.Value{reflect.ValueOf(p.Ctx), reflect.ValueOf(p.Event)})
+	ret := handleFunc.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(event)})
 
 	if len(ret) > 0 && !ret[0].IsNil() {
 		err := ret[0].Interface().(error)
@@ -126,6 +245,8 @@ func LocalCallHandler(p LocalCallConsumerParams) error {
 					slog.Int("retry_count", int(retryCount)),
 					slog.Int("max_retry", int(maxRetry)),
 				)
+				deadLetter(err)
+
 				return err
 			}
 		}
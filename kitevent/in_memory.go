@@ -2,84 +2,416 @@ package kitevent
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/spf13/viper"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+type InMemoryEventStoreConfig struct {
+	// Workers is the number of goroutines consuming the queue
+	Workers int `cfg:"workers"`
+
+	// BufferSize is the maximum number of (event, consumer) pairs waiting in the queue
+	BufferSize int `cfg:"buffer_size"`
+
+	// BlockOnFullQueue makes Produce wait for free space in the queue instead of returning ErrQueueFull
+	BlockOnFullQueue bool `cfg:"block_on_full_queue"`
+
+	// TickInterval is the precision of the delays (ProducerOptions.ProduceAt and ConsumerOptions.RetryInterval)
+	TickInterval time.Duration `cfg:"tick_interval"`
+
+	// WheelSize is the number of slots of the delay queue, a delay longer than WheelSize*TickInterval
+	// takes several turns of the wheel
+	WheelSize int `cfg:"wheel_size"`
+}
+
+func (c *InMemoryEventStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitevent.config_stores.in_memory"
+
+	viper.SetDefault(prefix+".workers", 10)
+	viper.SetDefault(prefix+".buffer_size", 1000)
+	viper.SetDefault(prefix+".block_on_full_queue", false)
+	viper.SetDefault(prefix+".tick_interval", time.Millisecond*10)
+	viper.SetDefault(prefix+".wheel_size", 512)
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal in memory event store config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(InMemoryEventStoreConfig))
+}
+
+// inMemoryJob is the processing of an event by a consumer, attempt starts at 1
+type inMemoryJob struct {
+	ctx      context.Context
+	event    Event
+	opts     *ProducerOptions
+	consumer Consumer
+	attempt  int32
+}
+
+// InMemoryEventStore processes the events in the current process, it is meant for tests and
+// for events that can be lost on restart. It behaves like the persistent stores:
+//   - each consumer is called in its own job, retried alone up to ConsumerOptions.MaxRetries after
+//     ConsumerOptions.RetryInterval, then sent to the dead letters
+//   - a consumer that does not return before ConsumerOptions.Timeout fails with context.DeadlineExceeded
+//   - the delayed events and retries wait in a timer wheel, not in goroutines
+//   - the events are queued in the order they are produced, a consumer with a Concurrency of 1 (or a
+//     store with a single worker) processes them in this order
+//   - OnStop waits for the queued and running jobs, the delayed ones are dropped
 type InMemoryEventStore struct {
-	handlers map[EventName][]Consumer
-	logger   *slog.Logger
+	consumers *ConsumerRegistry
+	logger    *slog.Logger
+	config    *InMemoryEventStoreConfig
+
+	queue   chan inMemoryJob
+	queueMu sync.Mutex
+
+	// overflow holds the due jobs fired while the queue was full, the workers move them to the queue
+	// so the timer wheel never waits for them
+	overflowMu sync.Mutex
+	overflow   []inMemoryJob
+
+	// delays holds the delayed events and the retries until they are due
+	delays *timerWheel[inMemoryJob]
+
+	// pending is the number of accepted jobs not processed yet: queued, running or delayed
+	pending  atomic.Int64
+	stopping atomic.Bool
+
+	// consumerSlots limits the number of events processed in parallel by a consumer,
+	// only consumers with ConsumerOptions.Concurrency have an entry
+	consumerSlotsMu sync.RWMutex
+	consumerSlots   map[string]*inMemorySlots
+
+	deadLettersMu sync.Mutex
+	deadLetters   []DeadLetter
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
 }
 
-func NewInMemoryEventStore(logger *slog.Logger) *InMemoryEventStore {
-	return &InMemoryEventStore{
-		handlers: make(map[EventName][]Consumer),
+func NewInMemoryEventStore(logger *slog.Logger, config *InMemoryEventStoreConfig) *InMemoryEventStore {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	tick := config.TickInterval
+	if tick <= 0 {
+		tick = time.Millisecond * 10
+	}
+
+	wheelSize := config.WheelSize
+	if wheelSize <= 0 {
+		wheelSize = 512
+	}
+
+	p := &InMemoryEventStore{
+		consumers: NewConsumerRegistry(),
 		logger: logger.With(
 			kitslog.Module("kitevent"),
 			slog.String("store", "in-memory")),
+		config:        config,
+		queue:         make(chan inMemoryJob, config.BufferSize),
+		consumerSlots: make(map[string]*inMemorySlots),
+		ctx:           ctx,
+		cancelFunc:    cancelFunc,
 	}
+
+	p.delays = newTimerWheel(tick, wheelSize, p.fire)
+
+	return p
 }
 
 func (p *InMemoryEventStore) AddConsumer(eventName EventName, listener Consumer) {
-	p.handlers[eventName] = append(p.handlers[eventName], listener)
+	p.consumers.Add(eventName, listener)
+
+	if concurrency := listener.Options().Concurrency; concurrency != nil {
+		p.consumerSlotsMu.Lock()
+		defer p.consumerSlotsMu.Unlock()
+
+		if _, ok := p.consumerSlots[listener.Name()]; !ok {
+			p.consumerSlots[listener.Name()] = &inMemorySlots{free: int(*concurrency)}
+		}
+	}
 }
 
 func (p *InMemoryEventStore) Produce(ctx context.Context, event Event, opts *ProducerOptions) error {
+	if p.stopping.Load() {
+		return ErrStoreStopped
+	}
+
 	if opts == nil {
 		opts = NewProducerOptions()
 	}
 
-	handlers, ok := p.handlers[event.EventName()]
-	if !ok {
+	opts.WithEventVersion(event)
+
+	handlers := p.consumers.Get(event.EventName())
+	if len(handlers) == 0 {
 		return nil
 	}
 
-	go func() {
-		if opts != nil && opts.ProduceAt.After(time.Now()) {
-			time.Sleep(time.Until(*opts.ProduceAt))
+	// the jobs outlive the context of the producer, only its values are kept
+	jobCtx := context.WithoutCancel(ctx)
+
+	jobs := make([]inMemoryJob, len(handlers))
+	for i, handler := range handlers {
+		jobs[i] = inMemoryJob{ctx: jobCtx, event: event, opts: opts, consumer: handler, attempt: 1}
+	}
+
+	if opts.ProduceAt != nil && opts.ProduceAt.After(time.Now()) {
+		for _, job := range jobs {
+			p.schedule(*opts.ProduceAt, job)
 		}
 
-		for _, handler := range handlers {
-			_ = LocalCallHandler(LocalCallConsumerParams{
-				Ctx:           ctx,
-				Event:         event,
-				Producer:      p,
-				Opts:          opts,
-				Consumer:      handler,
-				Logger:        p.logger,
-				IsProduceSync: false,
-			})
+		return nil
+	}
+
+	return p.enqueue(ctx, jobs)
+}
+
+// enqueue pushes the jobs to the queue, either all of them or none when the queue is full
+// and InMemoryEventStoreConfig.BlockOnFullQueue is false.
+func (p *InMemoryEventStore) enqueue(ctx context.Context, jobs []inMemoryJob) error {
+	if !p.config.BlockOnFullQueue {
+		p.queueMu.Lock()
+		defer p.queueMu.Unlock()
+
+		if cap(p.queue)-len(p.queue) < len(jobs) {
+			return ErrQueueFull
 		}
-	}()
+
+		p.pending.Add(int64(len(jobs)))
+		for _, job := range jobs {
+			p.queue <- job
+		}
+
+		return nil
+	}
+
+	for _, job := range jobs {
+		p.pending.Add(1)
+
+		select {
+		case p.queue <- job:
+		case <-ctx.Done():
+			p.pending.Add(-1)
+			return ctx.Err()
+		}
+	}
 
 	return nil
 }
 
+// schedule adds the job to the delay queue, it is queued once due by fire.
+// Once the store is stopping, only the jobs already due are accepted.
+func (p *InMemoryEventStore) schedule(at time.Time, job inMemoryJob) {
+	if p.stopping.Load() && at.After(time.Now()) {
+		p.logger.Warn("dropping delayed event, the store is stopping",
+			slog.String("event_name", job.event.EventName().Name),
+			slog.String("consumer", job.consumer.Name()))
+		return
+	}
+
+	p.pending.Add(1)
+	p.delays.Add(at, job)
+}
+
+// fire queues a due job of the delay queue, the job is already accepted and counted as pending.
+// It is called by the timer wheel and never blocks: if the queue is full, the job is added to the
+// overflow, after the jobs already there to keep their order.
+func (p *InMemoryEventStore) fire(job inMemoryJob) {
+	p.overflowMu.Lock()
+	defer p.overflowMu.Unlock()
+
+	if len(p.overflow) == 0 {
+		select {
+		case p.queue <- job:
+			return
+		default:
+		}
+	}
+
+	p.overflow = append(p.overflow, job)
+}
+
+// drainOverflow moves the jobs of the overflow to the queue until it is full.
+func (p *InMemoryEventStore) drainOverflow() {
+	p.overflowMu.Lock()
+	defer p.overflowMu.Unlock()
+
+	for len(p.overflow) > 0 {
+		select {
+		case p.queue <- p.overflow[0]:
+			p.overflow[0] = inMemoryJob{}
+			p.overflow = p.overflow[1:]
+		default:
+			return
+		}
+	}
+}
+
+func (p *InMemoryEventStore) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-p.queue:
+			p.process(job)
+			p.drainOverflow()
+		}
+	}
+}
+
+// process runs the job, or defers it if its consumer has no free slot: the job is then run by the
+// worker releasing the next slot of the consumer, so no worker waits for a busy consumer.
+func (p *InMemoryEventStore) process(job inMemoryJob) {
+	p.consumerSlotsMu.RLock()
+	slots, ok := p.consumerSlots[job.consumer.Name()]
+	p.consumerSlotsMu.RUnlock()
+
+	if !ok {
+		p.run(job)
+		return
+	}
+
+	if !slots.acquire(job) {
+		return
+	}
+
+	for {
+		p.run(job)
+
+		if job, ok = slots.release(); !ok {
+			return
+		}
+	}
+}
+
+func (p *InMemoryEventStore) run(job inMemoryJob) {
+	defer p.pending.Add(-1)
+
+	l := p.logger.With(
+		slog.String("event_name", job.event.EventName().Name),
+		slog.String("consumer", job.consumer.Name()),
+		slog.Int("attempt", int(job.attempt)))
+
+	event, err := ConsumerEvent(job.consumer, job.event, job.opts.Metadata)
+	if err != nil {
+		p.deadLetter(l, job, fmt.Errorf("unable to convert event for consumer %s: %w", job.consumer.Name(), err))
+		return
+	}
+
+	err = callConsumerWithTimeout(job.ctx, job.consumer, event, job.opts.Metadata)
+	if err == nil {
+		return
+	}
+
+	maxRetries := inMemoryMaxRetries(job.consumer)
+	if job.attempt >= maxRetries {
+		l.Error("unable to execute event, reached max retry", kitslog.Err(err),
+			slog.Int("max_retry", int(maxRetries)))
+		p.deadLetter(l, job, err)
+
+		return
+	}
+
+	l.Error("will retry event because consumer gets an error", kitslog.Err(err),
+		slog.Int("max_retry", int(maxRetries)))
+
+	retryAt := time.Now()
+	if retryInterval := job.consumer.Options().RetryInterval; retryInterval != nil {
+		retryAt = retryAt.Add(*retryInterval)
+	}
+
+	job.attempt++
+	p.schedule(retryAt, job)
+}
+
+// ProduceSync calls every consumer of the event in the current goroutine, each of them is retried
+// immediately up to ConsumerOptions.MaxRetries. It returns the errors of the consumers that failed.
 func (p *InMemoryEventStore) ProduceSync(ctx context.Context, event Event, opts *ProducerOptions) error {
 	if opts == nil {
 		opts = NewProducerOptions()
 	}
 
-	handlers, ok := p.handlers[event.EventName()]
+	opts.WithEventVersion(event)
 
-	if !ok {
-		return nil
+	var errs []error
+
+	for _, handler := range p.consumers.Get(event.EventName()) {
+		job := inMemoryJob{ctx: ctx, event: event, opts: opts, consumer: handler, attempt: 1}
+
+		if err := p.processSync(job); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	for _, handler := range handlers {
-		return LocalCallHandler(LocalCallConsumerParams{
-			Ctx:           ctx,
-			Event:         event,
-			Producer:      p,
-			Opts:          opts,
-			Consumer:      handler,
-			Logger:        p.logger,
-			IsProduceSync: true,
-		})
+	return errors.Join(errs...)
+}
+
+func (p *InMemoryEventStore) processSync(job inMemoryJob) error {
+	l := p.logger.With(
+		slog.String("event_name", job.event.EventName().Name),
+		slog.String("consumer", job.consumer.Name()))
+
+	event, err := ConsumerEvent(job.consumer, job.event, job.opts.Metadata)
+	if err != nil {
+		err = fmt.Errorf("unable to convert event for consumer %s: %w", job.consumer.Name(), err)
+		p.deadLetter(l, job, err)
+
+		return err
 	}
 
-	return nil
+	maxRetries := inMemoryMaxRetries(job.consumer)
+
+	for ; ; job.attempt++ {
+		err = callConsumerWithTimeout(job.ctx, job.consumer, event, job.opts.Metadata)
+		if err == nil {
+			return nil
+		}
+
+		if job.attempt >= maxRetries {
+			break
+		}
+
+		l.Error("will retry event because consumer gets an error", kitslog.Err(err),
+			slog.Int("attempt", int(job.attempt)),
+			slog.Int("max_retry", int(maxRetries)))
+	}
+
+	l.Error("unable to execute event, reached max retry", kitslog.Err(err),
+		slog.Int("max_retry", int(maxRetries)))
+	p.deadLetter(l, job, err)
+
+	return err
+}
+
+func (p *InMemoryEventStore) deadLetter(l *slog.Logger, job inMemoryJob, err error) {
+	l.Error("sending event to dead letter queue", kitslog.Err(err))
+
+	p.deadLettersMu.Lock()
+	defer p.deadLettersMu.Unlock()
+
+	p.deadLetters = append(p.deadLetters, DeadLetter{
+		Event:        job.event,
+		ConsumerName: job.consumer.Name(),
+		Err:          err,
+		At:           time.Now(),
+	})
+}
+
+// DeadLetters returns the events that will never be processed by a consumer, since the store is started.
+func (p *InMemoryEventStore) DeadLetters() []DeadLetter {
+	p.deadLettersMu.Lock()
+	defer p.deadLettersMu.Unlock()
+
+	return append([]DeadLetter(nil), p.deadLetters...)
 }
 
 func (p *InMemoryEventStore) Name() string {
@@ -87,9 +419,115 @@ func (p *InMemoryEventStore) Name() string {
 }
 
 func (p *InMemoryEventStore) OnStart(_ context.Context) error {
+	p.delays.Start()
+
+	for i := 0; i < p.config.Workers; i++ {
+		go p.work(p.ctx)
+	}
+
 	return nil
 }
 
-func (p *InMemoryEventStore) OnStop(_ context.Context) error {
+// OnStop stops accepting events and drops the delayed ones, then waits for the queued and running
+// jobs (and their immediate retries) until the context is done.
+func (p *InMemoryEventStore) OnStop(ctx context.Context) error {
+	p.stopping.Store(true)
+
+	if dropped := p.delays.Clear(); len(dropped) > 0 {
+		p.pending.Add(-int64(len(dropped)))
+		p.logger.Warn("dropping delayed events, the store is stopping", slog.Int("count", len(dropped)))
+	}
+
+	defer func() {
+		p.delays.Stop()
+		p.cancelFunc()
+	}()
+
+	ticker := time.NewTicker(p.delays.tick)
+	defer ticker.Stop()
+
+	for p.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unable to drain in-memory event store, %d events left: %w", p.pending.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
 	return nil
 }
+
+// inMemorySlots are the slots of a consumer with a Concurrency, the jobs arriving while they are
+// all taken wait in order for a slot to be released.
+type inMemorySlots struct {
+	mu      sync.Mutex
+	free    int
+	waiting []inMemoryJob
+}
+
+// acquire takes a free slot for the job, or adds the job to the waiting ones and returns false.
+func (s *inMemorySlots) acquire(job inMemoryJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.free == 0 {
+		s.waiting = append(s.waiting, job)
+		return false
+	}
+
+	s.free--
+
+	return true
+}
+
+// release hands the slot over to the first waiting job and returns it, the slot is freed if
+// there is none.
+func (s *inMemorySlots) release() (inMemoryJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.waiting) == 0 {
+		s.free++
+		return inMemoryJob{}, false
+	}
+
+	job := s.waiting[0]
+	s.waiting[0] = inMemoryJob{}
+	s.waiting = s.waiting[1:]
+
+	return job, true
+}
+
+func inMemoryMaxRetries(consumer Consumer) int32 {
+	if maxRetries := consumer.Options().MaxRetries; maxRetries != nil {
+		return *maxRetries
+	}
+
+	return 1
+}
+
+// callConsumerWithTimeout calls the consumer with the metadata in the context, it returns an error
+// wrapping context.DeadlineExceeded if the consumer does not return before ConsumerOptions.Timeout.
+func callConsumerWithTimeout(ctx context.Context, consumer Consumer, event Event, metadata map[string]any) error {
+	ctx = ContextWithMetadata(ctx, metadata)
+
+	timeout := consumer.Options().Timeout
+	if timeout == nil {
+		return CallConsumer(CallConsumerParams{Ctx: ctx, Event: event, Handler: consumer})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- CallConsumer(CallConsumerParams{Ctx: ctx, Event: event, Handler: consumer})
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("consumer %s timeout reached: %w", consumer.Name(), ctx.Err())
+	}
+}
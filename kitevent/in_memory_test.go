@@ -0,0 +1,311 @@
+package kitevent
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	ID int
+}
+
+func (testEvent) EventName() EventName {
+	return NewEventName("test_event")
+}
+
+type testConsumer struct {
+	name    string
+	opts    *ConsumerOptions
+	consume func(ctx context.Context, event *testEvent) error
+}
+
+func (c testConsumer) Consume(ctx context.Context, event *testEvent) error {
+	return c.consume(ctx, event)
+}
+
+func (c testConsumer) Options() *ConsumerOptions {
+	return c.opts
+}
+
+func (c testConsumer) Name() string {
+	if c.name != "" {
+		return c.name
+	}
+
+	return "test_consumer"
+}
+
+func newTestInMemoryEventStore(config *InMemoryEventStoreConfig) *InMemoryEventStore {
+	return NewInMemoryEventStore(slog.Default(), config)
+}
+
+func TestInMemoryEventStore_Produce(t *testing.T) {
+	t.Run("returns ErrQueueFull when the buffer is full", func(t *testing.T) {
+		store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 1})
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			opts:    NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error { return nil },
+		})
+
+		// workers are not started, so nothing is consumed
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+		require.ErrorIs(t, store.Produce(context.Background(), &testEvent{ID: 2}, nil), ErrQueueFull)
+	})
+
+	t.Run("blocks until the context is done when the buffer is full", func(t *testing.T) {
+		store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 1, BlockOnFullQueue: true})
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			opts:    NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error { return nil },
+		})
+
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, store.Produce(ctx, &testEvent{ID: 2}, nil), context.DeadlineExceeded)
+	})
+
+	t.Run("respects consumer concurrency", func(t *testing.T) {
+		store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 4, BufferSize: 10})
+
+		var (
+			running    atomic.Int32
+			maxRunning atomic.Int32
+			done       = make(chan struct{}, 6)
+		)
+
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			opts: NewConsumerOptions().WithConcurrency(2),
+			consume: func(ctx context.Context, event *testEvent) error {
+				current := running.Add(1)
+				for {
+					previous := maxRunning.Load()
+					if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+						break
+					}
+				}
+
+				time.Sleep(20 * time.Millisecond)
+				running.Add(-1)
+				done <- struct{}{}
+
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		defer store.OnStop(context.Background())
+
+		for i := 0; i < 6; i++ {
+			require.NoError(t, store.Produce(context.Background(), &testEvent{ID: i}, nil))
+		}
+
+		for i := 0; i < 6; i++ {
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("consumer was not called")
+			}
+		}
+
+		require.Equal(t, int32(2), maxRunning.Load())
+	})
+
+	t.Run("does not park the workers on a busy consumer", func(t *testing.T) {
+		store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 2, BufferSize: 10})
+
+		release := make(chan struct{})
+		fast := make(chan int, 3)
+
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "slow",
+			opts: NewConsumerOptions().WithConcurrency(1),
+			consume: func(ctx context.Context, event *testEvent) error {
+				<-release
+				return nil
+			},
+		})
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "fast",
+			opts: NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				fast <- event.ID
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		defer store.OnStop(context.Background())
+		defer close(release)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, store.Produce(context.Background(), &testEvent{ID: i}, nil))
+		}
+
+		for i := 0; i < 3; i++ {
+			select {
+			case <-fast:
+			case <-time.After(time.Second):
+				t.Fatal("fast consumer is waiting for the slow one")
+			}
+		}
+	})
+
+	t.Run("does not block the delay queue when the queue is full", func(t *testing.T) {
+		store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 1})
+
+		consumed := make(chan int, 3)
+		consumer := testConsumer{
+			opts: NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				consumed <- event.ID
+				return nil
+			},
+		}
+
+		// workers are not started, the queue is full after the first job
+		for i := 0; i < 3; i++ {
+			store.pending.Add(1)
+			store.fire(inMemoryJob{ctx: context.Background(), event: &testEvent{ID: i},
+				opts: NewProducerOptions(), consumer: consumer, attempt: 1})
+		}
+
+		require.Len(t, store.overflow, 2)
+
+		require.NoError(t, store.OnStart(context.Background()))
+		defer store.OnStop(context.Background())
+
+		for i := 0; i < 3; i++ {
+			select {
+			case id := <-consumed:
+				require.Equal(t, i, id)
+			case <-time.After(time.Second):
+				t.Fatal("overflow was not drained")
+			}
+		}
+	})
+}
+
+func TestInMemoryEventStore_Retry(t *testing.T) {
+	t.Run("retries only the failing consumer after the retry interval", func(t *testing.T) {
+		store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 2, BufferSize: 10, TickInterval: time.Millisecond})
+
+		var failingCalls, otherCalls atomic.Int32
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "failing",
+			opts: NewConsumerOptions().WithMaxRetry(3).WithRetryInterval(20 * time.Millisecond),
+			consume: func(ctx context.Context, event *testEvent) error {
+				if failingCalls.Add(1) < 3 {
+					return errors.New("not yet")
+				}
+
+				return nil
+			},
+		})
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "other",
+			opts: NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				otherCalls.Add(1)
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		defer store.OnStop(context.Background())
+
+		start := time.Now()
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		require.Eventually(t, func() bool {
+			return failingCalls.Load() == 3
+		}, time.Second, time.Millisecond)
+
+		require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+		require.Equal(t, int32(1), otherCalls.Load())
+		require.Empty(t, store.DeadLetters())
+	})
+
+	t.Run("sends the event to the dead letters when the consumer timeout is reached", func(t *testing.T) {
+		store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 10})
+
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			opts: NewConsumerOptions().WithTimeout(20 * time.Millisecond),
+			consume: func(ctx context.Context, event *testEvent) error {
+				<-ctx.Done()
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		defer store.OnStop(context.Background())
+
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		require.Eventually(t, func() bool {
+			return len(store.DeadLetters()) == 1
+		}, time.Second, time.Millisecond)
+
+		require.ErrorIs(t, store.DeadLetters()[0].Err, context.DeadlineExceeded)
+	})
+}
+
+func TestInMemoryEventStore_ProduceSync(t *testing.T) {
+	store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 10})
+
+	var calls atomic.Int32
+	store.AddConsumer(testEvent{}.EventName(), testConsumer{
+		name: "failing",
+		opts: NewConsumerOptions().WithMaxRetry(2),
+		consume: func(ctx context.Context, event *testEvent) error {
+			calls.Add(1)
+			return errors.New("always fails")
+		},
+	})
+	store.AddConsumer(testEvent{}.EventName(), testConsumer{
+		name: "other",
+		opts: NewConsumerOptions(),
+		consume: func(ctx context.Context, event *testEvent) error {
+			calls.Add(1)
+			return nil
+		},
+	})
+
+	err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+	require.ErrorContains(t, err, "always fails")
+	require.Equal(t, int32(3), calls.Load())
+}
+
+func TestInMemoryEventStore_OnStop(t *testing.T) {
+	store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 10})
+
+	var calls atomic.Int32
+	store.AddConsumer(testEvent{}.EventName(), testConsumer{
+		opts: NewConsumerOptions(),
+		consume: func(ctx context.Context, event *testEvent) error {
+			time.Sleep(10 * time.Millisecond)
+			calls.Add(1)
+			return nil
+		},
+	})
+
+	require.NoError(t, store.OnStart(context.Background()))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: i}, nil))
+	}
+
+	require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 3},
+		NewProducerOptions().WithProduceAt(time.Now().Add(time.Hour))))
+
+	require.NoError(t, store.OnStop(context.Background()))
+	require.Equal(t, int32(3), calls.Load())
+	require.ErrorIs(t, store.Produce(context.Background(), &testEvent{ID: 4}, nil), ErrStoreStopped)
+}
@@ -2,6 +2,8 @@ package kitevent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitdi"
@@ -10,10 +12,22 @@ import (
 	"time"
 )
 
+var (
+	// ErrQueueFull is returned by Producer.Produce when the store cannot accept more events
+	ErrQueueFull = errors.New("kitevent: queue is full")
+
+	// ErrStoreStopped is returned by Producer.Produce once the store is stopping
+	ErrStoreStopped = errors.New("kitevent: store is stopped")
+)
+
 type (
 	// EventName is the name of the Event
 	EventName struct {
 		Name string
+
+		// Version is the version of the payload produced for this EventName, see WithVersion.
+		// Consumers are registered by Name only, older payloads are upcasted (see RegisterUpcaster).
+		Version int
 	}
 
 	// Event is the interface that must be implemented by an Event
@@ -33,6 +47,14 @@ type (
 		// The duration that the server will wait for a consumer for any individual event once it has been delivered.
 		// If a consumer don't respond before the timeout, the event will be retried if the MaxRetries is not reached.
 		Timeout *time.Duration
+
+		// Concurrency is the maximum number of events a consumer can process in parallel
+		// If nil, the consumer is only limited by the worker pool of the store
+		Concurrency *int32
+
+		// BatchSize is the maximum number of events given to ConsumeBatch (see IsBatchConsumer)
+		// If nil, the batch size of the store is used
+		BatchSize *int32
 	}
 
 	// ProducerOptions is the options for an Event Producer
@@ -79,6 +101,35 @@ type (
 		Consume(ctx context.Context, event T) error
 	}
 
+	// Subscriber is an optional interface for a Consumer to subscribe to events other than
+	// the one of its Consume parameter: several events, patterns like "order.*", or "*" for all events.
+	//
+	// When implemented, only the returned EventName are subscribed.
+	// A Consumer consuming an *Envelope must implement it.
+	Subscriber interface {
+		Subscriptions() []EventName
+	}
+
+	// Envelope is the raw representation of an Event.
+	// A Consumer with a Consume(ctx context.Context, event *Envelope) error method receives the
+	// events without decoding them, which is useful for generic forwarders or archivers.
+	//
+	// An Envelope can be produced as is, its JSON representation is the Payload.
+	Envelope struct {
+		Name     EventName
+		Payload  json.RawMessage
+		Metadata map[string]any
+	}
+
+	// DeadLetter is an Event that a Consumer will never process, because the max retries is reached
+	// or because it cannot be decoded (see ErrUnknownEventVersion)
+	DeadLetter struct {
+		Event        Event
+		ConsumerName string
+		Err          error
+		At           time.Time
+	}
+
 	Store interface {
 		Producer
 		AddConsumer(eventName EventName, consumer Consumer)
@@ -119,6 +170,16 @@ func (h *ConsumerOptions) WithTimeout(timeout time.Duration) *ConsumerOptions {
 	return h
 }
 
+func (h *ConsumerOptions) WithConcurrency(concurrency int32) *ConsumerOptions {
+	h.Concurrency = &concurrency
+	return h
+}
+
+func (h *ConsumerOptions) WithBatchSize(batchSize int32) *ConsumerOptions {
+	h.BatchSize = &batchSize
+	return h
+}
+
 func NewProducerOptions() *ProducerOptions {
 	return &ProducerOptions{
 		Metadata: map[string]any{
@@ -128,6 +189,10 @@ func NewProducerOptions() *ProducerOptions {
 }
 
 func (p *ProducerOptions) WithMetadata(key string, value any) *ProducerOptions {
+	if p.Metadata == nil {
+		p.Metadata = make(map[string]any)
+	}
+
 	p.Metadata[key] = value
 	return p
 }
@@ -142,6 +207,23 @@ func (p *ProducerOptions) WithAddRetryCount() *ProducerOptions {
 	return p
 }
 
+func (e *Envelope) EventName() EventName {
+	return e.Name
+}
+
+func (e *Envelope) MarshalJSON() ([]byte, error) {
+	if e.Payload == nil {
+		return []byte("null"), nil
+	}
+
+	return e.Payload, nil
+}
+
+// Decode unmarshals the Payload into v.
+func (e *Envelope) Decode(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
 func NewEventName(name string) EventName {
 	return EventName{
 		Name: name,
@@ -2,10 +2,12 @@ package kitevent
 
 import (
 	"context"
+	"errors"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitdi"
 	"github.com/kitcat-framework/kitcat/kitslog"
 	"github.com/spf13/viper"
+	"go.uber.org/dig"
 	"log/slog"
 	"reflect"
 )
@@ -30,6 +32,16 @@ type KitCache struct {
 	logger *slog.Logger
 
 	CurrentStore Store
+
+	// Outbox is only set when an OutboxStorage is provided
+	Outbox *Outbox
+}
+
+type outboxParams struct {
+	dig.In
+
+	Storage OutboxStorage `optional:"true"`
+	Config  *OutboxConfig
 }
 
 func Module(a *kitcat.App, config *Config) {
@@ -47,6 +59,7 @@ func Module(a *kitcat.App, config *Config) {
 
 func (m *KitCache) Configure(_ context.Context, app *kitcat.App) error {
 	app.Invoke(m.setCurrentStore)
+	app.Invoke(m.setOutbox)
 
 	return nil
 }
@@ -56,7 +69,15 @@ func (m *KitCache) Priority() uint8 { return 0 }
 func (m *KitCache) OnStart(ctx context.Context, app *kitcat.App) error {
 	app.Invoke(m.registerHandlers)
 
-	return m.CurrentStore.OnStart(ctx)
+	if err := m.CurrentStore.OnStart(ctx); err != nil {
+		return err
+	}
+
+	if m.Outbox != nil {
+		m.Outbox.Start()
+	}
+
+	return nil
 }
 
 func (m *KitCache) registerHandlers(h consumers) error {
@@ -73,21 +94,44 @@ func (m *KitCache) registerHandlers(h consumers) error {
 			continue
 		}
 
-		eventName := reflect.New(reflect.ValueOf(consumer).
-			MethodByName("Consume").
-			Type().In(1).Elem()).
-			Interface().(Event).
-			EventName()
+		eventNames, err := consumerSubscriptions(consumer)
+		if err != nil {
+			m.logger.Warn("invalid consumer", kitslog.Err(err),
+				slog.String("consumer", reflect.TypeOf(consumer).String()))
+			continue
+		}
+
+		for _, eventName := range eventNames {
+			m.logger.Info("registering consumer",
+				slog.String("consumer", consumer.Name()),
+				slog.String("event", eventName.Name))
+			m.CurrentStore.AddConsumer(eventName, consumer)
+		}
 
-		m.logger.Info("registering consumer",
-			slog.String("consumer", consumer.Name()),
-			slog.String("event", eventName.Name))
-		m.CurrentStore.AddConsumer(eventName, consumer)
+		if m.Outbox != nil && !IsEnvelopeConsumer(consumer) {
+			m.Outbox.RegisterEvent(reflect.New(consumeEventType(consumer).Elem()).Interface().(Event))
+		}
 	}
 
 	return nil
 }
 
+// consumerSubscriptions returns the EventName subscribed by the consumer, the ones of Subscriber
+// if implemented, otherwise the one of its Consume parameter.
+func consumerSubscriptions(consumer Consumer) ([]EventName, error) {
+	if subscriber, ok := consumer.(Subscriber); ok {
+		return subscriber.Subscriptions(), nil
+	}
+
+	if IsEnvelopeConsumer(consumer) {
+		return nil, errors.New("a consumer of *kitevent.Envelope must implement kitevent.Subscriber")
+	}
+
+	event := reflect.New(consumeEventType(consumer).Elem()).Interface().(Event)
+
+	return []EventName{event.EventName()}, nil
+}
+
 func (m *KitCache) setCurrentStore(app *kitcat.App, st stores) error {
 	store, err := kitcat.UseImplementation(kitcat.UseImplementationParams[Store]{
 		ModuleName:                m.Name(),
@@ -107,7 +151,22 @@ func (m *KitCache) setCurrentStore(app *kitcat.App, st stores) error {
 	return nil
 }
 
+func (m *KitCache) setOutbox(app *kitcat.App, p outboxParams) {
+	if p.Storage == nil {
+		return
+	}
+
+	m.logger.Info("using outbox", slog.String("storage", p.Storage.Name()))
+	m.Outbox = NewOutbox(p.Storage, m.CurrentStore, p.Config, m.logger)
+
+	app.Provides(m.Outbox)
+}
+
 func (m *KitCache) OnStop(ctx context.Context, _ *kitcat.App) error {
+	if m.Outbox != nil {
+		m.Outbox.Stop()
+	}
+
 	return m.CurrentStore.OnStop(ctx)
 }
 
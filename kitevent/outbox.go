@@ -0,0 +1,272 @@
+package kitevent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitdi"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/spf13/viper"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type OutboxConfig struct {
+	// RelayInterval is the interval between two relays when the outbox is empty
+	RelayInterval time.Duration `cfg:"relay_interval"`
+
+	// BatchSize is the maximum number of messages relayed at once
+	BatchSize int `cfg:"batch_size"`
+}
+
+func (c *OutboxConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitevent.outbox"
+
+	viper.SetDefault(prefix+".relay_interval", time.Second)
+	viper.SetDefault(prefix+".batch_size", 100)
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal kitevent outbox config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(OutboxConfig))
+}
+
+type (
+	// OutboxMessage is an Event waiting in the outbox to be relayed to the Store
+	OutboxMessage struct {
+		ID        string
+		EventName EventName
+		Payload   []byte
+		Metadata  map[string]any
+		ProduceAt *time.Time
+		CreatedAt time.Time
+	}
+
+	// OutboxStorage persists the OutboxMessage next to the business data.
+	//
+	// Save must use the transaction carried by the context if there is one, so the message
+	// is committed or rolled back with the business data.
+	// FindUnrelayed must only return committed messages, ordered by creation.
+	// RelayTx calls the function in a transaction carried by its context: FindUnrelayed must lock
+	// the messages it returns within this transaction, skipping the ones locked by another relay,
+	// until MarkRelayed is committed.
+	OutboxStorage interface {
+		Save(ctx context.Context, message *OutboxMessage) error
+		RelayTx(ctx context.Context, fn func(ctx context.Context) error) error
+		FindUnrelayed(ctx context.Context, limit int) ([]*OutboxMessage, error)
+		MarkRelayed(ctx context.Context, ids []string) error
+		kitcat.Nameable
+	}
+
+	// Outbox is a Producer that writes events to an OutboxStorage instead of the Store.
+	// The events are relayed to the Store once committed, at least once.
+	//
+	// It is meant for stores that cannot join the transaction of the business data
+	// (in-memory, brokers ...). The postgres store does not need it.
+	Outbox struct {
+		storage  OutboxStorage
+		producer Producer
+		config   *OutboxConfig
+		logger   *slog.Logger
+
+		mu         sync.RWMutex
+		eventTypes map[string]reflect.Type
+
+		cancelFunc context.CancelFunc
+	}
+)
+
+func ProvideOutboxStorage(storage any) *kitdi.Annotation {
+	return kitdi.Annotate(storage, kitdi.As(new(OutboxStorage)))
+}
+
+func NewOutbox(storage OutboxStorage, producer Producer, config *OutboxConfig, logger *slog.Logger) *Outbox {
+	return &Outbox{
+		storage:  storage,
+		producer: producer,
+		config:   config,
+		logger: logger.With(
+			kitslog.Module("kitevent"),
+			slog.String("outbox", storage.Name())),
+		eventTypes: make(map[string]reflect.Type),
+	}
+}
+
+// RegisterEvent registers the Go type of an Event, used to decode the payload before relaying it.
+// The kitevent module registers the events of every Consumer.
+func (o *Outbox) RegisterEvent(event Event) {
+	typ := reflect.TypeOf(event)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.eventTypes[event.EventName().Name] = typ
+}
+
+// Produce saves the Event in the OutboxStorage, within the transaction of the context if any.
+func (o *Outbox) Produce(ctx context.Context, event Event, opts *ProducerOptions) error {
+	if opts == nil {
+		opts = NewProducerOptions()
+	}
+
+	opts.WithEventVersion(event)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	id, _ := opts.Metadata["id"].(string)
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	return o.storage.Save(ctx, &OutboxMessage{
+		ID:        id,
+		EventName: event.EventName(),
+		Payload:   payload,
+		Metadata:  opts.Metadata,
+		ProduceAt: opts.ProduceAt,
+		CreatedAt: time.Now(),
+	})
+}
+
+// ProduceSync calls the consumers directly, there is nothing to relay.
+func (o *Outbox) ProduceSync(ctx context.Context, event Event, opts *ProducerOptions) error {
+	return o.producer.ProduceSync(ctx, event, opts)
+}
+
+// Relay relays a batch of committed messages to the Producer and returns the number of relayed messages.
+// Messages of unregistered events, of another version than the registered one or that cannot be
+// decoded are relayed as an *Envelope, the store upcasts and converts them for each Consumer.
+//
+// A message that cannot be produced is skipped and stays in the outbox for the next relay, the
+// errors are returned once the other messages of the batch are relayed.
+func (o *Outbox) Relay(ctx context.Context) (int, error) {
+	var (
+		relayed    []string
+		produceErr error
+	)
+
+	err := o.storage.RelayTx(ctx, func(ctx context.Context) error {
+		messages, err := o.storage.FindUnrelayed(ctx, o.config.BatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to find unrelayed messages: %w", err)
+		}
+
+		relayed, produceErr = o.relay(ctx, messages)
+		if len(relayed) == 0 {
+			return nil
+		}
+
+		if err := o.storage.MarkRelayed(ctx, relayed); err != nil {
+			return fmt.Errorf("failed to mark messages as relayed: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(relayed), produceErr
+}
+
+// relay produces the messages and returns the IDs of the produced ones.
+func (o *Outbox) relay(ctx context.Context, messages []*OutboxMessage) ([]string, error) {
+	var errs error
+
+	relayed := make([]string, 0, len(messages))
+	for _, message := range messages {
+		event := o.decode(message)
+
+		opts := NewProducerOptions()
+		for key, value := range message.Metadata {
+			opts.WithMetadata(key, value)
+		}
+
+		if message.ProduceAt != nil {
+			opts.WithProduceAt(*message.ProduceAt)
+		}
+
+		if err := o.producer.Produce(ctx, event, opts); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to relay message %s: %w", message.ID, err))
+			continue
+		}
+
+		relayed = append(relayed, message.ID)
+	}
+
+	return relayed, errs
+}
+
+func (o *Outbox) decode(message *OutboxMessage) Event {
+	envelope := &Envelope{
+		Name:     message.EventName,
+		Payload:  message.Payload,
+		Metadata: message.Metadata,
+	}
+
+	o.mu.RLock()
+	typ, ok := o.eventTypes[message.EventName.Name]
+	o.mu.RUnlock()
+
+	if !ok {
+		return envelope
+	}
+
+	val := reflect.New(typ)
+	event, ok := val.Interface().(Event)
+	if !ok || event.EventName().CurrentVersion() != MetadataVersion(message.Metadata) {
+		return envelope
+	}
+
+	if err := json.Unmarshal(message.Payload, event); err != nil {
+		o.logger.Warn("unable to decode outbox message, relaying it as an envelope", kitslog.Err(err),
+			slog.String("id", message.ID),
+			slog.String("event_name", message.EventName.Name))
+		return envelope
+	}
+
+	return event
+}
+
+// Start relays the messages in background until Stop is called.
+func (o *Outbox) Start() {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.cancelFunc = cancelFunc
+
+	go func() {
+		for {
+			n, err := o.Relay(ctx)
+			if err != nil {
+				o.logger.Error("failed to relay outbox", kitslog.Err(err))
+			}
+
+			if n > 0 {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(o.config.RelayInterval):
+			}
+		}
+	}()
+}
+
+func (o *Outbox) Stop() {
+	if o.cancelFunc != nil {
+		o.cancelFunc()
+	}
+}
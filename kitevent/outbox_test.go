@@ -0,0 +1,157 @@
+package kitevent
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testOutboxStorage struct {
+	mu       sync.Mutex
+	messages []*OutboxMessage
+	relayed  map[string]bool
+}
+
+func (s *testOutboxStorage) Save(_ context.Context, message *OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+func (s *testOutboxStorage) RelayTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (s *testOutboxStorage) FindUnrelayed(_ context.Context, limit int) ([]*OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []*OutboxMessage
+	for _, message := range s.messages {
+		if !s.relayed[message.ID] && len(messages) < limit {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+func (s *testOutboxStorage) MarkRelayed(_ context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.relayed[id] = true
+	}
+
+	return nil
+}
+
+func (s *testOutboxStorage) Name() string {
+	return "test"
+}
+
+// failingProducer fails to produce the events of the IDs, and produces the others to the Producer.
+type failingProducer struct {
+	Producer
+	failing map[int]bool
+}
+
+func (p failingProducer) Produce(ctx context.Context, event Event, opts *ProducerOptions) error {
+	if p.failing[event.(*testEvent).ID] {
+		return errors.New("produce failed")
+	}
+
+	return p.Producer.Produce(ctx, event, opts)
+}
+
+func TestOutbox_Relay(t *testing.T) {
+	store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 10})
+
+	received := make(chan int, 2)
+	store.AddConsumer(testEvent{}.EventName(), testConsumer{
+		opts: NewConsumerOptions(),
+		consume: func(ctx context.Context, event *testEvent) error {
+			received <- event.ID
+			return nil
+		},
+	})
+
+	require.NoError(t, store.OnStart(context.Background()))
+	defer store.OnStop(context.Background())
+
+	storage := &testOutboxStorage{relayed: map[string]bool{}}
+	outbox := NewOutbox(storage, store, &OutboxConfig{BatchSize: 10, RelayInterval: time.Second}, slog.Default())
+	outbox.RegisterEvent(&testEvent{})
+
+	require.NoError(t, outbox.Produce(context.Background(), &testEvent{ID: 1}, nil))
+	require.NoError(t, outbox.Produce(context.Background(), &testEvent{ID: 2}, nil))
+
+	n, err := outbox.Relay(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	for _, expected := range []int{1, 2} {
+		select {
+		case id := <-received:
+			require.Equal(t, expected, id)
+		case <-time.After(time.Second):
+			t.Fatal("event was not relayed")
+		}
+	}
+
+	n, err = outbox.Relay(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestOutbox_RelaySkipsFailingMessages(t *testing.T) {
+	store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 10})
+
+	received := make(chan int, 3)
+	store.AddConsumer(testEvent{}.EventName(), testConsumer{
+		opts: NewConsumerOptions(),
+		consume: func(ctx context.Context, event *testEvent) error {
+			received <- event.ID
+			return nil
+		},
+	})
+
+	require.NoError(t, store.OnStart(context.Background()))
+	defer store.OnStop(context.Background())
+
+	storage := &testOutboxStorage{relayed: map[string]bool{}}
+	producer := failingProducer{Producer: store, failing: map[int]bool{1: true}}
+	outbox := NewOutbox(storage, producer, &OutboxConfig{BatchSize: 10, RelayInterval: time.Second}, slog.Default())
+	outbox.RegisterEvent(&testEvent{})
+
+	for _, id := range []int{1, 2, 3} {
+		require.NoError(t, outbox.Produce(context.Background(), &testEvent{ID: id}, nil))
+	}
+
+	n, err := outbox.Relay(context.Background())
+	require.Error(t, err)
+	require.Equal(t, 2, n)
+
+	for _, expected := range []int{2, 3} {
+		select {
+		case id := <-received:
+			require.Equal(t, expected, id)
+		case <-time.After(time.Second):
+			t.Fatal("event was not relayed")
+		}
+	}
+
+	delete(producer.failing, 1)
+
+	n, err = outbox.Relay(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, 1, <-received)
+}
@@ -0,0 +1,132 @@
+package kitevent
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// ConsumerRegistry keeps the consumers of a Store by EventName, regardless of its version.
+// The EventName can be a pattern (see EventName.IsPattern), in which case the consumer
+// receives every event matching it.
+type ConsumerRegistry struct {
+	mu        sync.RWMutex
+	consumers map[string][]Consumer
+	patterns  map[EventName][]Consumer
+}
+
+func NewConsumerRegistry() *ConsumerRegistry {
+	return &ConsumerRegistry{
+		consumers: make(map[string][]Consumer),
+		patterns:  make(map[EventName][]Consumer),
+	}
+}
+
+func (r *ConsumerRegistry) Add(eventName EventName, consumer Consumer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if eventName.IsPattern() {
+		r.patterns[eventName] = append(r.patterns[eventName], consumer)
+	} else {
+		r.consumers[eventName.Name] = append(r.consumers[eventName.Name], consumer)
+	}
+}
+
+// Get returns the consumers subscribed to the event, either by its name or by a pattern.
+// A consumer subscribed several times to the same event is returned once.
+func (r *ConsumerRegistry) Get(eventName EventName) []Consumer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		consumers []Consumer
+		seen      = make(map[string]bool)
+	)
+
+	add := func(consumer Consumer) {
+		if seen[consumer.Name()] {
+			return
+		}
+
+		seen[consumer.Name()] = true
+		consumers = append(consumers, consumer)
+	}
+
+	for _, consumer := range r.consumers[eventName.Name] {
+		add(consumer)
+	}
+
+	for pattern, patternConsumers := range r.patterns {
+		if !pattern.Match(eventName) {
+			continue
+		}
+
+		for _, consumer := range patternConsumers {
+			add(consumer)
+		}
+	}
+
+	return consumers
+}
+
+// Find returns the consumer named consumerName subscribed to the event.
+func (r *ConsumerRegistry) Find(eventName EventName, consumerName string) (Consumer, bool) {
+	for _, consumer := range r.Get(eventName) {
+		if consumer.Name() == consumerName {
+			return consumer, true
+		}
+	}
+
+	return nil, false
+}
+
+// All returns every registered consumer once.
+func (r *ConsumerRegistry) All() []Consumer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		consumers []Consumer
+		seen      = make(map[string]bool)
+	)
+
+	add := func(eventConsumers []Consumer) {
+		for _, consumer := range eventConsumers {
+			if seen[consumer.Name()] {
+				continue
+			}
+
+			seen[consumer.Name()] = true
+			consumers = append(consumers, consumer)
+		}
+	}
+
+	for _, eventConsumers := range r.consumers {
+		add(eventConsumers)
+	}
+
+	for _, eventConsumers := range r.patterns {
+		add(eventConsumers)
+	}
+
+	return consumers
+}
+
+// IsPattern returns true if the name contains a wildcard, like "order.*" or "*".
+// The syntax is the one of path.Match.
+func (e EventName) IsPattern() bool {
+	return strings.ContainsAny(e.Name, "*?[")
+}
+
+// Match returns true if the event name is equal to e, or matches it when e is a pattern.
+// The version is ignored.
+func (e EventName) Match(eventName EventName) bool {
+	if !e.IsPattern() {
+		return e.Name == eventName.Name
+	}
+
+	matched, err := path.Match(e.Name, eventName.Name)
+
+	return err == nil && matched
+}
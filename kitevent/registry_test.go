@@ -0,0 +1,88 @@
+package kitevent
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEventName_Match(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.paid", false},
+		{"order.*", "order.created", true},
+		{"order.*", "invoice.created", false},
+		{"*", "invoice.created", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, NewEventName(tt.pattern).Match(NewEventName(tt.name)))
+		})
+	}
+}
+
+type testEnvelopeConsumer struct {
+	received chan *Envelope
+}
+
+func (c testEnvelopeConsumer) Consume(_ context.Context, event *Envelope) error {
+	c.received <- event
+	return nil
+}
+
+func (c testEnvelopeConsumer) Subscriptions() []EventName {
+	return []EventName{NewEventName("test_*")}
+}
+
+func (c testEnvelopeConsumer) Options() *ConsumerOptions {
+	return NewConsumerOptions()
+}
+
+func (c testEnvelopeConsumer) Name() string {
+	return "test_envelope_consumer"
+}
+
+func TestConsumerRegistry_Get(t *testing.T) {
+	registry := NewConsumerRegistry()
+	consumer := testEnvelopeConsumer{}
+
+	registry.Add(NewEventName("test_event"), consumer)
+	registry.Add(NewEventName("test_*"), consumer)
+
+	require.Len(t, registry.Get(NewEventName("test_event")), 1)
+	require.Len(t, registry.Get(NewEventName("test_other")), 1)
+	require.Empty(t, registry.Get(NewEventName("other")))
+}
+
+func TestInMemoryEventStore_EnvelopeConsumer(t *testing.T) {
+	store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 10})
+	consumer := testEnvelopeConsumer{received: make(chan *Envelope, 1)}
+
+	for _, eventName := range consumer.Subscriptions() {
+		store.AddConsumer(eventName, consumer)
+	}
+
+	require.NoError(t, store.OnStart(context.Background()))
+	defer store.OnStop(context.Background())
+
+	opts := NewProducerOptions().WithMetadata("source", "test")
+	require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 42}, opts))
+
+	select {
+	case envelope := <-consumer.received:
+		require.Equal(t, testEvent{}.EventName(), envelope.Name)
+		require.Equal(t, "test", envelope.Metadata["source"])
+
+		var event testEvent
+		require.NoError(t, envelope.Decode(&event))
+		require.Equal(t, 42, event.ID)
+	case <-time.After(time.Second):
+		t.Fatal("envelope was not consumed")
+	}
+}
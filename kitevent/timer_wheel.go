@@ -0,0 +1,152 @@
+package kitevent
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// timerWheel is a hashed timing wheel: a ring of slots advanced every tick by a single goroutine.
+	// An item due in n ticks is put in the slot n ahead of the current one, with the number of full
+	// turns to wait, so scheduling is O(1) whatever the number of pending items.
+	//
+	// Items are fired at most one tick late, in the order they were added when due in the same tick.
+	timerWheel[T any] struct {
+		mu    sync.Mutex
+		tick  time.Duration
+		slots [][]*timerWheelItem[T]
+		pos   int
+		len   int
+
+		fire func(item T)
+
+		stop chan struct{}
+		done chan struct{}
+	}
+
+	timerWheelItem[T any] struct {
+		value  T
+		rounds int
+	}
+)
+
+func newTimerWheel[T any](tick time.Duration, size int, fire func(item T)) *timerWheel[T] {
+	return &timerWheel[T]{
+		tick:  tick,
+		slots: make([][]*timerWheelItem[T], size),
+		fire:  fire,
+	}
+}
+
+// Start advances the wheel every tick until Stop is called.
+func (w *timerWheel[T]) Start() {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				for _, value := range w.advance() {
+					w.fire(value)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops advancing the wheel, the items left are never fired.
+func (w *timerWheel[T]) Stop() {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+		w.stop = nil
+	}
+}
+
+// Clear removes and returns the items that were not fired yet.
+func (w *timerWheel[T]) Clear() []T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var remaining []T
+	for i, slot := range w.slots {
+		for _, item := range slot {
+			remaining = append(remaining, item.value)
+		}
+
+		w.slots[i] = nil
+	}
+
+	w.len = 0
+
+	return remaining
+}
+
+// Add schedules the item at the given time, an item already due is fired on the next tick.
+func (w *timerWheel[T]) Add(at time.Time, value T) {
+	ticks := max(int((time.Until(at)+w.tick-1)/w.tick), 1)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	size := len(w.slots)
+
+	// the slot is visited after ticks%size ticks, then every size ticks
+	first := ticks % size
+	if first == 0 {
+		first = size
+	}
+
+	slot := (w.pos + first) % size
+	w.slots[slot] = append(w.slots[slot], &timerWheelItem[T]{value: value, rounds: (ticks - first) / size})
+	w.len++
+}
+
+// Len returns the number of items waiting in the wheel.
+func (w *timerWheel[T]) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.len
+}
+
+// advance moves the wheel to the next slot and returns its due items.
+func (w *timerWheel[T]) advance() []T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pos = (w.pos + 1) % len(w.slots)
+
+	var (
+		due       []T
+		remaining = w.slots[w.pos][:0]
+	)
+
+	for _, item := range w.slots[w.pos] {
+		if item.rounds > 0 {
+			item.rounds--
+			remaining = append(remaining, item)
+			continue
+		}
+
+		due = append(due, item.value)
+	}
+
+	// clear the tail so the fired items can be collected
+	for i := len(remaining); i < len(w.slots[w.pos]); i++ {
+		w.slots[w.pos][i] = nil
+	}
+
+	w.slots[w.pos] = remaining
+	w.len -= len(due)
+
+	return due
+}
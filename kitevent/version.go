@@ -0,0 +1,125 @@
+package kitevent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// MetadataKeyVersion is the metadata key holding the version of the produced Event
+const MetadataKeyVersion = "version"
+
+var (
+	// ErrUnknownEventVersion is returned when a payload cannot be upcasted to the version
+	// expected by a Consumer, the event is sent to the dead letter queue of the store.
+	ErrUnknownEventVersion = errors.New("kitevent: unknown event version")
+)
+
+// Upcaster migrates a payload of an Event from a version to the next one.
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+var (
+	upcastersMu sync.RWMutex
+	upcasters   = make(map[string]map[int]Upcaster)
+)
+
+// RegisterUpcaster registers the function migrating the payload of the event named eventName
+// from the version fromVersion to fromVersion+1. It is generally called in an init function.
+//
+// Upcasters are chained, a payload in version 1 consumed by a Consumer of the version 3 goes
+// through the upcasters registered for the versions 1 and 2.
+func RegisterUpcaster(eventName EventName, fromVersion int, upcaster Upcaster) {
+	upcastersMu.Lock()
+	defer upcastersMu.Unlock()
+
+	if _, ok := upcasters[eventName.Name]; !ok {
+		upcasters[eventName.Name] = make(map[int]Upcaster)
+	}
+
+	upcasters[eventName.Name][fromVersion] = upcaster
+}
+
+// Upcast migrates the payload of the event from the version fromVersion to the version toVersion.
+// ErrUnknownEventVersion is returned if fromVersion is newer than toVersion or if an upcaster is missing.
+func Upcast(eventName EventName, payload json.RawMessage, fromVersion, toVersion int) (json.RawMessage, error) {
+	if fromVersion > toVersion {
+		return nil, fmt.Errorf("%w: %s version %d is newer than %d", ErrUnknownEventVersion,
+			eventName.Name, fromVersion, toVersion)
+	}
+
+	upcastersMu.RLock()
+	defer upcastersMu.RUnlock()
+
+	for version := fromVersion; version < toVersion; version++ {
+		upcaster, ok := upcasters[eventName.Name][version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no upcaster for %s version %d", ErrUnknownEventVersion,
+				eventName.Name, version)
+		}
+
+		var err error
+		payload, err = upcaster(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upcast %s from version %d: %w", eventName.Name, version, err)
+		}
+	}
+
+	return payload, nil
+}
+
+// WithVersion returns the EventName with its version, the version of an EventName is the version
+// of the payload produced by the current code.
+func (e EventName) WithVersion(version int) EventName {
+	e.Version = version
+	return e
+}
+
+// CurrentVersion returns the version of the EventName, an unversioned EventName is in version 1.
+func (e EventName) CurrentVersion() int {
+	if e.Version == 0 {
+		return 1
+	}
+
+	return e.Version
+}
+
+// MetadataVersion returns the version stored in the metadata of an Event, 1 if there is none.
+func MetadataVersion(metadata map[string]any) int {
+	switch version := metadata[MetadataKeyVersion].(type) {
+	case int:
+		return version
+	case int32:
+		return int(version)
+	case int64:
+		return int(version)
+	case float64:
+		return int(version)
+	case json.Number:
+		v, err := version.Int64()
+		if err == nil {
+			return int(v)
+		}
+	}
+
+	return 1
+}
+
+// WithEventVersion stores the version of the event in the metadata, unless it is already set.
+// The version of an *Envelope is the one of its metadata. The metadata is copied before, it may
+// be shared with the caller.
+func (p *ProducerOptions) WithEventVersion(event Event) *ProducerOptions {
+	if _, ok := p.Metadata[MetadataKeyVersion]; ok {
+		return p
+	}
+
+	p.Metadata = maps.Clone(p.Metadata)
+
+	version := event.EventName().CurrentVersion()
+	if envelope, ok := event.(*Envelope); ok {
+		version = MetadataVersion(envelope.Metadata)
+	}
+
+	return p.WithMetadata(MetadataKeyVersion, version)
+}
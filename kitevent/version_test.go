@@ -0,0 +1,119 @@
+package kitevent
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testVersionedEvent struct {
+	FullName string `json:"full_name"`
+}
+
+func (testVersionedEvent) EventName() EventName {
+	return NewEventName("test_versioned_event").WithVersion(3)
+}
+
+type testVersionedConsumer struct {
+	received chan *testVersionedEvent
+}
+
+func (c testVersionedConsumer) Consume(_ context.Context, event *testVersionedEvent) error {
+	c.received <- event
+	return nil
+}
+
+func (c testVersionedConsumer) Options() *ConsumerOptions {
+	return NewConsumerOptions()
+}
+
+func (c testVersionedConsumer) Name() string {
+	return "test_versioned_consumer"
+}
+
+func init() {
+	RegisterUpcaster(testVersionedEvent{}.EventName(), 1, func(payload json.RawMessage) (json.RawMessage, error) {
+		var v1 struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(payload, &v1); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(map[string]string{"first_name": v1.Name})
+	})
+
+	RegisterUpcaster(testVersionedEvent{}.EventName(), 2, func(payload json.RawMessage) (json.RawMessage, error) {
+		var v2 struct {
+			FirstName string `json:"first_name"`
+		}
+		if err := json.Unmarshal(payload, &v2); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(map[string]string{"full_name": v2.FirstName + " Doe"})
+	})
+}
+
+func TestUpcast(t *testing.T) {
+	t.Run("chains upcasters up to the current version", func(t *testing.T) {
+		payload, err := Upcast(testVersionedEvent{}.EventName(), json.RawMessage(`{"name":"John"}`), 1, 3)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"full_name":"John Doe"}`, string(payload))
+	})
+
+	t.Run("fails for a newer version", func(t *testing.T) {
+		_, err := Upcast(testVersionedEvent{}.EventName(), json.RawMessage(`{}`), 4, 3)
+		require.ErrorIs(t, err, ErrUnknownEventVersion)
+	})
+}
+
+func TestInMemoryEventStore_Versions(t *testing.T) {
+	store := newTestInMemoryEventStore(&InMemoryEventStoreConfig{Workers: 1, BufferSize: 10})
+	consumer := testVersionedConsumer{received: make(chan *testVersionedEvent, 1)}
+	store.AddConsumer(testVersionedEvent{}.EventName(), consumer)
+
+	require.NoError(t, store.OnStart(context.Background()))
+	defer store.OnStop(context.Background())
+
+	t.Run("upcasts an old payload", func(t *testing.T) {
+		err := store.Produce(context.Background(), &Envelope{
+			Name:     NewEventName("test_versioned_event"),
+			Payload:  json.RawMessage(`{"name":"John"}`),
+			Metadata: map[string]any{MetadataKeyVersion: 1},
+		}, nil)
+		require.NoError(t, err)
+
+		select {
+		case event := <-consumer.received:
+			require.Equal(t, "John Doe", event.FullName)
+		case <-time.After(time.Second):
+			t.Fatal("event was not consumed")
+		}
+	})
+
+	t.Run("sends an unknown version to the dead letters", func(t *testing.T) {
+		err := store.ProduceSync(context.Background(), &Envelope{
+			Name:     NewEventName("test_versioned_event"),
+			Payload:  json.RawMessage(`{}`),
+			Metadata: map[string]any{MetadataKeyVersion: 4},
+		}, nil)
+		require.ErrorIs(t, err, ErrUnknownEventVersion)
+
+		deadLetters := store.DeadLetters()
+		require.Len(t, deadLetters, 1)
+		require.Equal(t, consumer.Name(), deadLetters[0].ConsumerName)
+	})
+}
+
+func TestProducerOptions_WithEventVersion(t *testing.T) {
+	metadata := map[string]any{"id": "1"}
+	opts := &ProducerOptions{Metadata: metadata}
+
+	opts.WithEventVersion(&testVersionedEvent{})
+
+	require.Equal(t, 3, opts.Metadata[MetadataKeyVersion])
+	require.NotContains(t, metadata, MetadataKeyVersion)
+}
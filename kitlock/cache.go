@@ -0,0 +1,111 @@
+package kitlock
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"time"
+)
+
+const (
+	cacheLockKeyPrefix  = "kitlock:"
+	cacheTokenKeyPrefix = "kitlock_token:"
+)
+
+// cacheLock is the value of the key of a lock, the zero value marks a released lock.
+type cacheLock struct {
+	Owner string `json:"owner"`
+	Token int64  `json:"token"`
+}
+
+// CacheLocker is a Locker built on the atomic operations of a kitcache.Store, it is shared by
+// the instances using the same store (e.g. redis). The fencing tokens are counters of the store,
+// they only increase as long as the store keeps them: use a store without eviction.
+type CacheLocker struct {
+	store kitcache.Store
+	cache kitcache.Cache[cacheLock]
+}
+
+func NewCacheLocker(store kitcache.Store) *CacheLocker {
+	return &CacheLocker{
+		store: store,
+		cache: kitcache.NewCache[cacheLock](store, kitcache.WithCodec(kitcache.JSONCodec{})),
+	}
+}
+
+func (l *CacheLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	token, err := l.store.Increment(ctx, cacheTokenKeyPrefix+key, 1)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get fencing token of lock %s: %w", key, err)
+	}
+
+	value := cacheLock{Owner: uuid.New().String(), Token: token}
+	opts := kitcache.NewSetOptions().WithTTL(ttl)
+
+	acquired, err := l.cache.SetNX(ctx, cacheLockKeyPrefix+key, value, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire lock %s: %w", key, err)
+	}
+
+	if !acquired {
+		// the key of a released lock is kept until its TTL, it can be taken over
+		acquired, err = l.cache.CompareAndSwap(ctx, cacheLockKeyPrefix+key, cacheLock{}, value, opts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to acquire lock %s: %w", key, err)
+		}
+	}
+
+	if !acquired {
+		return nil, ErrNotAcquired
+	}
+
+	return &Lock{
+		Key:       key,
+		Owner:     value.Owner,
+		Token:     token,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// Release marks the lock as released rather than deleting its key, the store has no atomic
+// compare-and-delete.
+func (l *CacheLocker) Release(ctx context.Context, lock *Lock) error {
+	ttl := time.Until(lock.ExpiresAt)
+	if ttl <= 0 {
+		return ErrNotHeld
+	}
+
+	return l.swap(ctx, lock, cacheLock{}, ttl)
+}
+
+func (l *CacheLocker) Extend(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	if err := l.swap(ctx, lock, cacheLock{Owner: lock.Owner, Token: lock.Token}, ttl); err != nil {
+		return err
+	}
+
+	lock.ExpiresAt = time.Now().Add(ttl)
+
+	return nil
+}
+
+func (l *CacheLocker) Name() string {
+	return "cache"
+}
+
+// swap replaces the value of the lock if it is still held.
+func (l *CacheLocker) swap(ctx context.Context, lock *Lock, value cacheLock, ttl time.Duration) error {
+	held := cacheLock{Owner: lock.Owner, Token: lock.Token}
+
+	swapped, err := l.cache.CompareAndSwap(ctx, cacheLockKeyPrefix+lock.Key, held, value,
+		kitcache.NewSetOptions().WithTTL(ttl))
+	if err != nil {
+		return fmt.Errorf("unable to update lock %s: %w", lock.Key, err)
+	}
+
+	if !swapped {
+		return ErrNotHeld
+	}
+
+	return nil
+}
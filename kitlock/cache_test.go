@@ -0,0 +1,70 @@
+package kitlock
+
+import (
+	"context"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) kitcache.Store {
+	store, err := kitcache.NewInMemoryStore(kitcache.InMemoryStoreParams{
+		Config: &kitcache.InMemoryStoreConfig{NumCounters: 1000, MaxCost: 1 << 20, BufferItems: 64, ReadYourWrites: true},
+	})
+	require.NoError(t, err)
+
+	return store
+}
+
+func TestCacheLocker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("grants the lock to a single owner with increasing tokens", func(t *testing.T) {
+		locker := NewCacheLocker(newTestStore(t))
+
+		lock, err := locker.Acquire(ctx, "key", time.Minute)
+		require.NoError(t, err)
+
+		_, err = locker.Acquire(ctx, "key", time.Minute)
+		require.ErrorIs(t, err, ErrNotAcquired)
+
+		require.NoError(t, locker.Extend(ctx, lock, time.Minute))
+		require.NoError(t, locker.Release(ctx, lock))
+		require.ErrorIs(t, locker.Release(ctx, lock), ErrNotHeld)
+		require.ErrorIs(t, locker.Extend(ctx, lock, time.Minute), ErrNotHeld)
+
+		next, err := locker.Acquire(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		require.Greater(t, next.Token, lock.Token)
+	})
+
+	t.Run("expires the lock after its TTL", func(t *testing.T) {
+		locker := NewCacheLocker(newTestStore(t))
+
+		lock, err := locker.Acquire(ctx, "key", 50*time.Millisecond)
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+
+		_, err = locker.Acquire(ctx, "key", time.Minute)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, locker.Release(ctx, lock), ErrNotHeld)
+	})
+}
+
+func TestCheckCacheLockerStore(t *testing.T) {
+	store := newTestStore(t)
+
+	require.Error(t, checkCacheLockerStore(store, &kitcache.TieredStoreConfig{}))
+
+	tiered, err := kitcache.NewTieredStore(store, store, nil, &kitcache.TieredStoreConfig{FarStoreName: "in_memory"},
+		slog.Default())
+	require.NoError(t, err)
+	t.Cleanup(tiered.Close)
+
+	require.Error(t, checkCacheLockerStore(tiered, &kitcache.TieredStoreConfig{FarStoreName: "in_memory"}))
+	require.NoError(t, checkCacheLockerStore(tiered, &kitcache.TieredStoreConfig{FarStoreName: "redis"}))
+}
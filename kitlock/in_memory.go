@@ -0,0 +1,87 @@
+package kitlock
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// InMemoryLocker is a Locker for a single process, the fencing tokens increase across every key.
+type InMemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]Lock
+	token int64
+}
+
+func NewInMemoryLocker() *InMemoryLocker {
+	return &InMemoryLocker{locks: make(map[string]Lock)}
+}
+
+func (l *InMemoryLocker) Acquire(_ context.Context, key string, ttl time.Duration) (*Lock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.locks[key]; ok && time.Now().Before(current.ExpiresAt) {
+		return nil, ErrNotAcquired
+	}
+
+	l.token++
+	lock := Lock{
+		Key:       key,
+		Owner:     uuid.New().String(),
+		Token:     l.token,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	l.locks[key] = lock
+
+	return &lock, nil
+}
+
+func (l *InMemoryLocker) Release(_ context.Context, lock *Lock) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.held(lock) {
+		return ErrNotHeld
+	}
+
+	delete(l.locks, lock.Key)
+
+	return nil
+}
+
+func (l *InMemoryLocker) Extend(_ context.Context, lock *Lock, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.held(lock) {
+		return ErrNotHeld
+	}
+
+	lock.ExpiresAt = time.Now().Add(ttl)
+	l.locks[lock.Key] = *lock
+
+	return nil
+}
+
+func (l *InMemoryLocker) Name() string {
+	return "in_memory"
+}
+
+// held returns whether the lock is the current unexpired lock of its key, the expired locks are
+// removed.
+func (l *InMemoryLocker) held(lock *Lock) bool {
+	current, ok := l.locks[lock.Key]
+	if !ok {
+		return false
+	}
+
+	if !time.Now().Before(current.ExpiresAt) {
+		delete(l.locks, lock.Key)
+		return false
+	}
+
+	return current.Owner == lock.Owner
+}
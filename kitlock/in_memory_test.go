@@ -0,0 +1,63 @@
+package kitlock
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestInMemoryLocker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("grants the lock to a single owner with increasing tokens", func(t *testing.T) {
+		locker := NewInMemoryLocker()
+
+		lock, err := locker.Acquire(ctx, "key", time.Minute)
+		require.NoError(t, err)
+
+		_, err = locker.Acquire(ctx, "key", time.Minute)
+		require.ErrorIs(t, err, ErrNotAcquired)
+
+		require.NoError(t, locker.Release(ctx, lock))
+		require.ErrorIs(t, locker.Release(ctx, lock), ErrNotHeld)
+
+		next, err := locker.Acquire(ctx, "key", time.Minute)
+		require.NoError(t, err)
+		require.Greater(t, next.Token, lock.Token)
+	})
+
+	t.Run("expires the lock after its TTL unless extended", func(t *testing.T) {
+		locker := NewInMemoryLocker()
+
+		lock, err := locker.Acquire(ctx, "key", 50*time.Millisecond)
+		require.NoError(t, err)
+		require.NoError(t, locker.Extend(ctx, lock, time.Minute))
+
+		time.Sleep(100 * time.Millisecond)
+
+		_, err = locker.Acquire(ctx, "key", time.Minute)
+		require.ErrorIs(t, err, ErrNotAcquired)
+
+		require.NoError(t, locker.Extend(ctx, lock, 10*time.Millisecond))
+		time.Sleep(20 * time.Millisecond)
+
+		require.ErrorIs(t, locker.Extend(ctx, lock, time.Minute), ErrNotHeld)
+
+		_, err = locker.Acquire(ctx, "key", time.Minute)
+		require.NoError(t, err)
+	})
+
+	t.Run("releases the lock after the function", func(t *testing.T) {
+		locker := NewInMemoryLocker()
+
+		err := WithLock(ctx, locker, "key", time.Minute, func(ctx context.Context, lock *Lock) error {
+			_, err := locker.Acquire(ctx, "key", time.Minute)
+			return err
+		})
+		require.ErrorIs(t, err, ErrNotAcquired)
+
+		_, err = locker.Acquire(ctx, "key", time.Minute)
+		require.NoError(t, err)
+	})
+}
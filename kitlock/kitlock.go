@@ -0,0 +1,79 @@
+package kitlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitdi"
+	"go.uber.org/dig"
+	"time"
+)
+
+var (
+	ErrNotAcquired = errors.New("kitlock: lock is held by another owner")
+	ErrNotHeld     = errors.New("kitlock: lock is not held anymore")
+	ErrContention  = errors.New("kitlock: too many concurrent updates")
+)
+
+type (
+	// Locker grants locks on keys to a single owner at a time, until they are released or their
+	// TTL expires.
+	Locker interface {
+		// Acquire locks the key for ttl, it returns ErrNotAcquired without waiting if the key is
+		// locked by another owner.
+		Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+
+		// Release unlocks the key, it returns ErrNotHeld if the lock expired or was released.
+		Release(ctx context.Context, lock *Lock) error
+
+		// Extend sets the remaining time-to-live of the lock to ttl, it returns ErrNotHeld if the
+		// lock expired or was released.
+		Extend(ctx context.Context, lock *Lock, ttl time.Duration) error
+
+		kitcat.Nameable
+	}
+
+	// Lock is a lock granted by a Locker.
+	Lock struct {
+		Key string
+
+		// Owner identifies the acquisition of the lock
+		Owner string
+
+		// Token is the fencing token of the lock, it is greater than the tokens of the previous
+		// locks of the key. Pass it to the resources guarded by the lock so they can reject the
+		// writes of an owner whose lock expired meanwhile.
+		Token int64
+
+		// ExpiresAt is updated by Locker.Extend
+		ExpiresAt time.Time
+	}
+
+	lockers struct {
+		dig.In
+		Lockers []Locker `group:"kitlock.locker"`
+	}
+)
+
+func ProvideLocker(locker any) *kitdi.Annotation {
+	return kitdi.Annotate(locker, kitdi.As(new(Locker)), kitdi.Group("kitlock.locker"))
+}
+
+// WithLock calls fn while holding the lock of the key, it returns ErrNotAcquired if the key is
+// locked by another owner. The lock is released when fn returns, fn must complete within ttl
+// or use Locker.Extend.
+func WithLock(ctx context.Context, locker Locker, key string, ttl time.Duration, fn func(ctx context.Context, lock *Lock) error) error {
+	lock, err := locker.Acquire(ctx, key, ttl)
+	if err != nil {
+		return err
+	}
+
+	fnErr := fn(ctx, lock)
+
+	if err := locker.Release(ctx, lock); err != nil {
+		return errors.Join(fnErr, fmt.Errorf("unable to release lock %s: %w", key, err))
+	}
+
+	return fnErr
+}
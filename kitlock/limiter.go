@@ -0,0 +1,222 @@
+package kitlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	tokenBucketKeyPrefix   = "kitlock_bucket:"
+	slidingWindowKeyPrefix = "kitlock_window:"
+
+	// maxSwapAttempts is the number of compare-and-swap attempts of a limiter before ErrContention
+	maxSwapAttempts = 10
+)
+
+type (
+	// Limiter limits the rate of the events of a key, e.g. the requests of a user.
+	Limiter interface {
+		// Allow is AllowN with n = 1.
+		Allow(ctx context.Context, key string) (*LimitResult, error)
+
+		// AllowN reports whether n events may happen now, the events are only counted if allowed.
+		AllowN(ctx context.Context, key string, n int64) (*LimitResult, error)
+	}
+
+	// Rate is a number of events per period.
+	Rate struct {
+		Limit  int64
+		Period time.Duration
+	}
+
+	LimitResult struct {
+		Allowed bool
+
+		// Remaining is the number of events still allowed now
+		Remaining int64
+
+		// RetryAfter is the time to wait before the events are allowed, when they are not
+		RetryAfter time.Duration
+	}
+
+	// tokenBucket is the state of the bucket of a key.
+	tokenBucket struct {
+		Tokens    float64 `json:"tokens"`
+		UpdatedAt int64   `json:"updated_at"`
+	}
+)
+
+func PerSecond(limit int64) Rate { return Rate{Limit: limit, Period: time.Second} }
+func PerMinute(limit int64) Rate { return Rate{Limit: limit, Period: time.Minute} }
+func PerHour(limit int64) Rate   { return Rate{Limit: limit, Period: time.Hour} }
+
+// perNanosecond returns the number of events of the rate per nanosecond.
+func (r Rate) perNanosecond() float64 {
+	return float64(r.Limit) / float64(r.Period)
+}
+
+// TokenBucketLimiter allows bursts of up to burst events, the bucket is refilled at rate. The
+// state of the buckets is kept in a kitcache.Store and updated with compare-and-swap, so the
+// limit is shared by the instances using the same store.
+type TokenBucketLimiter struct {
+	cache kitcache.Cache[tokenBucket]
+	rate  Rate
+	burst int64
+}
+
+func NewTokenBucketLimiter(store kitcache.Store, rate Rate, burst int64) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		cache: kitcache.NewCache[tokenBucket](store, kitcache.WithCodec(kitcache.JSONCodec{})),
+		rate:  rate,
+		burst: burst,
+	}
+}
+
+func (l *TokenBucketLimiter) Allow(ctx context.Context, key string) (*LimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *TokenBucketLimiter) AllowN(ctx context.Context, key string, n int64) (*LimitResult, error) {
+	key = tokenBucketKeyPrefix + key
+
+	// the bucket expires once full, a missing bucket is a full one
+	ttl := time.Duration(float64(l.burst)/l.rate.perNanosecond()) + time.Second
+	opts := kitcache.NewSetOptions().WithTTL(ttl)
+
+	for attempt := 0; attempt < maxSwapAttempts; attempt++ {
+		now := time.Now()
+
+		current, err := l.cache.Get(ctx, key)
+		exists := err == nil
+		if err != nil && !errors.Is(err, kitcache.ErrNotFound) {
+			return nil, fmt.Errorf("unable to get bucket %s: %w", key, err)
+		}
+
+		tokens := float64(l.burst)
+		if exists {
+			elapsed := now.Sub(time.Unix(0, current.UpdatedAt))
+			tokens = math.Min(tokens, current.Tokens+float64(elapsed)*l.rate.perNanosecond())
+		}
+
+		if tokens < float64(n) {
+			return &LimitResult{
+				Remaining:  int64(tokens),
+				RetryAfter: time.Duration((float64(n) - tokens) / l.rate.perNanosecond()),
+			}, nil
+		}
+
+		next := tokenBucket{Tokens: tokens - float64(n), UpdatedAt: now.UnixNano()}
+
+		var swapped bool
+		if exists {
+			swapped, err = l.cache.CompareAndSwap(ctx, key, current, next, opts)
+		} else {
+			swapped, err = l.cache.SetNX(ctx, key, next, opts)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to update bucket %s: %w", key, err)
+		}
+
+		if swapped {
+			return &LimitResult{Allowed: true, Remaining: int64(next.Tokens)}, nil
+		}
+	}
+
+	return nil, ErrContention
+}
+
+// SlidingWindowLimiter allows rate.Limit events per rate.Period, over a window sliding with the
+// time. The events of the previous period are weighted by the part of the window they overlap,
+// the counters are kept in a kitcache.Store so the limit is shared by the instances using it.
+type SlidingWindowLimiter struct {
+	store kitcache.Store
+	rate  Rate
+}
+
+func NewSlidingWindowLimiter(store kitcache.Store, rate Rate) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{store: store, rate: rate}
+}
+
+func (l *SlidingWindowLimiter) Allow(ctx context.Context, key string) (*LimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *SlidingWindowLimiter) AllowN(ctx context.Context, key string, n int64) (*LimitResult, error) {
+	now := time.Now()
+	window := now.UnixNano() / int64(l.rate.Period)
+	elapsed := time.Duration(now.UnixNano() % int64(l.rate.Period))
+
+	currentKey := slidingWindowKeyPrefix + key + ":" + strconv.FormatInt(window, 10)
+	previousKey := slidingWindowKeyPrefix + key + ":" + strconv.FormatInt(window-1, 10)
+
+	count, err := l.store.Increment(ctx, currentKey, n)
+	if err != nil {
+		return nil, fmt.Errorf("unable to increment window %s: %w", currentKey, err)
+	}
+
+	if count == n {
+		// the counter is read as the previous window during the next period
+		if err := l.store.Expire(ctx, currentKey, 2*l.rate.Period); err != nil {
+			return nil, fmt.Errorf("unable to expire window %s: %w", currentKey, err)
+		}
+	}
+
+	previous, err := l.counter(ctx, previousKey)
+	if err != nil {
+		return nil, err
+	}
+
+	weight := 1 - float64(elapsed)/float64(l.rate.Period)
+	estimate := float64(previous)*weight + float64(count)
+
+	if estimate <= float64(l.rate.Limit) {
+		return &LimitResult{Allowed: true, Remaining: l.rate.Limit - int64(math.Ceil(estimate))}, nil
+	}
+
+	if _, err := l.store.Decrement(ctx, currentKey, n); err != nil {
+		return nil, fmt.Errorf("unable to decrement window %s: %w", currentKey, err)
+	}
+
+	// wait for the previous window to slide out enough, or for the next period
+	retryAfter := l.rate.Period - elapsed
+	if previous > 0 {
+		overlap := 1 - float64(l.rate.Limit-count)/float64(previous)
+		if wait := time.Duration(overlap*float64(l.rate.Period)) - elapsed; wait < retryAfter {
+			retryAfter = max(wait, 0)
+		}
+	}
+
+	return &LimitResult{
+		Remaining:  max(l.rate.Limit-int64(math.Ceil(estimate-float64(n))), 0),
+		RetryAfter: retryAfter,
+	}, nil
+}
+
+// counter returns the integer value of the key, 0 if it is missing.
+func (l *SlidingWindowLimiter) counter(ctx context.Context, key string) (int64, error) {
+	value, err := l.store.Get(ctx, key)
+	if errors.Is(err, kitcache.ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("unable to get window %s: %w", key, err)
+	}
+
+	switch value := value.(type) {
+	case int64:
+		return value, nil
+	case int:
+		return int64(value), nil
+	case []byte:
+		return strconv.ParseInt(string(value), 10, 64)
+	case string:
+		return strconv.ParseInt(value, 10, 64)
+	default:
+		return 0, fmt.Errorf("%w: got %T for window %s", kitcache.ErrNotAnInteger, value, key)
+	}
+}
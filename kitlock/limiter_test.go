@@ -0,0 +1,56 @@
+package kitlock
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewTokenBucketLimiter(newTestStore(t), PerSecond(10), 3)
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, "user")
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+		require.Equal(t, int64(2-i), result.Remaining)
+	}
+
+	result, err := limiter.Allow(ctx, "user")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Greater(t, result.RetryAfter, time.Duration(0))
+	require.LessOrEqual(t, result.RetryAfter, 100*time.Millisecond)
+
+	result, err = limiter.Allow(ctx, "other")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	time.Sleep(result.RetryAfter + 110*time.Millisecond)
+
+	result, err = limiter.Allow(ctx, "user")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewSlidingWindowLimiter(newTestStore(t), Rate{Limit: 3, Period: time.Hour})
+
+	result, err := limiter.AllowN(ctx, "user", 2)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	result, err = limiter.AllowN(ctx, "user", 2)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, int64(1), result.Remaining)
+	require.Greater(t, result.RetryAfter, time.Duration(0))
+
+	result, err = limiter.Allow(ctx, "user")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Zero(t, result.Remaining)
+}
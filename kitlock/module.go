@@ -0,0 +1,116 @@
+package kitlock
+
+import (
+	"context"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/kitcat-framework/kitcat/kitdi"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/spf13/viper"
+	"log/slog"
+)
+
+type Config struct {
+	// LockerName is the name of the locker: in_memory, cache (the store of kitcache, e.g. redis,
+	// but not in_memory) or a provided one, e.g. postgres
+	LockerName string `cfg:"locker_name"`
+}
+
+func (c *Config) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitlock"
+	viper.SetDefault(prefix+".locker_name", "in_memory")
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal kitlock config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(Config))
+}
+
+type KitLock struct {
+	Config        *Config
+	CurrentLocker Locker
+
+	logger *slog.Logger
+}
+
+func Module(config *Config, app *kitcat.App) {
+	mod := &KitLock{
+		Config: config,
+		logger: slog.With(kitslog.Module("kitlock")),
+	}
+
+	app.Provides(
+		kitcat.ProvideConfigurableModule(mod),
+		ProvideLocker(NewInMemoryLocker),
+	)
+}
+
+func (m *KitLock) Configure(_ context.Context, app *kitcat.App) error {
+	if m.Config.LockerName == "cache" {
+		// the store is provided by the kitcache module, only when it is used
+		app.Invoke(m.useCacheLocker)
+	} else {
+		app.Invoke(m.useLocker)
+	}
+
+	m.logger.Info("using locker", slog.String("locker", m.CurrentLocker.Name()))
+	app.Provides(kitdi.Annotate(m.CurrentLocker, kitdi.As(new(Locker))))
+
+	return nil
+}
+
+func (m *KitLock) useCacheLocker(store kitcache.Store, tiered *kitcache.TieredStoreConfig) error {
+	if err := checkCacheLockerStore(store, tiered); err != nil {
+		return fmt.Errorf("%s: %w", m.Name(), err)
+	}
+
+	m.CurrentLocker = NewCacheLocker(store)
+
+	return nil
+}
+
+// checkCacheLockerStore refuses the in_memory store for the cache locker: it may drop writes
+// and evict keys, so a lock could be granted twice and its fencing token go back. The locks of
+// a tiered store are kept in its far store.
+func checkCacheLockerStore(store kitcache.Store, tiered *kitcache.TieredStoreConfig) error {
+	name := store.Name()
+	if name == "tiered" {
+		name = tiered.FarStoreName
+	}
+
+	if name == "in_memory" {
+		return fmt.Errorf("the cache locker needs a store without eviction, got %s: use the in_memory locker instead",
+			store.Name())
+	}
+
+	return nil
+}
+
+func (m *KitLock) useLocker(l lockers) error {
+	locker, err := kitcat.UseImplementation(kitcat.UseImplementationParams[Locker]{
+		ModuleName:                m.Name(),
+		ImplementationTerminology: "locker",
+		ConfigImplementationName:  m.Config.LockerName,
+		Implementations:           l.Lockers,
+	})
+	if err != nil {
+		return err
+	}
+
+	if locker == nil {
+		return fmt.Errorf("%s: locker %q not found", m.Name(), m.Config.LockerName)
+	}
+
+	m.CurrentLocker = locker
+
+	return nil
+}
+
+// Priority is below the one of kitcache, so its store is provided for the cache locker.
+func (m *KitLock) Priority() uint8 { return 0 }
+
+func (m *KitLock) Name() string {
+	return "kitlock"
+}
@@ -0,0 +1,49 @@
+package kitsaga
+
+import (
+	"context"
+	"github.com/kitcat-framework/kitcat/kitevent"
+)
+
+// StepReplyConsumer moves the sagas forward when a StepReply is consumed.
+// A concurrent update of the saga fails the consumer, so the reply is retried.
+type StepReplyConsumer struct {
+	orchestrator *Orchestrator
+}
+
+func NewStepReplyConsumer(orchestrator *Orchestrator) *StepReplyConsumer {
+	return &StepReplyConsumer{orchestrator: orchestrator}
+}
+
+func (c StepReplyConsumer) Consume(ctx context.Context, reply *StepReply) error {
+	return c.orchestrator.handleReply(ctx, reply)
+}
+
+func (c StepReplyConsumer) Options() *kitevent.ConsumerOptions {
+	return kitevent.NewConsumerOptions().WithMaxRetry(5)
+}
+
+func (c StepReplyConsumer) Name() string {
+	return "kitsaga.step_reply"
+}
+
+// StepTimeoutConsumer compensates the sagas when a StepTimeout is consumed.
+type StepTimeoutConsumer struct {
+	orchestrator *Orchestrator
+}
+
+func NewStepTimeoutConsumer(orchestrator *Orchestrator) *StepTimeoutConsumer {
+	return &StepTimeoutConsumer{orchestrator: orchestrator}
+}
+
+func (c StepTimeoutConsumer) Consume(ctx context.Context, timeout *StepTimeout) error {
+	return c.orchestrator.handleTimeout(ctx, timeout)
+}
+
+func (c StepTimeoutConsumer) Options() *kitevent.ConsumerOptions {
+	return kitevent.NewConsumerOptions().WithMaxRetry(5)
+}
+
+func (c StepTimeoutConsumer) Name() string {
+	return "kitsaga.step_timeout"
+}
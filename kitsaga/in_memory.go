@@ -0,0 +1,106 @@
+package kitsaga
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+type InMemoryStorage struct {
+	mu    sync.RWMutex
+	sagas map[string]*Saga
+	order []string
+}
+
+func NewInMemoryStorage() *InMemoryStorage {
+	return &InMemoryStorage{
+		sagas: make(map[string]*Saga),
+	}
+}
+
+func (s *InMemoryStorage) Create(_ context.Context, saga *Saga) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sagas[saga.ID] = cloneSaga(saga)
+	s.order = append(s.order, saga.ID)
+
+	return nil
+}
+
+func (s *InMemoryStorage) Update(_ context.Context, saga *Saga) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sagas[saga.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if stored.Version != saga.Version {
+		return ErrConcurrentUpdate
+	}
+
+	saga.Version++
+	s.sagas[saga.ID] = cloneSaga(saga)
+
+	return nil
+}
+
+func (s *InMemoryStorage) Get(_ context.Context, id string) (*Saga, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	saga, ok := s.sagas[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return cloneSaga(saga), nil
+}
+
+func (s *InMemoryStorage) List(_ context.Context, filter ListFilter) ([]*Saga, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sagas []*Saga
+	for _, id := range s.order {
+		saga := s.sagas[id]
+
+		if filter.Name != "" && saga.Name != filter.Name {
+			continue
+		}
+
+		if filter.Status != "" && saga.Status != filter.Status {
+			continue
+		}
+
+		sagas = append(sagas, cloneSaga(saga))
+
+		if filter.Limit > 0 && len(sagas) == filter.Limit {
+			break
+		}
+	}
+
+	return sagas, nil
+}
+
+func (s *InMemoryStorage) Name() string {
+	return "in_memory"
+}
+
+func cloneSaga(saga *Saga) *Saga {
+	clone := *saga
+
+	clone.Results = make(map[string]json.RawMessage, len(saga.Results))
+	for step, result := range saga.Results {
+		clone.Results[step] = result
+	}
+
+	if saga.Error != nil {
+		reason := *saga.Error
+		clone.Error = &reason
+	}
+
+	return &clone
+}
@@ -0,0 +1,236 @@
+package kitsaga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitdi"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"go.uber.org/dig"
+	"time"
+)
+
+var (
+	ErrNotFound          = errors.New("kitsaga: saga not found")
+	ErrConcurrentUpdate  = errors.New("kitsaga: saga updated concurrently")
+	ErrUnknownDefinition = errors.New("kitsaga: unknown saga definition")
+	ErrNotInSaga         = errors.New("kitsaga: the event being consumed does not belong to a saga")
+)
+
+const (
+	// MetadataKeySagaID is the metadata key of the events produced by a saga holding its ID
+	MetadataKeySagaID = "saga_id"
+
+	// MetadataKeySagaStep is the metadata key of the events produced by a saga holding the step index
+	MetadataKeySagaStep = "saga_step"
+
+	// MetadataKeySagaCompensation is set to true on the compensation events
+	MetadataKeySagaCompensation = "saga_compensation"
+)
+
+type Status string
+
+const (
+	// StatusRunning is the status of a saga waiting for the reply of its current step
+	StatusRunning Status = "RUNNING"
+
+	// StatusCompleted is the status of a saga with every step replied successfully
+	StatusCompleted Status = "COMPLETED"
+
+	// StatusCompensating is the status of a failed saga while its compensation events are produced,
+	// a saga stays in this status if a compensation cannot be produced until it is resumed, see
+	// Orchestrator.ResumeCompensations
+	StatusCompensating Status = "COMPENSATING"
+
+	// StatusCompensated is the status of a failed saga once every compensation event is produced
+	StatusCompensated Status = "COMPENSATED"
+)
+
+type (
+	// Saga is the persisted state of a saga
+	Saga struct {
+		ID   string
+		Name string
+
+		Status      Status
+		CurrentStep int
+
+		// CompensatedSteps is the number of steps before CurrentStep already compensated, the
+		// compensation of a failed saga resumes from the step before them
+		CompensatedSteps int
+
+		// Data is the JSON representation of the data given to Orchestrator.Start
+		Data json.RawMessage
+
+		// Results holds the data replied by each completed step, by step name
+		Results map[string]json.RawMessage
+
+		// Error is the reason of the failure of the saga
+		Error *string
+
+		// Version is incremented on each update, it is used to detect concurrent updates
+		Version int
+
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	// Step is a step of a Definition.
+	//
+	// The Action event is produced when the step starts, its consumer must call Reply once the
+	// step is done. If the step fails, the Compensation events of the previous steps are produced
+	// in reverse order.
+	Step struct {
+		Name string
+
+		// Action returns the event produced to execute the step
+		Action func(ctx context.Context, saga *Saga) (kitevent.Event, error)
+
+		// Compensation returns the event produced to undo the step when a following step fails, optional
+		Compensation func(ctx context.Context, saga *Saga) (kitevent.Event, error)
+
+		// Timeout is the duration after which the step fails if it is not replied, optional
+		Timeout *time.Duration
+	}
+
+	// Definition describes a saga, Name must be unique
+	Definition struct {
+		Name  string
+		Steps []Step
+	}
+
+	// Storage persists the state of the sagas
+	Storage interface {
+		Create(ctx context.Context, saga *Saga) error
+
+		// Update saves the saga if its Version is the stored one and increments it,
+		// otherwise ErrConcurrentUpdate is returned
+		Update(ctx context.Context, saga *Saga) error
+
+		// Get returns ErrNotFound if the saga does not exist
+		Get(ctx context.Context, id string) (*Saga, error)
+
+		List(ctx context.Context, filter ListFilter) ([]*Saga, error)
+
+		kitcat.Nameable
+	}
+
+	// ListFilter filters the sagas returned by Storage.List, zero values are ignored
+	ListFilter struct {
+		Name   string
+		Status Status
+		Limit  int
+	}
+
+	// StepReply is produced by Reply to notify the Orchestrator that a step is done
+	StepReply struct {
+		SagaID string          `json:"saga_id"`
+		Step   int             `json:"step"`
+		Data   json.RawMessage `json:"data,omitempty"`
+		Error  *string         `json:"error,omitempty"`
+	}
+
+	// StepTimeout is produced with the Step.Timeout of a step, it fails the step if it is still running
+	StepTimeout struct {
+		SagaID string `json:"saga_id"`
+		Step   int    `json:"step"`
+	}
+
+	storages struct {
+		dig.In
+		Storages []Storage `group:"kitsaga.storage"`
+	}
+)
+
+func (StepReply) EventName() kitevent.EventName {
+	return kitevent.NewEventName("kitsaga.step_reply")
+}
+
+func (StepTimeout) EventName() kitevent.EventName {
+	return kitevent.NewEventName("kitsaga.step_timeout")
+}
+
+// Decode unmarshals the data of the saga into v.
+func (s *Saga) Decode(v any) error {
+	return json.Unmarshal(s.Data, v)
+}
+
+// Result unmarshals the data replied by the step into v.
+func (s *Saga) Result(step string, v any) error {
+	result, ok := s.Results[step]
+	if !ok {
+		return ErrNotFound
+	}
+
+	return json.Unmarshal(result, v)
+}
+
+// Done returns true if the saga will not change anymore
+func (s *Saga) Done() bool {
+	return s.Status == StatusCompleted || s.Status == StatusCompensated
+}
+
+func ProvideStorage(storage any) *kitdi.Annotation {
+	return kitdi.Annotate(storage, kitdi.As(new(Storage)), kitdi.Group("kitsaga.storage"))
+}
+
+// ProvideDefinition provides a *Definition, or a constructor of *Definition
+func ProvideDefinition(definition any) *kitdi.Annotation {
+	return kitdi.Annotate(definition, kitdi.Group("kitsaga.definition"))
+}
+
+// Reply notifies the Orchestrator that the step of the event being consumed is done, with an error
+// if it failed. data is stored in Saga.Results, it can be nil.
+//
+// It must be called from the Consumer of the Step.Action event, since the saga is read from the
+// metadata of the context (see kitevent.MetadataFromContext).
+func Reply(ctx context.Context, producer kitevent.Producer, data any, stepErr error) error {
+	metadata := kitevent.MetadataFromContext(ctx)
+
+	sagaID, ok := metadata[MetadataKeySagaID].(string)
+	if !ok {
+		return ErrNotInSaga
+	}
+
+	reply := &StepReply{
+		SagaID: sagaID,
+		Step:   metadataInt(metadata[MetadataKeySagaStep]),
+	}
+
+	if data != nil {
+		marshalData, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		reply.Data = marshalData
+	}
+
+	if stepErr != nil {
+		reply.Error = new(string)
+		*reply.Error = stepErr.Error()
+	}
+
+	return producer.Produce(ctx, reply, nil)
+}
+
+// metadataInt converts a metadata value to an int, numbers decoded from JSON are float64, or
+// json.Number with a decoder using UseNumber.
+func metadataInt(value any) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return int(n)
+	}
+
+	return 0
+}
@@ -0,0 +1,15 @@
+package kitsaga
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMetadataInt(t *testing.T) {
+	for _, value := range []any{2, int32(2), int64(2), float64(2), json.Number("2")} {
+		require.Equal(t, 2, metadataInt(value))
+	}
+
+	require.Equal(t, 0, metadataInt("2"))
+}
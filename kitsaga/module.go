@@ -0,0 +1,79 @@
+package kitsaga
+
+import (
+	"context"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitdi"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/spf13/viper"
+	"log/slog"
+)
+
+type Config struct {
+	StorageName string `cfg:"storage_name"`
+}
+
+func (c *Config) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitsaga"
+	viper.SetDefault(prefix+".storage_name", "in_memory")
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal kitsaga config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(Config))
+}
+
+type KitSaga struct {
+	Config         *Config
+	CurrentStorage Storage
+
+	logger *slog.Logger
+}
+
+// Module provides the Orchestrator, it requires the kitevent module.
+func Module(app *kitcat.App, config *Config) {
+	mod := &KitSaga{
+		Config: config,
+		logger: slog.With(kitslog.Module("kitsaga")),
+	}
+
+	app.Provides(
+		kitcat.ProvideConfigurableModule(mod),
+		ProvideStorage(NewInMemoryStorage),
+		NewOrchestrator,
+		kitevent.ProvideConsumer(NewStepReplyConsumer),
+		kitevent.ProvideConsumer(NewStepTimeoutConsumer),
+	)
+}
+
+func (m *KitSaga) Configure(_ context.Context, app *kitcat.App) error {
+	app.Invoke(m.setCurrentStorage)
+
+	return nil
+}
+
+func (m *KitSaga) Priority() uint8 { return 0 }
+
+func (m *KitSaga) setCurrentStorage(a *kitcat.App, s storages) error {
+	implementation, err := kitcat.UseImplementation(kitcat.UseImplementationParams[Storage]{
+		ModuleName:                m.Name(),
+		ImplementationTerminology: "storage",
+		ConfigImplementationName:  m.Config.StorageName,
+		Implementations:           s.Storages,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.CurrentStorage = implementation
+	m.logger.Info("using storage", slog.String("storage", m.CurrentStorage.Name()))
+	a.Provides(kitdi.Annotate(m.CurrentStorage, kitdi.As(new(Storage))))
+
+	return nil
+}
+
+func (m *KitSaga) Name() string {
+	return "kitsaga"
+}
@@ -0,0 +1,284 @@
+package kitsaga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"go.uber.org/dig"
+	"log/slog"
+	"time"
+)
+
+// Orchestrator runs the sagas: it produces the events of the steps, and moves a saga forward
+// or compensates it when a StepReply or a StepTimeout is consumed.
+type Orchestrator struct {
+	producer    kitevent.Producer
+	storage     Storage
+	definitions map[string]*Definition
+	logger      *slog.Logger
+}
+
+type OrchestratorParams struct {
+	dig.In
+
+	Producer    kitevent.Producer
+	Storage     Storage
+	Definitions []*Definition `group:"kitsaga.definition"`
+	Logger      *slog.Logger
+}
+
+func NewOrchestrator(params OrchestratorParams) (*Orchestrator, error) {
+	o := &Orchestrator{
+		producer:    params.Producer,
+		storage:     params.Storage,
+		definitions: make(map[string]*Definition),
+		logger:      params.Logger.With(kitslog.Module("kitsaga")),
+	}
+
+	for _, definition := range params.Definitions {
+		if _, ok := o.definitions[definition.Name]; ok {
+			return nil, fmt.Errorf("kitsaga: definition %q is provided twice", definition.Name)
+		}
+
+		o.definitions[definition.Name] = definition
+	}
+
+	return o, nil
+}
+
+// Start creates a saga from the definition named name and produces the event of its first step.
+func (o *Orchestrator) Start(ctx context.Context, name string, data any) (*Saga, error) {
+	definition, ok := o.definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDefinition, name)
+	}
+
+	marshalData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal saga data: %w", err)
+	}
+
+	saga := &Saga{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Status:    StatusRunning,
+		Data:      marshalData,
+		Results:   make(map[string]json.RawMessage),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if len(definition.Steps) == 0 {
+		saga.Status = StatusCompleted
+	}
+
+	if err := o.storage.Create(ctx, saga); err != nil {
+		return nil, fmt.Errorf("failed to create saga: %w", err)
+	}
+
+	if saga.Status == StatusRunning {
+		if err := o.runStep(ctx, saga, definition); err != nil {
+			return saga, err
+		}
+	}
+
+	return saga, nil
+}
+
+// Get returns the saga, ErrNotFound if it does not exist.
+func (o *Orchestrator) Get(ctx context.Context, id string) (*Saga, error) {
+	return o.storage.Get(ctx, id)
+}
+
+// List returns the sagas matching the filter.
+func (o *Orchestrator) List(ctx context.Context, filter ListFilter) ([]*Saga, error) {
+	return o.storage.List(ctx, filter)
+}
+
+func (o *Orchestrator) handleReply(ctx context.Context, reply *StepReply) error {
+	saga, definition, ok, err := o.runningSaga(ctx, reply.SagaID, reply.Step)
+	if err != nil || !ok {
+		return err
+	}
+
+	if saga.Status == StatusCompensating {
+		return o.resumeCompensation(ctx, saga, definition)
+	}
+
+	if reply.Error != nil {
+		return o.compensate(ctx, saga, definition, *reply.Error)
+	}
+
+	if reply.Data != nil {
+		saga.Results[definition.Steps[saga.CurrentStep].Name] = reply.Data
+	}
+
+	saga.CurrentStep++
+	if saga.CurrentStep == len(definition.Steps) {
+		saga.Status = StatusCompleted
+	}
+
+	if err := o.update(ctx, saga); err != nil {
+		return err
+	}
+
+	if saga.Status == StatusCompleted {
+		o.logger.Info("saga completed", slog.String("saga_id", saga.ID), slog.String("saga", saga.Name))
+		return nil
+	}
+
+	return o.runStep(ctx, saga, definition)
+}
+
+func (o *Orchestrator) handleTimeout(ctx context.Context, timeout *StepTimeout) error {
+	saga, definition, ok, err := o.runningSaga(ctx, timeout.SagaID, timeout.Step)
+	if err != nil || !ok {
+		return err
+	}
+
+	if saga.Status == StatusCompensating {
+		return o.resumeCompensation(ctx, saga, definition)
+	}
+
+	return o.compensate(ctx, saga, definition,
+		fmt.Sprintf("step %s timed out", definition.Steps[saga.CurrentStep].Name))
+}
+
+// runningSaga returns the saga if it is still running the step, or compensating since the step
+// failed: the retries of the reply or the timeout of the failed step resume its compensation.
+// Replies and timeouts of other steps are late or duplicated and must be ignored.
+func (o *Orchestrator) runningSaga(ctx context.Context, id string, step int) (*Saga, *Definition, bool, error) {
+	saga, err := o.storage.Get(ctx, id)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if (saga.Status != StatusRunning && saga.Status != StatusCompensating) || saga.CurrentStep != step {
+		return nil, nil, false, nil
+	}
+
+	definition, ok := o.definitions[saga.Name]
+	if !ok {
+		return nil, nil, false, fmt.Errorf("%w: %s", ErrUnknownDefinition, saga.Name)
+	}
+
+	return saga, definition, true, nil
+}
+
+func (o *Orchestrator) runStep(ctx context.Context, saga *Saga, definition *Definition) error {
+	step := definition.Steps[saga.CurrentStep]
+
+	event, err := step.Action(ctx, saga)
+	if err != nil {
+		return o.compensate(ctx, saga, definition, fmt.Sprintf("step %s: %s", step.Name, err))
+	}
+
+	if err := o.producer.Produce(ctx, event, o.producerOptions(saga, saga.CurrentStep)); err != nil {
+		return o.compensate(ctx, saga, definition, fmt.Sprintf("step %s: %s", step.Name, err))
+	}
+
+	if step.Timeout != nil {
+		err := o.producer.Produce(ctx, &StepTimeout{SagaID: saga.ID, Step: saga.CurrentStep},
+			kitevent.NewProducerOptions().WithProduceAt(time.Now().Add(*step.Timeout)))
+		if err != nil {
+			return fmt.Errorf("failed to produce step timeout: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// compensate marks the saga as compensating and produces the compensation events of the completed
+// steps, in reverse order.
+func (o *Orchestrator) compensate(ctx context.Context, saga *Saga, definition *Definition, reason string) error {
+	o.logger.Error("saga failed, compensating", slog.String("saga_id", saga.ID),
+		slog.String("saga", saga.Name), slog.String("reason", reason))
+
+	saga.Status = StatusCompensating
+	saga.Error = &reason
+	saga.CompensatedSteps = 0
+
+	if err := o.update(ctx, saga); err != nil {
+		return err
+	}
+
+	return o.resumeCompensation(ctx, saga, definition)
+}
+
+// ResumeCompensations resumes the compensation of the sagas left in StatusCompensating because a
+// compensation event could not be produced, it can be called periodically.
+func (o *Orchestrator) ResumeCompensations(ctx context.Context) error {
+	sagas, err := o.storage.List(ctx, ListFilter{Status: StatusCompensating})
+	if err != nil {
+		return fmt.Errorf("failed to list compensating sagas: %w", err)
+	}
+
+	var errs []error
+	for _, saga := range sagas {
+		definition, ok := o.definitions[saga.Name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrUnknownDefinition, saga.Name))
+			continue
+		}
+
+		if err := o.resumeCompensation(ctx, saga, definition); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// resumeCompensation produces the compensation events of the steps not compensated yet, the saga is
+// saved after each of them so a failed compensation resumes from the step that failed.
+func (o *Orchestrator) resumeCompensation(ctx context.Context, saga *Saga, definition *Definition) error {
+	for saga.CompensatedSteps < saga.CurrentStep {
+		i := saga.CurrentStep - 1 - saga.CompensatedSteps
+
+		step := definition.Steps[i]
+		if step.Compensation != nil {
+			event, err := step.Compensation(ctx, saga)
+			if err == nil {
+				err = o.producer.Produce(ctx, event,
+					o.producerOptions(saga, i).WithMetadata(MetadataKeySagaCompensation, true))
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to compensate step %s of saga %s: %w", step.Name, saga.ID, err)
+			}
+		}
+
+		saga.CompensatedSteps++
+
+		// the last compensation is saved with the status
+		if step.Compensation != nil && saga.CompensatedSteps < saga.CurrentStep {
+			if err := o.update(ctx, saga); err != nil {
+				return err
+			}
+		}
+	}
+
+	saga.Status = StatusCompensated
+
+	return o.update(ctx, saga)
+}
+
+func (o *Orchestrator) update(ctx context.Context, saga *Saga) error {
+	saga.UpdatedAt = time.Now()
+
+	if err := o.storage.Update(ctx, saga); err != nil {
+		return fmt.Errorf("failed to update saga %s: %w", saga.ID, err)
+	}
+
+	return nil
+}
+
+func (o *Orchestrator) producerOptions(saga *Saga, step int) *kitevent.ProducerOptions {
+	return kitevent.NewProducerOptions().
+		WithMetadata(MetadataKeySagaID, saga.ID).
+		WithMetadata(MetadataKeySagaStep, step)
+}
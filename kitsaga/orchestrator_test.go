@@ -0,0 +1,301 @@
+package kitsaga
+
+import (
+	"context"
+	"errors"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type reserveStock struct {
+	OrderID string
+}
+
+func (reserveStock) EventName() kitevent.EventName {
+	return kitevent.NewEventName("test.reserve_stock")
+}
+
+type releaseStock struct {
+	OrderID string
+}
+
+func (releaseStock) EventName() kitevent.EventName {
+	return kitevent.NewEventName("test.release_stock")
+}
+
+type chargePayment struct {
+	OrderID string
+}
+
+func (chargePayment) EventName() kitevent.EventName {
+	return kitevent.NewEventName("test.charge_payment")
+}
+
+type testConsumer[T kitevent.Event] struct {
+	name    string
+	consume func(ctx context.Context, event T) error
+}
+
+func (c testConsumer[T]) Consume(ctx context.Context, event T) error {
+	return c.consume(ctx, event)
+}
+
+func (c testConsumer[T]) Options() *kitevent.ConsumerOptions {
+	return kitevent.NewConsumerOptions()
+}
+
+func (c testConsumer[T]) Name() string {
+	return c.name
+}
+
+type order struct {
+	ID string
+}
+
+func newTestOrchestrator(t *testing.T, paymentErr error) (*Orchestrator, chan string) {
+	store := kitevent.NewInMemoryEventStore(slog.Default(), &kitevent.InMemoryEventStoreConfig{
+		Workers:    2,
+		BufferSize: 100,
+	})
+
+	orchestrator, err := NewOrchestrator(OrchestratorParams{
+		Producer: store,
+		Storage:  NewInMemoryStorage(),
+		Definitions: []*Definition{{
+			Name: "order",
+			Steps: []Step{
+				{
+					Name: "stock",
+					Action: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						var o order
+						if err := saga.Decode(&o); err != nil {
+							return nil, err
+						}
+
+						return &reserveStock{OrderID: o.ID}, nil
+					},
+					Compensation: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						return &releaseStock{}, nil
+					},
+				},
+				{
+					Name: "payment",
+					Action: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						return &chargePayment{}, nil
+					},
+				},
+			},
+		}},
+		Logger: slog.Default(),
+	})
+	require.NoError(t, err)
+
+	calls := make(chan string, 10)
+
+	store.AddConsumer(StepReply{}.EventName(), NewStepReplyConsumer(orchestrator))
+	store.AddConsumer(reserveStock{}.EventName(), testConsumer[*reserveStock]{
+		name: "reserve_stock",
+		consume: func(ctx context.Context, event *reserveStock) error {
+			calls <- "reserve_stock"
+			return Reply(ctx, store, map[string]string{"reservation": "r-1"}, nil)
+		},
+	})
+	store.AddConsumer(chargePayment{}.EventName(), testConsumer[*chargePayment]{
+		name: "charge_payment",
+		consume: func(ctx context.Context, event *chargePayment) error {
+			calls <- "charge_payment"
+			return Reply(ctx, store, nil, paymentErr)
+		},
+	})
+	store.AddConsumer(releaseStock{}.EventName(), testConsumer[*releaseStock]{
+		name: "release_stock",
+		consume: func(ctx context.Context, event *releaseStock) error {
+			calls <- "release_stock"
+			return nil
+		},
+	})
+
+	require.NoError(t, store.OnStart(context.Background()))
+	t.Cleanup(func() { _ = store.OnStop(context.Background()) })
+
+	return orchestrator, calls
+}
+
+// recordingProducer records the events produced.
+type recordingProducer struct {
+	events []kitevent.Event
+}
+
+func (p *recordingProducer) Produce(_ context.Context, event kitevent.Event, _ *kitevent.ProducerOptions) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *recordingProducer) ProduceSync(ctx context.Context, event kitevent.Event, opts *kitevent.ProducerOptions) error {
+	return p.Produce(ctx, event, opts)
+}
+
+// compensations returns the compensation events produced.
+func (p *recordingProducer) compensations() []string {
+	var compensations []string
+	for _, event := range p.events {
+		if release, ok := event.(*releaseStock); ok {
+			compensations = append(compensations, release.OrderID)
+		}
+	}
+
+	return compensations
+}
+
+// newCompensationTestOrchestrator returns an orchestrator whose "order" saga has two compensated
+// steps, the first compensation of the stock fails.
+func newCompensationTestOrchestrator(t *testing.T) (*Orchestrator, *recordingProducer) {
+	producer := &recordingProducer{}
+	stockCompensationFails := true
+
+	orchestrator, err := NewOrchestrator(OrchestratorParams{
+		Producer: producer,
+		Storage:  NewInMemoryStorage(),
+		Definitions: []*Definition{{
+			Name: "order",
+			Steps: []Step{
+				{
+					Name: "stock",
+					Action: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						return &reserveStock{}, nil
+					},
+					Compensation: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						if stockCompensationFails {
+							stockCompensationFails = false
+							return nil, errors.New("stock unavailable")
+						}
+
+						return &releaseStock{OrderID: "stock"}, nil
+					},
+				},
+				{
+					Name: "payment",
+					Action: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						return &chargePayment{}, nil
+					},
+					Compensation: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						return &releaseStock{OrderID: "payment"}, nil
+					},
+				},
+				{
+					Name: "shipping",
+					Action: func(ctx context.Context, saga *Saga) (kitevent.Event, error) {
+						return &chargePayment{}, nil
+					},
+				},
+			},
+		}},
+		Logger: slog.Default(),
+	})
+	require.NoError(t, err)
+
+	return orchestrator, producer
+}
+
+func waitSaga(t *testing.T, orchestrator *Orchestrator, id string, status Status) *Saga {
+	var saga *Saga
+
+	require.Eventually(t, func() bool {
+		var err error
+		saga, err = orchestrator.Get(context.Background(), id)
+		require.NoError(t, err)
+
+		return saga.Status == status
+	}, time.Second, 10*time.Millisecond)
+
+	return saga
+}
+
+func TestOrchestrator(t *testing.T) {
+	t.Run("completes the saga when every step replies", func(t *testing.T) {
+		orchestrator, calls := newTestOrchestrator(t, nil)
+
+		saga, err := orchestrator.Start(context.Background(), "order", order{ID: "o-1"})
+		require.NoError(t, err)
+
+		saga = waitSaga(t, orchestrator, saga.ID, StatusCompleted)
+		require.Equal(t, 2, saga.CurrentStep)
+
+		var result map[string]string
+		require.NoError(t, saga.Result("stock", &result))
+		require.Equal(t, "r-1", result["reservation"])
+
+		require.Equal(t, "reserve_stock", <-calls)
+		require.Equal(t, "charge_payment", <-calls)
+	})
+
+	t.Run("compensates the completed steps when a step fails", func(t *testing.T) {
+		orchestrator, calls := newTestOrchestrator(t, errors.New("card declined"))
+
+		saga, err := orchestrator.Start(context.Background(), "order", order{ID: "o-2"})
+		require.NoError(t, err)
+
+		saga = waitSaga(t, orchestrator, saga.ID, StatusCompensated)
+		require.NotNil(t, saga.Error)
+		require.Equal(t, "card declined", *saga.Error)
+
+		require.Equal(t, "reserve_stock", <-calls)
+		require.Equal(t, "charge_payment", <-calls)
+		require.Equal(t, "release_stock", <-calls)
+	})
+
+	t.Run("resumes the compensation on the retry of the failed reply", func(t *testing.T) {
+		orchestrator, producer := newCompensationTestOrchestrator(t)
+		ctx := context.Background()
+
+		saga, err := orchestrator.Start(ctx, "order", order{ID: "o-3"})
+		require.NoError(t, err)
+		require.NoError(t, orchestrator.handleReply(ctx, &StepReply{SagaID: saga.ID, Step: 0}))
+		require.NoError(t, orchestrator.handleReply(ctx, &StepReply{SagaID: saga.ID, Step: 1}))
+
+		stepErr := "shipping unavailable"
+		reply := &StepReply{SagaID: saga.ID, Step: 2, Error: &stepErr}
+		require.Error(t, orchestrator.handleReply(ctx, reply))
+
+		saga, err = orchestrator.Get(ctx, saga.ID)
+		require.NoError(t, err)
+		require.Equal(t, StatusCompensating, saga.Status)
+		require.Equal(t, 1, saga.CompensatedSteps)
+
+		require.NoError(t, orchestrator.handleReply(ctx, reply))
+
+		saga, err = orchestrator.Get(ctx, saga.ID)
+		require.NoError(t, err)
+		require.Equal(t, StatusCompensated, saga.Status)
+		require.Equal(t, []string{"payment", "stock"}, producer.compensations())
+	})
+
+	t.Run("resumes the compensating sagas", func(t *testing.T) {
+		orchestrator, producer := newCompensationTestOrchestrator(t)
+		ctx := context.Background()
+
+		saga, err := orchestrator.Start(ctx, "order", order{ID: "o-4"})
+		require.NoError(t, err)
+		require.NoError(t, orchestrator.handleReply(ctx, &StepReply{SagaID: saga.ID, Step: 0}))
+
+		stepErr := "card declined"
+		require.Error(t, orchestrator.handleReply(ctx, &StepReply{SagaID: saga.ID, Step: 1, Error: &stepErr}))
+
+		require.NoError(t, orchestrator.ResumeCompensations(ctx))
+
+		saga, err = orchestrator.Get(ctx, saga.ID)
+		require.NoError(t, err)
+		require.Equal(t, StatusCompensated, saga.Status)
+		require.Equal(t, []string{"stock"}, producer.compensations())
+	})
+
+	t.Run("returns ErrUnknownDefinition", func(t *testing.T) {
+		orchestrator, _ := newTestOrchestrator(t, nil)
+
+		_, err := orchestrator.Start(context.Background(), "unknown", nil)
+		require.ErrorIs(t, err, ErrUnknownDefinition)
+	})
+}
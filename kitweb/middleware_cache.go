@@ -0,0 +1,401 @@
+package kitweb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// responseCacheKeyPrefix prefixes the keys of the cached responses, the path follows so the
+// responses of a path can be purged with kitcache.Store.DeletePrefix.
+const responseCacheKeyPrefix = "kitweb_cache:"
+
+type (
+	ResponseCacheOptions struct {
+		// TTL is the time-to-live of the cached responses, the s-maxage or max-age directive of the
+		// response takes precedence over it. Default: 1 minute
+		TTL time.Duration
+
+		// Tags are attached to the cached responses, see PurgeCachedTags
+		Tags []string
+
+		// QueryParams are the query parameters of the key, every parameter is used when nil
+		QueryParams []string
+
+		// Headers are the request headers of the key, e.g. Accept-Language
+		Headers []string
+
+		// User returns the identifier of the user of the request, the responses are cached per user
+		// when set. The requests with an Authorization or a Cookie header are not cached without it.
+		User func(r *http.Request) string
+	}
+
+	ResponseCacheOption func(*ResponseCacheOptions)
+
+	// cachedResponse is either a response or, when Vary is set, the list of the request headers
+	// selecting the key of the response.
+	cachedResponse struct {
+		StatusCode int         `json:"status_code,omitempty"`
+		Header     http.Header `json:"header,omitempty"`
+		Body       []byte      `json:"body,omitempty"`
+		StoredAt   time.Time   `json:"stored_at"`
+		Vary       []string    `json:"vary,omitempty"`
+	}
+)
+
+func CacheTTL(ttl time.Duration) ResponseCacheOption {
+	return func(o *ResponseCacheOptions) {
+		o.TTL = ttl
+	}
+}
+
+func CacheTags(tags ...string) ResponseCacheOption {
+	return func(o *ResponseCacheOptions) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
+
+func CacheKeyQuery(params ...string) ResponseCacheOption {
+	return func(o *ResponseCacheOptions) {
+		o.QueryParams = append([]string{}, params...)
+	}
+}
+
+func CacheKeyHeaders(headers ...string) ResponseCacheOption {
+	return func(o *ResponseCacheOptions) {
+		o.Headers = append(o.Headers, headers...)
+	}
+}
+
+func CacheKeyUser(user func(r *http.Request) string) ResponseCacheOption {
+	return func(o *ResponseCacheOptions) {
+		o.User = user
+	}
+}
+
+// MiddlewareCache caches the 200 OK responses of the GET requests in the store, it can be used
+// per route with its own options:
+//
+//	r.Get("/products", h.list, kitweb.MiddlewareCache(store, kitweb.CacheTTL(time.Hour)))
+//
+// The response is buffered before being written, whatever the Res returned by the handler.
+// The responses with a no-store or private (without CacheKeyUser) Cache-Control, or a "Vary: *"
+// are not cached, the requests with a no-cache or no-store Cache-Control skip the cached
+// responses, so do the requests with credentials (Authorization or Cookie) without CacheKeyUser.
+// An ETag is generated when the handler did not set one, and a matching If-None-Match gets a
+// 304 Not Modified.
+func MiddlewareCache(store kitcache.Store, opts ...ResponseCacheOption) mux.MiddlewareFunc {
+	options := &ResponseCacheOptions{TTL: time.Minute}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	cache := kitcache.NewCache[cachedResponse](store,
+		kitcache.WithCodec(kitcache.JSONCodec{}),
+		kitcache.WithCompression(1024),
+	)
+
+	logger := slog.With(kitslog.Module("kitweb"), slog.String("middleware", "cache"))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			requestDirectives := parseCacheControl(req.Header.Get("Cache-Control"))
+			_, noStore := requestDirectives["no-store"]
+
+			if req.Method != http.MethodGet || noStore || (options.User == nil && hasCredentials(req)) {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			key := options.key(req)
+
+			if _, noCache := requestDirectives["no-cache"]; !noCache {
+				if response, ok := getCachedResponse(req.Context(), logger, cache, key, req); ok {
+					response.write(rw, req, "HIT")
+					return
+				}
+			}
+
+			recorder := &responseRecorder{header: http.Header{}}
+			next.ServeHTTP(recorder, req)
+
+			response := &cachedResponse{
+				StatusCode: recorder.statusCode,
+				Header:     recorder.header,
+				Body:       recorder.body.Bytes(),
+				StoredAt:   time.Now(),
+			}
+
+			if response.StatusCode == 0 {
+				response.StatusCode = http.StatusOK
+			}
+
+			if ttl, ok := options.cacheable(response); ok {
+				if response.Header.Get("ETag") == "" {
+					sum := sha256.Sum256(response.Body)
+					response.Header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+				}
+
+				setCachedResponse(req.Context(), logger, cache, key, req, response, kitcache.NewSetOptions().
+					WithTTL(ttl).
+					WithTags(options.Tags...))
+			}
+
+			response.write(rw, req, "MISS")
+		})
+	}
+}
+
+// PurgeCachedPath deletes the cached responses of the path, whatever their query or variant.
+func PurgeCachedPath(ctx context.Context, store kitcache.Store, path string) error {
+	return store.DeletePrefix(ctx, responseCacheKeyPrefix+path+"?")
+}
+
+// PurgeCachedPrefix deletes the cached responses of the paths starting with the prefix,
+// e.g. "/products/" for every product.
+func PurgeCachedPrefix(ctx context.Context, store kitcache.Store, prefix string) error {
+	return store.DeletePrefix(ctx, responseCacheKeyPrefix+prefix)
+}
+
+// PurgeCachedTags deletes the cached responses tagged with any of the tags, see CacheTags.
+func PurgeCachedTags(ctx context.Context, store kitcache.Store, tags ...string) error {
+	return store.InvalidateTags(ctx, tags...)
+}
+
+// key returns the key of the request: the path, the query, the headers and the user.
+func (o *ResponseCacheOptions) key(req *http.Request) string {
+	query := req.URL.Query()
+	if o.QueryParams != nil {
+		selected := url.Values{}
+		for _, param := range o.QueryParams {
+			if values, ok := query[param]; ok {
+				selected[param] = values
+			}
+		}
+
+		query = selected
+	}
+
+	var key strings.Builder
+	key.WriteString(responseCacheKeyPrefix)
+	key.WriteString(req.URL.Path)
+	key.WriteString("?")
+	key.WriteString(query.Encode())
+
+	if len(o.Headers) > 0 {
+		key.WriteString("|h:")
+		key.WriteString(headersKey(req, o.Headers))
+	}
+
+	if o.User != nil {
+		key.WriteString("|u:")
+		key.WriteString(url.QueryEscape(o.User(req)))
+	}
+
+	return key.String()
+}
+
+// cacheable returns the time-to-live of the response, or false when it must not be cached.
+func (o *ResponseCacheOptions) cacheable(response *cachedResponse) (time.Duration, bool) {
+	if response.StatusCode != http.StatusOK {
+		return 0, false
+	}
+
+	if _, ok := response.Header["Set-Cookie"]; ok {
+		return 0, false
+	}
+
+	directives := parseCacheControl(response.Header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+
+	if _, ok := directives["no-cache"]; ok {
+		return 0, false
+	}
+
+	if _, ok := directives["private"]; ok && o.User == nil {
+		return 0, false
+	}
+
+	for _, vary := range response.Header.Values("Vary") {
+		if strings.TrimSpace(vary) == "*" {
+			return 0, false
+		}
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return o.TTL, true
+}
+
+func getCachedResponse(
+	ctx context.Context,
+	logger *slog.Logger,
+	cache kitcache.Cache[cachedResponse],
+	key string,
+	req *http.Request,
+) (cachedResponse, bool) {
+	response, err := cache.Get(ctx, key)
+	if err == nil && response.Vary != nil {
+		response, err = cache.Get(ctx, varyKey(key, req, response.Vary))
+	}
+
+	if err != nil {
+		if !errors.Is(err, kitcache.ErrNotFound) {
+			logger.Warn("unable to get cached response", slog.String("key", key), kitslog.Err(err))
+		}
+
+		return cachedResponse{}, false
+	}
+
+	return response, true
+}
+
+// setCachedResponse stores the response, a response varying on request headers is stored under
+// a key with their values, and the list of the headers under the key of the request.
+func setCachedResponse(ctx context.Context, logger *slog.Logger, cache kitcache.Cache[cachedResponse], key string,
+	req *http.Request, response *cachedResponse, opts *kitcache.SetOptions) {
+	var vary []string
+	for _, values := range response.Header.Values("Vary") {
+		for _, header := range strings.Split(values, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				vary = append(vary, http.CanonicalHeaderKey(header))
+			}
+		}
+	}
+
+	var err error
+	if len(vary) > 0 {
+		err = errors.Join(
+			cache.Set(ctx, key, cachedResponse{Vary: vary, StoredAt: response.StoredAt}, opts),
+			cache.Set(ctx, varyKey(key, req, vary), *response, opts),
+		)
+	} else {
+		err = cache.Set(ctx, key, *response, opts)
+	}
+
+	if err != nil {
+		logger.Warn("unable to cache response", slog.String("key", key), kitslog.Err(err))
+	}
+}
+
+// hasCredentials reports whether the request is authenticated, its response may be personal.
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+func varyKey(key string, req *http.Request, vary []string) string {
+	return key + "|vary:" + headersKey(req, vary)
+}
+
+func headersKey(req *http.Request, headers []string) string {
+	values := url.Values{}
+	for _, header := range headers {
+		values[http.CanonicalHeaderKey(header)] = req.Header.Values(header)
+	}
+
+	return values.Encode()
+}
+
+// write writes the response, or a 304 Not Modified when the If-None-Match of the request
+// matches its ETag.
+func (c cachedResponse) write(rw http.ResponseWriter, req *http.Request, status string) {
+	for key, values := range c.Header {
+		rw.Header()[key] = values
+	}
+
+	rw.Header().Set("X-Cache", status)
+	if status == "HIT" {
+		rw.Header().Set("Age", strconv.Itoa(int(time.Since(c.StoredAt).Seconds())))
+	}
+
+	if etag := c.Header.Get("ETag"); etag != "" && etagMatches(req.Header.Get("If-None-Match"), etag) {
+		for _, header := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			rw.Header().Del(header)
+		}
+
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rw.WriteHeader(c.StatusCode)
+	_, _ = rw.Write(c.Body)
+}
+
+// etagMatches compares the ETags of an If-None-Match header with the weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseCacheControl returns the directives of a Cache-Control header with their value.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name == "" {
+			continue
+		}
+
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+
+	return directives
+}
+
+// responseRecorder buffers the response of the handler so it can be cached.
+type responseRecorder struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+
+	return r.body.Write(data)
+}
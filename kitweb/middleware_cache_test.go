@@ -0,0 +1,288 @@
+package kitweb
+
+import (
+	"context"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/kitcat-framework/kitcat/kittemplate"
+	"github.com/kitcat-framework/kitcat/kitweb/httpbind"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testEngine renders the name of the template and the data of the RenderData.
+type testEngine struct{}
+
+func (testEngine) Name() string {
+	return "gohtml"
+}
+
+func (testEngine) Execute(writer io.Writer, templateName string, options ...kittemplate.EngineOption) error {
+	opts := &kittemplate.EngineOptions{}
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	_, err := fmt.Fprintf(writer, "%s %v", templateName, opts.Data.(RenderData).Data)
+	return err
+}
+
+func newTestCacheStore(t *testing.T) *kitcache.InMemoryStore {
+	store, err := kitcache.NewInMemoryStore(kitcache.InMemoryStoreParams{
+		Config: &kitcache.InMemoryStoreConfig{NumCounters: 1000, MaxCost: 1 << 20, BufferItems: 64,
+			ReadYourWrites: true},
+	})
+	require.NoError(t, err)
+
+	return store
+}
+
+func newTestRouter() *Router {
+	binder := httpbind.NewBinder(httpbind.StringsParamExtractors, httpbind.ValuesParamExtractors)
+	web := &KitWeb{
+		config:          &Config{PanicHandler: panicHandler, NoContentHandler: noContentHandler},
+		paramsBinder:    binder,
+		paramsValidator: GetValidator(binder.GetParsableTags()),
+		engines:         map[string]kittemplate.Engine{"gohtml": testEngine{}},
+		env:             &kitcat.EnvironmentDevelopment,
+	}
+
+	return newRouter("test", web, web.env, nil)
+}
+
+// countingHandler answers with the number of calls in the body, and the headers.
+func countingHandler(calls *atomic.Int32, headers map[string]string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := calls.Add(1)
+		for key, value := range headers {
+			rw.Header().Set(key, value)
+		}
+
+		_, _ = fmt.Fprintf(rw, "call %d", n)
+	})
+}
+
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	return rw
+}
+
+func TestMiddlewareCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("caches the response", func(t *testing.T) {
+		var calls atomic.Int32
+		handler := MiddlewareCache(newTestCacheStore(t))(countingHandler(&calls, nil))
+
+		rw := serve(handler, httptest.NewRequest(http.MethodGet, "/products?page=1", nil))
+		require.Equal(t, http.StatusOK, rw.Code)
+		require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+		require.Equal(t, "call 1", rw.Body.String())
+
+		rw = serve(handler, httptest.NewRequest(http.MethodGet, "/products?page=1", nil))
+		require.Equal(t, http.StatusOK, rw.Code)
+		require.Equal(t, "HIT", rw.Header().Get("X-Cache"))
+		require.Equal(t, "call 1", rw.Body.String())
+		require.NotEmpty(t, rw.Header().Get("ETag"))
+
+		rw = serve(handler, httptest.NewRequest(http.MethodGet, "/products?page=2", nil))
+		require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+		require.Equal(t, "call 2", rw.Body.String())
+	})
+
+	t.Run("caches a response per variant", func(t *testing.T) {
+		var calls atomic.Int32
+		handler := MiddlewareCache(newTestCacheStore(t))(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			calls.Add(1)
+			rw.Header().Set("Vary", "Accept-Language")
+			_, _ = io.WriteString(rw, req.Header.Get("Accept-Language"))
+		}))
+
+		for _, language := range []string{"en", "fr", "en", "fr"} {
+			req := httptest.NewRequest(http.MethodGet, "/products", nil)
+			req.Header.Set("Accept-Language", language)
+
+			rw := serve(handler, req)
+			require.Equal(t, language, rw.Body.String())
+		}
+
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("answers 304 to a matching If-None-Match", func(t *testing.T) {
+		var calls atomic.Int32
+		handler := MiddlewareCache(newTestCacheStore(t))(countingHandler(&calls, map[string]string{
+			"Content-Type": "text/plain",
+		}))
+
+		rw := serve(handler, httptest.NewRequest(http.MethodGet, "/products", nil))
+		etag := rw.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		req := httptest.NewRequest(http.MethodGet, "/products", nil)
+		req.Header.Set("If-None-Match", etag)
+
+		rw = serve(handler, req)
+		require.Equal(t, http.StatusNotModified, rw.Code)
+		require.Equal(t, "HIT", rw.Header().Get("X-Cache"))
+		require.Empty(t, rw.Header().Get("Content-Type"))
+		require.Empty(t, rw.Body.String())
+	})
+
+	t.Run("does not cache the uncacheable responses", func(t *testing.T) {
+		for name, headers := range map[string]map[string]string{
+			"no-store":   {"Cache-Control": "no-store"},
+			"private":    {"Cache-Control": "private, max-age=60"},
+			"set-cookie": {"Set-Cookie": "session=abc"},
+		} {
+			headers := headers
+			t.Run(name, func(t *testing.T) {
+				var calls atomic.Int32
+				handler := MiddlewareCache(newTestCacheStore(t))(countingHandler(&calls, headers))
+
+				serve(handler, httptest.NewRequest(http.MethodGet, "/products", nil))
+				rw := serve(handler, httptest.NewRequest(http.MethodGet, "/products", nil))
+				require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+				require.Equal(t, "call 2", rw.Body.String())
+			})
+		}
+	})
+
+	t.Run("does not cache the requests with credentials", func(t *testing.T) {
+		for _, header := range []string{"Authorization", "Cookie"} {
+			header := header
+			t.Run(header, func(t *testing.T) {
+				var calls atomic.Int32
+				handler := MiddlewareCache(newTestCacheStore(t))(countingHandler(&calls, nil))
+
+				for i := 0; i < 2; i++ {
+					req := httptest.NewRequest(http.MethodGet, "/products", nil)
+					req.Header.Set(header, "secret")
+
+					rw := serve(handler, req)
+					require.Empty(t, rw.Header().Get("X-Cache"))
+				}
+
+				require.Equal(t, int32(2), calls.Load())
+			})
+		}
+	})
+
+	t.Run("caches the requests with credentials per user", func(t *testing.T) {
+		var calls atomic.Int32
+		handler := MiddlewareCache(newTestCacheStore(t), CacheKeyUser(func(r *http.Request) string {
+			return r.Header.Get("Cookie")
+		}))(countingHandler(&calls, nil))
+
+		for _, cookie := range []string{"user=1", "user=2", "user=1"} {
+			req := httptest.NewRequest(http.MethodGet, "/products", nil)
+			req.Header.Set("Cookie", cookie)
+			serve(handler, req)
+		}
+
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("expires the response after its max-age", func(t *testing.T) {
+		for name, headers := range map[string]map[string]string{
+			"max-age":  {"Cache-Control": "max-age=1"},
+			"s-maxage": {"Cache-Control": "max-age=3600, s-maxage=1"},
+		} {
+			headers := headers
+			t.Run(name, func(t *testing.T) {
+				var calls atomic.Int32
+				handler := MiddlewareCache(newTestCacheStore(t), CacheTTL(time.Hour))(countingHandler(&calls, headers))
+
+				serve(handler, httptest.NewRequest(http.MethodGet, "/products", nil))
+				rw := serve(handler, httptest.NewRequest(http.MethodGet, "/products", nil))
+				require.Equal(t, "HIT", rw.Header().Get("X-Cache"))
+
+				time.Sleep(1100 * time.Millisecond)
+
+				rw = serve(handler, httptest.NewRequest(http.MethodGet, "/products", nil))
+				require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+				require.Equal(t, int32(2), calls.Load())
+			})
+		}
+	})
+
+	t.Run("purges the responses of a path", func(t *testing.T) {
+		store := newTestCacheStore(t)
+		var calls atomic.Int32
+		handler := MiddlewareCache(store)(countingHandler(&calls, nil))
+
+		for _, target := range []string{"/products?page=1", "/products?page=2", "/products/42"} {
+			serve(handler, httptest.NewRequest(http.MethodGet, target, nil))
+		}
+
+		require.NoError(t, PurgeCachedPath(ctx, store, "/products"))
+
+		rw := serve(handler, httptest.NewRequest(http.MethodGet, "/products?page=1", nil))
+		require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+		rw = serve(handler, httptest.NewRequest(http.MethodGet, "/products?page=2", nil))
+		require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+		rw = serve(handler, httptest.NewRequest(http.MethodGet, "/products/42", nil))
+		require.Equal(t, "HIT", rw.Header().Get("X-Cache"))
+	})
+
+	t.Run("purges the responses of a tag", func(t *testing.T) {
+		store := newTestCacheStore(t)
+		var calls atomic.Int32
+		products := MiddlewareCache(store, CacheTags("products"))(countingHandler(&calls, nil))
+		orders := MiddlewareCache(store, CacheTags("orders"))(countingHandler(&calls, nil))
+
+		serve(products, httptest.NewRequest(http.MethodGet, "/products", nil))
+		serve(orders, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+		require.NoError(t, PurgeCachedTags(ctx, store, "products"))
+
+		rw := serve(products, httptest.NewRequest(http.MethodGet, "/products", nil))
+		require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+		rw = serve(orders, httptest.NewRequest(http.MethodGet, "/orders", nil))
+		require.Equal(t, "HIT", rw.Header().Get("X-Cache"))
+	})
+
+	t.Run("caches the renders of the handlers", func(t *testing.T) {
+		store := newTestCacheStore(t)
+		var calls atomic.Int32
+
+		r := newTestRouter()
+		r.Get("/json", func(c *Ctx[struct{}]) Res {
+			return JSONRender().Data(calls.Add(1))
+		}, MiddlewareCache(store))
+		r.Get("/view", func(c *Ctx[struct{}]) Res {
+			return ViewRender("products").Data(calls.Add(1))
+		}, MiddlewareCache(store))
+
+		for _, test := range []struct {
+			target      string
+			contentType string
+			body        string
+		}{
+			{target: "/json", contentType: "application/json", body: `{"data":1}`},
+			{target: "/view", contentType: "text/html", body: "products 2"},
+		} {
+			rw := serve(r.handler, httptest.NewRequest(http.MethodGet, test.target, nil))
+			require.Equal(t, http.StatusOK, rw.Code)
+			require.Equal(t, "MISS", rw.Header().Get("X-Cache"))
+			require.Contains(t, rw.Header().Get("Content-Type"), test.contentType)
+			require.Equal(t, test.body, strings.TrimSpace(rw.Body.String()))
+
+			rw = serve(r.handler, httptest.NewRequest(http.MethodGet, test.target, nil))
+			require.Equal(t, "HIT", rw.Header().Get("X-Cache"))
+			require.Contains(t, rw.Header().Get("Content-Type"), test.contentType)
+			require.Equal(t, test.body, strings.TrimSpace(rw.Body.String()))
+		}
+
+		require.Equal(t, int32(2), calls.Load())
+	})
+}
@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/kitcat-framework/kitcat/pkg/kitcat-cli/utils"
+	"github.com/kitcat-framework/kitcat/pkg/kitpg/kiteventpg"
+	"github.com/mkideal/cli"
+)
+
+type migrateKitEventSQL struct {
+	cli.Helper
+
+	Down bool `cli:"down" usage:"print the down migrations, in reverse order"`
+}
+
+var MigrateKitEventSQL = &cli.Command{
+	Name: "kitevent-sql",
+	Desc: "print the SQL of the migrations of the kitevent postgres store, to apply them with " +
+		"auto_migrate disabled",
+	Argv: func() interface{} { return new(migrateKitEventSQL) },
+	Fn: func(ctx *cli.Context) error {
+		m := ctx.Argv().(*migrateKitEventSQL)
+		return migrateKitEventSQLFunc(m)
+	},
+}
+
+func migrateKitEventSQLFunc(m *migrateKitEventSQL) error {
+	sql, err := kiteventpg.MigrationsSQL(m.Down)
+	if err != nil {
+		return utils.Err(err)
+	}
+
+	fmt.Print(sql)
+
+	return nil
+}
@@ -18,6 +18,7 @@ func main() {
 		cli.Tree(commands.Migrate,
 			cli.Tree(commands.MigrateApply),
 			cli.Tree(commands.MigrateDiff),
+			cli.Tree(commands.MigrateKitEventSQL),
 		),
 	)
 
@@ -0,0 +1,503 @@
+package kiteventnats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	headerEventName = "Kitevent-Event-Name"
+	headerMetadata  = "Kitevent-Metadata"
+	headerProduceAt = "Kitevent-Produce-At"
+	headerConsumer  = "Kitevent-Consumer"
+	headerError     = "Kitevent-Error"
+	headerFailedAt  = "Kitevent-Failed-At"
+
+	delayedConsumerName = "kitevent_delayed"
+)
+
+type NatsEventStoreConfig struct {
+	// StreamName is the JetStream stream of the events, it is created or updated on start
+	StreamName string `cfg:"stream_name"`
+
+	// SubjectPrefix prefixes the subjects of the stream: <prefix>.events.<event name> for the events,
+	// <prefix>.delayed.<event name> for the events produced with a ProduceAt in the future and
+	// <prefix>.dlq.<event name> for the dead letters
+	SubjectPrefix string `cfg:"subject_prefix"`
+
+	// Storage is the storage of the stream, "file" or "memory"
+	Storage string `cfg:"storage"`
+
+	Replicas int `cfg:"replicas"`
+
+	// MaxAge is the maximum age of the messages of the stream, 0 keeps them forever
+	MaxAge time.Duration `cfg:"max_age"`
+
+	// Workers is the maximum number of events processed in parallel by the store
+	Workers int `cfg:"workers"`
+
+	// BatchSize is the maximum number of messages pulled per consumer in a single request
+	BatchSize int `cfg:"batch_size"`
+}
+
+func (c *NatsEventStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitevent.config_stores.nats"
+
+	viper.SetDefault(prefix+".stream_name", "KITEVENT")
+	viper.SetDefault(prefix+".subject_prefix", "kitevent")
+	viper.SetDefault(prefix+".storage", "file")
+	viper.SetDefault(prefix+".replicas", 1)
+	viper.SetDefault(prefix+".max_age", 0)
+	viper.SetDefault(prefix+".workers", 10)
+	viper.SetDefault(prefix+".batch_size", 10)
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal nats event store config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(NatsEventStoreConfig))
+}
+
+// NatsEventStore is a kitevent.Store on top of NATS JetStream. Each EventName is a subject of a single
+// stream, and each Consumer is a durable pull consumer named after Consumer.Name(), filtered on the
+// subjects it subscribes to, or on every event when it subscribes to a pattern. The ConsumerOptions are mapped to the consumer:
+//   - MaxRetries to MaxDeliver, a message is sent to the dead letter subject when its last delivery fails
+//   - Timeout to AckWait, a message is redelivered if the consumer does not return in time, or sent
+//     to the dead letter subject on its last delivery
+//   - RetryInterval to the delay of the negative acknowledgement of a failed message
+//   - Concurrency to MaxAckPending
+//
+// The events produced with a ProduceAt in the future are published on a delayed subject, and
+// republished on their subject once due by an internal consumer. The events that cannot be processed
+// are published on the dead letter subject of their event, with the consumer and the error in the headers.
+type NatsEventStore struct {
+	consumers  *kitevent.ConsumerRegistry
+	js         jetstream.JetStream
+	logger     *slog.Logger
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+
+	config *NatsEventStoreConfig
+
+	// subscriptions are the event names (or patterns) of each consumer
+	subscriptions map[string][]kitevent.EventName
+
+	consumeContexts []jetstream.ConsumeContext
+
+	// workerSlots is a semaphore limiting the number of events processed in parallel
+	workerSlots chan struct{}
+}
+
+func New(nc *nats.Conn, logger *slog.Logger, config *NatsEventStoreConfig) (*NatsEventStore, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	return &NatsEventStore{
+		js: js,
+		logger: logger.With(
+			kitslog.Module("kitevent"),
+			slog.String("store", "nats")),
+		consumers:     kitevent.NewConsumerRegistry(),
+		ctx:           ctx,
+		cancelFunc:    cancelFunc,
+		config:        config,
+		subscriptions: make(map[string][]kitevent.EventName),
+		workerSlots:   make(chan struct{}, config.Workers),
+	}, nil
+}
+
+func (p *NatsEventStore) OnStart(ctx context.Context) error {
+	storage := jetstream.FileStorage
+	if p.config.Storage == "memory" {
+		storage = jetstream.MemoryStorage
+	}
+
+	_, err := p.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     p.config.StreamName,
+		Subjects: []string{p.subject("events", ">"), p.subject("delayed", ">"), p.subject("dlq", ">")},
+		Storage:  storage,
+		Replicas: p.config.Replicas,
+		MaxAge:   p.config.MaxAge,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create stream %s: %w", p.config.StreamName, err)
+	}
+
+	for _, consumer := range p.consumers.All() {
+		if err := p.consume(ctx, consumer); err != nil {
+			return err
+		}
+	}
+
+	return p.consumeDelayed(ctx)
+}
+
+func (p *NatsEventStore) Produce(ctx context.Context, event kitevent.Event, opt *kitevent.ProducerOptions) error {
+	if len(p.consumers.Get(event.EventName())) == 0 {
+		return errors.New("no consumer found for event")
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	if opt == nil {
+		opt = kitevent.NewProducerOptions()
+	}
+
+	opt.WithEventVersion(event)
+
+	metadata, err := json.Marshal(opt.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	msg := nats.NewMsg(p.subject("events", event.EventName().Name))
+	msg.Data = payload
+	msg.Header.Set(headerEventName, event.EventName().Name)
+	msg.Header.Set(headerMetadata, string(metadata))
+
+	var opts []jetstream.PublishOpt
+
+	id, _ := opt.Metadata["id"].(string)
+	if id != "" {
+		opts = append(opts, jetstream.WithMsgID(id))
+	}
+
+	if opt.ProduceAt != nil && opt.ProduceAt.After(time.Now()) {
+		msg.Subject = p.subject("delayed", event.EventName().Name)
+		msg.Header.Set(headerProduceAt, opt.ProduceAt.UTC().Format(time.RFC3339Nano))
+
+		if id != "" {
+			opts = []jetstream.PublishOpt{jetstream.WithMsgID("delayed:" + id)}
+		}
+	}
+
+	if _, err := p.js.PublishMsg(ctx, msg, opts...); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+
+	return nil
+}
+
+func (p *NatsEventStore) ProduceSync(
+	ctx context.Context,
+	event kitevent.Event,
+	opts *kitevent.ProducerOptions,
+) error {
+	if opts == nil {
+		opts = kitevent.NewProducerOptions()
+	}
+
+	handlers := p.consumers.Get(event.EventName())
+
+	var errs []error
+
+	for _, handler := range handlers {
+		// each consumer counts its own retries
+		consumerOpts := *opts
+
+		err := kitevent.LocalCallHandler(kitevent.LocalCallConsumerParams{
+			Ctx:           ctx,
+			Event:         event,
+			Producer:      p,
+			Opts:          &consumerOpts,
+			Consumer:      handler,
+			Logger:        p.logger,
+			IsProduceSync: true,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *NatsEventStore) AddConsumer(eventName kitevent.EventName, handler kitevent.Consumer) {
+	p.consumers.Add(eventName, handler)
+	p.subscriptions[handler.Name()] = append(p.subscriptions[handler.Name()], eventName)
+}
+
+func (p *NatsEventStore) OnStop(_ context.Context) error {
+	for _, consumeContext := range p.consumeContexts {
+		consumeContext.Stop()
+	}
+
+	p.cancelFunc()
+
+	return nil
+}
+
+func (p *NatsEventStore) Name() string {
+	return "nats"
+}
+
+func (p *NatsEventStore) subject(kind string, eventName string) string {
+	return p.config.SubjectPrefix + "." + kind + "." + eventName
+}
+
+// filterSubjects returns the subjects of the EventNames of the consumer. The patterns of path.Match
+// have no subject wildcard equivalent, a consumer with a pattern gets every event and skips those it
+// does not subscribe to, see subscribed.
+func (p *NatsEventStore) filterSubjects(consumer kitevent.Consumer) []string {
+	var subjects []string
+	for _, eventName := range p.subscriptions[consumer.Name()] {
+		if eventName.IsPattern() {
+			return []string{p.subject("events", ">")}
+		}
+
+		subjects = append(subjects, p.subject("events", eventName.Name))
+	}
+
+	return subjects
+}
+
+// subscribed returns true if the consumer subscribes to the event name.
+func (p *NatsEventStore) subscribed(consumer kitevent.Consumer, eventName string) bool {
+	for _, subscription := range p.subscriptions[consumer.Name()] {
+		if subscription.Match(kitevent.NewEventName(eventName)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// consume creates or updates the durable consumer of the Consumer and starts consuming its messages.
+func (p *NatsEventStore) consume(ctx context.Context, consumer kitevent.Consumer) error {
+	config := jetstream.ConsumerConfig{
+		Durable:        durableName(consumer.Name()),
+		FilterSubjects: p.filterSubjects(consumer),
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        *consumer.Options().Timeout,
+		MaxDeliver:     int(maxRetries(consumer)),
+	}
+
+	if concurrency := consumer.Options().Concurrency; concurrency != nil {
+		config.MaxAckPending = int(*concurrency)
+	}
+
+	cons, err := p.js.CreateOrUpdateConsumer(ctx, p.config.StreamName, config)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer %s: %w", consumer.Name(), err)
+	}
+
+	consumeContext, err := cons.Consume(func(msg jetstream.Msg) {
+		if eventName := msg.Headers().Get(headerEventName); !p.subscribed(consumer, eventName) {
+			p.ack(p.logger.With(slog.String("event_name", eventName)), msg.Ack)
+			return
+		}
+
+		p.workerSlots <- struct{}{}
+
+		go func() {
+			defer func() { <-p.workerSlots }()
+
+			p.process(consumer, msg)
+		}()
+	}, jetstream.PullMaxMessages(p.config.BatchSize))
+	if err != nil {
+		return fmt.Errorf("failed to consume %s: %w", consumer.Name(), err)
+	}
+
+	p.consumeContexts = append(p.consumeContexts, consumeContext)
+
+	return nil
+}
+
+func (p *NatsEventStore) process(consumer kitevent.Consumer, msg jetstream.Msg) {
+	eventName := msg.Headers().Get(headerEventName)
+
+	var metadata map[string]any
+	_ = json.Unmarshal([]byte(msg.Headers().Get(headerMetadata)), &metadata)
+
+	attempt := int32(1)
+	if msgMetadata, err := msg.Metadata(); err == nil {
+		attempt = int32(msgMetadata.NumDelivered)
+	}
+
+	l := p.logger.With(
+		slog.String("consumer", consumer.Name()),
+		slog.String("event_name", eventName),
+		slog.Int("attempt", int(attempt)))
+
+	envelope := &kitevent.Envelope{
+		Name:     kitevent.NewEventName(eventName),
+		Payload:  msg.Data(),
+		Metadata: metadata,
+	}
+
+	event, err := kitevent.ConsumerEvent(consumer, envelope, metadata)
+	if err != nil {
+		p.deadLetter(l, consumer, msg, fmt.Errorf("failed to convert payload to event: %w", err))
+		return
+	}
+
+	l.Info("processing event")
+
+	err = callWithTimeout(p.ctx, *consumer.Options().Timeout, func() error {
+		return kitevent.CallConsumer(kitevent.CallConsumerParams{
+			Ctx:     kitevent.ContextWithMetadata(p.ctx, metadata),
+			Event:   event,
+			Handler: consumer,
+		})
+	})
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && attempt >= maxRetries(consumer):
+		// the server does not redeliver the message after its last delivery
+		p.deadLetter(l, consumer, msg, errors.New("consumer timeout reached"))
+	case errors.Is(err, context.DeadlineExceeded):
+		// redelivered by the server once AckWait is elapsed
+		l.Error("consumer timeout reached")
+	case err == nil:
+		p.ack(l, msg.Ack)
+	case attempt >= maxRetries(consumer):
+		p.deadLetter(l, consumer, msg, err)
+	default:
+		l.Error("failed to process event, will retry", kitslog.Err(err))
+
+		var retryInterval time.Duration
+		if consumer.Options().RetryInterval != nil {
+			retryInterval = *consumer.Options().RetryInterval
+		}
+
+		p.ack(l, func() error { return msg.NakWithDelay(retryInterval) })
+	}
+}
+
+// deadLetter publishes the message on the dead letter subject of its event with the consumer and the
+// error, and terminates it, it will never be redelivered. The message is not acknowledged if the
+// publication fails, it is redelivered after AckWait if it has deliveries left.
+func (p *NatsEventStore) deadLetter(l *slog.Logger, consumer kitevent.Consumer, msg jetstream.Msg, err error) {
+	l.Error("sending event to dead letter queue", kitslog.Err(err))
+
+	eventName := msg.Headers().Get(headerEventName)
+
+	dead := nats.NewMsg(p.subject("dlq", eventName))
+	dead.Data = msg.Data()
+	dead.Header.Set(headerEventName, eventName)
+	dead.Header.Set(headerMetadata, msg.Headers().Get(headerMetadata))
+	dead.Header.Set(headerConsumer, consumer.Name())
+	dead.Header.Set(headerError, err.Error())
+	dead.Header.Set(headerFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	if _, err := p.js.PublishMsg(p.ctx, dead); err != nil {
+		l.Error("failed to send event to dead letter queue", kitslog.Err(err))
+		return
+	}
+
+	p.ack(l, msg.Term)
+}
+
+// consumeDelayed republishes the delayed messages on their subject once due, a message not due yet
+// is negatively acknowledged until it is.
+func (p *NatsEventStore) consumeDelayed(ctx context.Context) error {
+	cons, err := p.js.CreateOrUpdateConsumer(ctx, p.config.StreamName, jetstream.ConsumerConfig{
+		Durable:       delayedConsumerName,
+		FilterSubject: p.subject("delayed", ">"),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    -1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create delayed consumer: %w", err)
+	}
+
+	consumeContext, err := cons.Consume(func(msg jetstream.Msg) {
+		l := p.logger.With(slog.String("event_name", msg.Headers().Get(headerEventName)))
+
+		produceAt, err := time.Parse(time.RFC3339Nano, msg.Headers().Get(headerProduceAt))
+		if err != nil {
+			l.Error("invalid delayed event", kitslog.Err(err))
+			p.ack(l, msg.Term)
+			return
+		}
+
+		if delay := time.Until(produceAt); delay > 0 {
+			p.ack(l, func() error { return msg.NakWithDelay(delay) })
+			return
+		}
+
+		due := nats.NewMsg(p.subject("events", msg.Headers().Get(headerEventName)))
+		due.Data = msg.Data()
+		due.Header.Set(headerEventName, msg.Headers().Get(headerEventName))
+		due.Header.Set(headerMetadata, msg.Headers().Get(headerMetadata))
+
+		var opts []jetstream.PublishOpt
+		if id := msg.Headers().Get(nats.MsgIdHdr); id != "" {
+			opts = append(opts, jetstream.WithMsgID(strings.TrimPrefix(id, "delayed:")))
+		}
+
+		if _, err := p.js.PublishMsg(p.ctx, due, opts...); err != nil {
+			l.Error("failed to publish delayed event", kitslog.Err(err))
+			p.ack(l, msg.Nak)
+			return
+		}
+
+		p.ack(l, msg.Ack)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to consume delayed events: %w", err)
+	}
+
+	p.consumeContexts = append(p.consumeContexts, consumeContext)
+
+	return nil
+}
+
+// ack sends an acknowledgement (Ack, Nak, Term ...) and logs its error.
+func (p *NatsEventStore) ack(l *slog.Logger, ack func() error) {
+	if err := ack(); err != nil {
+		l.Error("failed to acknowledge message", kitslog.Err(err))
+	}
+}
+
+// durableName returns a valid durable name for the consumer, durable names cannot contain
+// whitespaces, ".", "*" nor ">".
+func durableName(consumerName string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(consumerName)
+}
+
+func maxRetries(consumer kitevent.Consumer) int32 {
+	if consumer.Options().MaxRetries != nil {
+		return *consumer.Options().MaxRetries
+	}
+
+	return 1
+}
+
+// callWithTimeout calls the consumer and returns context.DeadlineExceeded if it does not return before the timeout.
+func callWithTimeout(ctx context.Context, timeout time.Duration, call func() error) error {
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- call()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-chErr:
+		return err
+	}
+}
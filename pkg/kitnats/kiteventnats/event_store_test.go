@@ -0,0 +1,318 @@
+package kiteventnats
+
+import (
+	"context"
+	"errors"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	ID int
+}
+
+func (testEvent) EventName() kitevent.EventName {
+	return kitevent.NewEventName("test_event")
+}
+
+type testConsumer struct {
+	name    string
+	opts    *kitevent.ConsumerOptions
+	consume func(ctx context.Context, event *testEvent) error
+}
+
+func (c testConsumer) Consume(ctx context.Context, event *testEvent) error {
+	return c.consume(ctx, event)
+}
+
+func (c testConsumer) Options() *kitevent.ConsumerOptions {
+	return c.opts
+}
+
+func (c testConsumer) Name() string {
+	return c.name
+}
+
+// newTestNatsEventStore returns a store connected to an embedded nats-server with JetStream enabled.
+func newTestNatsEventStore(t *testing.T) *NatsEventStore {
+	ns, err := server.NewServer(&server.Options{
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	require.True(t, ns.ReadyForConnections(5*time.Second))
+
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+
+	store, err := New(nc, slog.Default(), &NatsEventStoreConfig{
+		StreamName:    "KITEVENT",
+		SubjectPrefix: "kitevent",
+		Storage:       "memory",
+		Replicas:      1,
+		Workers:       2,
+		BatchSize:     10,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = store.OnStop(context.Background()) })
+
+	return store
+}
+
+func TestNatsEventStore(t *testing.T) {
+	t.Run("consumes an event", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		received := make(chan int, 1)
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				received <- event.ID
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		select {
+		case id := <-received:
+			require.Equal(t, 1, id)
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not consumed")
+		}
+	})
+
+	t.Run("consumes the events matching a pattern", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		received := make(chan string, 2)
+		for _, pattern := range []string{"test_*", "other_*"} {
+			pattern := pattern
+			store.AddConsumer(kitevent.NewEventName(pattern), testConsumer{
+				name: pattern,
+				opts: kitevent.NewConsumerOptions(),
+				consume: func(ctx context.Context, event *testEvent) error {
+					received <- pattern
+					return nil
+				},
+			})
+		}
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		select {
+		case pattern := <-received:
+			require.Equal(t, "test_*", pattern)
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not consumed")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		require.Empty(t, received)
+	})
+
+	t.Run("redelivers a failed event until max retries", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		var calls atomic.Int32
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions().
+				WithMaxRetry(3).
+				WithRetryInterval(20 * time.Millisecond),
+			consume: func(ctx context.Context, event *testEvent) error {
+				calls.Add(1)
+				return errors.New("always fails")
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		require.Eventually(t, func() bool {
+			return calls.Load() == 3
+		}, 2*time.Second, 10*time.Millisecond)
+
+		time.Sleep(100 * time.Millisecond)
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("redelivers an event when the consumer timeout is reached", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		var calls atomic.Int32
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions().
+				WithMaxRetry(2).
+				WithTimeout(100 * time.Millisecond),
+			consume: func(ctx context.Context, event *testEvent) error {
+				if calls.Add(1) == 1 {
+					time.Sleep(time.Second)
+				}
+
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		require.Eventually(t, func() bool {
+			return calls.Load() == 2
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("delays the event until ProduceAt", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		consumed := make(chan time.Time, 1)
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				consumed <- time.Now()
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+
+		produceAt := time.Now().Add(200 * time.Millisecond)
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1},
+			kitevent.NewProducerOptions().WithProduceAt(produceAt)))
+
+		select {
+		case at := <-consumed:
+			require.False(t, at.Before(produceAt))
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not consumed")
+		}
+	})
+	t.Run("sends the event to the dead letter subject when max retries is reached", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions().WithMaxRetry(1),
+			consume: func(ctx context.Context, event *testEvent) error {
+				return errors.New("always fails")
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		stream, err := store.js.Stream(context.Background(), "KITEVENT")
+		require.NoError(t, err)
+
+		var msg *jetstream.RawStreamMsg
+		require.Eventually(t, func() bool {
+			msg, err = stream.GetLastMsgForSubject(context.Background(), "kitevent.dlq.test_event")
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond)
+
+		require.JSONEq(t, `{"ID": 1}`, string(msg.Data))
+		require.Equal(t, "test_consumer", msg.Header.Get(headerConsumer))
+		require.Equal(t, "always fails", msg.Header.Get(headerError))
+	})
+
+	t.Run("sends the event to the dead letter subject when the last delivery times out", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions().
+				WithMaxRetry(1).
+				WithTimeout(100 * time.Millisecond),
+			consume: func(ctx context.Context, event *testEvent) error {
+				time.Sleep(time.Second)
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		stream, err := store.js.Stream(context.Background(), "KITEVENT")
+		require.NoError(t, err)
+
+		var msg *jetstream.RawStreamMsg
+		require.Eventually(t, func() bool {
+			msg, err = stream.GetLastMsgForSubject(context.Background(), "kitevent.dlq.test_event")
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond)
+
+		require.Equal(t, "consumer timeout reached", msg.Header.Get(headerError))
+	})
+
+	t.Run("calls every consumer in ProduceSync", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		errFirst := errors.New("first consumer fails")
+		var called []string
+
+		for _, name := range []string{"first", "second"} {
+			name := name
+			store.AddConsumer(testEvent{}.EventName(), testConsumer{
+				name: name,
+				opts: kitevent.NewConsumerOptions(),
+				consume: func(ctx context.Context, event *testEvent) error {
+					called = append(called, name)
+					if name == "first" {
+						return errFirst
+					}
+
+					return nil
+				},
+			})
+		}
+
+		err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+		require.ErrorIs(t, err, errFirst)
+		require.Equal(t, []string{"first", "second"}, called)
+	})
+
+	t.Run("retries each consumer on its own in ProduceSync", func(t *testing.T) {
+		store := newTestNatsEventStore(t)
+
+		errFirst := errors.New("first consumer fails")
+		calls := map[string]int{}
+
+		for _, name := range []string{"first", "second"} {
+			name := name
+			store.AddConsumer(testEvent{}.EventName(), testConsumer{
+				name: name,
+				opts: kitevent.NewConsumerOptions().WithMaxRetry(3),
+				consume: func(ctx context.Context, event *testEvent) error {
+					calls[name]++
+					if name == "first" {
+						return errFirst
+					}
+
+					return nil
+				},
+			})
+		}
+
+		err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+		require.ErrorIs(t, err, errFirst)
+		require.Equal(t, map[string]int{"first": 4, "second": 1}, calls)
+	})
+}
@@ -0,0 +1,69 @@
+package kitnats
+
+import (
+	"context"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/pkg/kitnats/kiteventnats"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"math"
+)
+
+type Config struct {
+	URL  string `cfg:"url"`
+	Name string `cfg:"name"`
+
+	Options []nats.Option // manually configurable
+}
+
+func (c *Config) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".nats"
+
+	viper.SetDefault(prefix+".url", nats.DefaultURL)
+	viper.SetDefault(prefix+".name", "")
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal kitnats config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(Config))
+}
+
+type KitNats struct {
+	config     *Config
+	connection *nats.Conn
+}
+
+func Module(app *kitcat.App, config *Config) {
+	m := &KitNats{config: config}
+
+	app.Provides(
+		kitcat.ProvideConfigurableModule(m),
+		kitevent.ProvideStore(kiteventnats.New),
+	)
+}
+
+func (m *KitNats) Configure(_ context.Context, app *kitcat.App) error {
+	opts := m.config.Options
+	if m.config.Name != "" {
+		opts = append(opts, nats.Name(m.config.Name))
+	}
+
+	nc, err := nats.Connect(m.config.URL, opts...)
+	if err != nil {
+		return err
+	}
+
+	m.connection = nc
+
+	app.Provides(nc)
+
+	return nil
+}
+
+func (m *KitNats) Priority() uint8 { return math.MaxUint8 }
+
+func (m *KitNats) Name() string {
+	return "kitnats"
+}
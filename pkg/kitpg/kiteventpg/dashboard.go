@@ -0,0 +1,240 @@
+package kiteventpg
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/kitcat-framework/kitcat/kitweb"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+//go:embed dashboard.gohtml
+var dashboardTemplate string
+
+type DashboardConfig struct {
+	// Enabled registers the dashboard on kitweb when kitevent uses the postgres store. It allows to
+	// retry, skip and purge the handler results: protect it with a basic auth or Middlewares.
+	// Default: false
+	Enabled bool `cfg:"enabled"`
+
+	// Path is the path of the dashboard
+	Path string `cfg:"path"`
+
+	// BasicAuthUser and BasicAuthPassword protect the dashboard with a basic authentication when set
+	BasicAuthUser     string `cfg:"basic_auth_user"`
+	BasicAuthPassword string `cfg:"basic_auth_password"`
+
+	// FailedLimit is the number of failed handler results shown
+	FailedLimit int `cfg:"failed_limit"`
+
+	// Middlewares are applied to every route of the dashboard, see kitweb.Router.Route
+	Middlewares []any // manually configurable
+}
+
+func (c *DashboardConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitevent.config_stores.postgres.dashboard"
+
+	viper.SetDefault(prefix+".enabled", false)
+	viper.SetDefault(prefix+".path", "/_kitevent")
+	viper.SetDefault(prefix+".basic_auth_user", "")
+	viper.SetDefault(prefix+".basic_auth_password", "")
+	viper.SetDefault(prefix+".failed_limit", 100)
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal postgres event store dashboard config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(DashboardConfig))
+}
+
+// Dashboard is a kitweb handler showing the state of the consumers of the postgres store,
+// and allowing to retry, skip or purge the failed handler results.
+type Dashboard struct {
+	config   *DashboardConfig
+	store    *PgEventStore
+	template *template.Template
+	logger   *slog.Logger
+}
+
+func NewDashboard(db *gorm.DB, config *DashboardConfig, storeConfig *PostgresEventStoreConfig) (*Dashboard, error) {
+	store := NewPgEventStore(db)
+	if storeConfig.Notify {
+		store = store.WithNotifyChannel(storeConfig.NotifyChannel)
+	}
+
+	tmpl, err := template.New("dashboard").Funcs(template.FuncMap{
+		"since": func(t *time.Time) string {
+			if t == nil {
+				return "-"
+			}
+
+			return time.Since(*t).Truncate(time.Millisecond).String()
+		},
+		"duration": func(ms *int64) string {
+			if ms == nil {
+				return "-"
+			}
+
+			return (time.Duration(*ms) * time.Millisecond).String()
+		},
+	}).Parse(dashboardTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dashboard template: %w", err)
+	}
+
+	return &Dashboard{
+		config:   config,
+		store:    store,
+		template: tmpl,
+		logger: slog.With(
+			kitslog.Module("kitevent"),
+			slog.String("store", "postgres"),
+			slog.String("handler", "dashboard")),
+	}, nil
+}
+
+func (d *Dashboard) Routes(r *kitweb.Router) {
+	middlewares := d.config.Middlewares
+	if d.config.BasicAuthUser != "" {
+		middlewares = append([]any{d.basicAuth}, middlewares...)
+	}
+
+	if len(middlewares) == 0 {
+		d.logger.Warn("the dashboard is not protected, set a basic auth or middlewares",
+			slog.String("path", d.config.Path))
+	}
+
+	r.Get(d.config.Path, d.index, middlewares...)
+
+	actionMiddlewares := append([]any{d.sameOrigin}, middlewares...)
+	r.Post(d.config.Path+"/retry", d.retry, actionMiddlewares...)
+	r.Post(d.config.Path+"/skip", d.skip, actionMiddlewares...)
+	r.Post(d.config.Path+"/purge", d.purge, actionMiddlewares...)
+}
+
+// sameOrigin rejects the requests whose Origin, or Referer without Origin, is not the host of the
+// dashboard. The actions are plain forms, another site could post them with the credentials of the user.
+func (d *Dashboard) sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := r.Header.Get("Origin")
+		if source == "" {
+			source = r.Header.Get("Referer")
+		}
+
+		u, err := url.Parse(source)
+		if source == "" || err != nil || u.Host != r.Host {
+			d.logger.Warn("dashboard: cross-origin request rejected",
+				slog.String("origin", source), slog.String("host", r.Host))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Dashboard) basicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(d.config.BasicAuthUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(d.config.BasicAuthPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="kitevent"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Dashboard) index(w http.ResponseWriter, r *http.Request) {
+	stats, err := d.store.ConsumerStats(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	failed, err := d.store.FindFailed(r.Context(), d.config.FailedLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = d.template.Execute(w, map[string]any{
+		"Path":   d.config.Path,
+		"Stats":  stats,
+		"Failed": failed,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (d *Dashboard) retry(w http.ResponseWriter, r *http.Request) {
+	d.updateFailed(w, r, "retry", d.store.RetryFailed)
+}
+
+func (d *Dashboard) skip(w http.ResponseWriter, r *http.Request) {
+	d.updateFailed(w, r, "skip", d.store.SkipFailed)
+}
+
+func (d *Dashboard) updateFailed(
+	w http.ResponseWriter,
+	r *http.Request,
+	action string,
+	update func(ctx context.Context, id int32) error,
+) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	err = update(r.Context(), int32(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.logger.Info("dashboard: "+action+" event handler", slog.Int64("id", id))
+	http.Redirect(w, r, d.config.Path, http.StatusSeeOther)
+}
+
+func (d *Dashboard) purge(w http.ResponseWriter, r *http.Request) {
+	consumerName := r.FormValue("consumer")
+	if consumerName == "" {
+		http.Error(w, "invalid consumer", http.StatusBadRequest)
+		return
+	}
+
+	n, err := d.store.PurgeFailed(r.Context(), consumerName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.logger.Info("dashboard: purged event handlers",
+		slog.String("consumer", consumerName), slog.Int64("count", n))
+	http.Redirect(w, r, d.config.Path, http.StatusSeeOther)
+}
+
+func (d *Dashboard) Name() string {
+	return "kitevent_dashboard"
+}
@@ -0,0 +1,48 @@
+package kiteventpg
+
+import (
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDashboard_sameOrigin(t *testing.T) {
+	d := &Dashboard{config: &DashboardConfig{Path: "/_kitevent"}, logger: slog.Default()}
+	handler := d.sameOrigin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected int
+	}{
+		{name: "same origin", headers: map[string]string{"Origin": "http://example.com"}, expected: http.StatusNoContent},
+		{name: "same referer", headers: map[string]string{"Referer": "http://example.com/_kitevent"}, expected: http.StatusNoContent},
+		{name: "cross origin", headers: map[string]string{"Origin": "http://evil.com"}, expected: http.StatusForbidden},
+		{name: "cross referer", headers: map[string]string{"Referer": "http://evil.com/form"}, expected: http.StatusForbidden},
+		{
+			name:     "origin over referer",
+			headers:  map[string]string{"Origin": "http://evil.com", "Referer": "http://example.com/_kitevent"},
+			expected: http.StatusForbidden,
+		},
+		{name: "opaque origin", headers: map[string]string{"Origin": "null"}, expected: http.StatusForbidden},
+		{name: "no origin", expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/_kitevent/retry", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.expected, rec.Code)
+		})
+	}
+}
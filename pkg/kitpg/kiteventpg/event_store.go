@@ -5,9 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitevent"
@@ -16,24 +13,66 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/samber/lo"
 	"github.com/spf13/viper"
-	"gorm.io/datatypes"
 	"gorm.io/gorm"
 	"log/slog"
-	"path/filepath"
-	"runtime"
 	"time"
 )
 
 type PostgresEventStoreConfig struct {
+	// PollInterval is the interval between two polls when Notify is disabled or the store
+	// is not listening (e.g. during a reconnection)
 	PollInterval time.Duration `cfg:"poll_interval"`
 	CreateSchema bool          `cfg:"create_schema"`
+
+	// AutoMigrate applies the migrations of the kitevent schema on start, disable it to apply
+	// them through your own pipeline (see MigrationsSQL and `kitcat-cli migrate kitevent-sql`)
+	AutoMigrate bool `cfg:"auto_migrate"`
+
+	// Notify makes Produce NOTIFY the NotifyChannel, and the store LISTEN to it to process
+	// the events as soon as they are committed
+	Notify        bool   `cfg:"notify"`
+	NotifyChannel string `cfg:"notify_channel"`
+
+	// FallbackPollInterval is the interval between two polls while the store listens to the NotifyChannel,
+	// it catches missed notifications and delayed events
+	FallbackPollInterval time.Duration `cfg:"fallback_poll_interval"`
+
+	// Workers is the maximum number of events processed in parallel by the store
+	Workers int `cfg:"workers"`
+
+	// BatchSize is the maximum number of events claimed per consumer in a single query
+	BatchSize int `cfg:"batch_size"`
+
+	// RecordSyncHistory makes ProduceSync write the event and a handler result per attempt, with
+	// their status, duration and error, so sync dispatches appear in the history like async ones
+	RecordSyncHistory bool `cfg:"record_sync_history"`
+
+	Retention RetentionConfig `cfg:"retention"`
 }
 
+// defaultWorkers and defaultBatchSize replace a Workers or BatchSize lower than 1, with which no
+// event would ever be claimed
+const (
+	defaultWorkers   = 10
+	defaultBatchSize = 10
+)
+
 func (c *PostgresEventStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
 	prefix = prefix + ".kitevent.config_stores.postgres"
 
 	viper.SetDefault(prefix+".poll_interval", time.Millisecond*500)
 	viper.SetDefault(prefix+".create_schema", true)
+	viper.SetDefault(prefix+".auto_migrate", true)
+	viper.SetDefault(prefix+".notify", true)
+	viper.SetDefault(prefix+".notify_channel", "kitevent_events")
+	viper.SetDefault(prefix+".fallback_poll_interval", time.Second*10)
+	viper.SetDefault(prefix+".workers", defaultWorkers)
+	viper.SetDefault(prefix+".batch_size", defaultBatchSize)
+	viper.SetDefault(prefix+".record_sync_history", false)
+	viper.SetDefault(prefix+".retention.interval", time.Hour)
+	viper.SetDefault(prefix+".retention.batch_size", 1000)
+	viper.SetDefault(prefix+".retention.archive_retention", 0)
+	viper.SetDefault(prefix+".retention.partition_archive", false)
 
 	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal postgres event store config: %w")
 }
@@ -43,7 +82,7 @@ func init() {
 }
 
 type PostgresEventStore struct {
-	handlers   map[kitevent.EventName][]kitevent.Consumer
+	consumers  *kitevent.ConsumerRegistry
 	db         *gorm.DB
 	store      EventStoreStorage
 	logger     *slog.Logger
@@ -51,21 +90,54 @@ type PostgresEventStore struct {
 	cancelFunc context.CancelFunc
 
 	config *PostgresEventStoreConfig
+
+	// workerSlots and consumerSlots are semaphores, an event is claimed only when a slot is free
+	// in both of them, so claimed events never wait for a worker
+	workerSlots   chan struct{}
+	consumerSlots map[string]chan struct{}
+
+	notifier *notifier
 }
 
 func New(db *gorm.DB, logger *slog.Logger, config *PostgresEventStoreConfig) *PostgresEventStore {
 	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	config = withDefaults(config)
+
+	store := NewPgEventStore(db)
+	if config.Notify {
+		store = store.WithNotifyChannel(config.NotifyChannel)
+	}
+
 	return &PostgresEventStore{
 		db: db,
 		logger: logger.With(
 			kitslog.Module("kitevent"),
 			slog.String("store", "postgres")),
-		handlers:   make(map[kitevent.EventName][]kitevent.Consumer),
-		store:      NewPgEventStore(db),
-		ctx:        ctx,
-		cancelFunc: cancelFunc,
-		config:     config,
+		consumers:     kitevent.NewConsumerRegistry(),
+		store:         store,
+		ctx:           ctx,
+		cancelFunc:    cancelFunc,
+		config:        config,
+		workerSlots:   make(chan struct{}, config.Workers),
+		consumerSlots: make(map[string]chan struct{}),
+		notifier:      newNotifier(),
+	}
+}
+
+// withDefaults returns a copy of the config with the defaults of the Workers and the BatchSize
+// lower than 1.
+func withDefaults(config *PostgresEventStoreConfig) *PostgresEventStoreConfig {
+	c := *config
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
 	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+
+	return &c
 }
 
 func (p PostgresEventStore) OnStart(ctx context.Context) error {
@@ -76,37 +148,28 @@ func (p PostgresEventStore) OnStart(ctx context.Context) error {
 		}
 	}
 
-	db, err := p.db.DB()
-	if err != nil {
-		return fmt.Errorf("failed to get db instance: %w", err)
+	if p.config.AutoMigrate {
+		if err := Migrate(p.db); err != nil {
+			return err
+		}
 	}
 
-	driver, err := postgres.WithInstance(db, &postgres.Config{
-		SchemaName: "kitevent",
-	})
-
-	_, currentFile, _, _ := runtime.Caller(0)
-	libraryPath := filepath.Dir(currentFile)
-	migrationsPath := filepath.Join(libraryPath, "migrations")
-
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://"+migrationsPath,
-		"postgres", driver)
-	if err != nil {
-		return fmt.Errorf("failed to create migration instance: %w", err)
-	}
-	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		return err
+	if p.config.Notify {
+		go p.listen(p.ctx)
 	}
 
 	go p.run(p.ctx)
 	go p.monitorTimeoutEvents(p.ctx)
 
+	if p.config.Retention.Interval > 0 {
+		go p.runRetention(p.ctx)
+	}
+
 	return nil
 }
 
 func (p PostgresEventStore) Produce(ctx context.Context, event kitevent.Event, opt *kitevent.ProducerOptions) error {
-	handlersConcerned := p.handlers[event.EventName()]
+	handlersConcerned := p.consumers.Get(event.EventName())
 	if len(handlersConcerned) == 0 {
 		return errors.New("no consumer found for event")
 	}
@@ -120,11 +183,11 @@ func (p PostgresEventStore) Produce(ctx context.Context, event kitevent.Event, o
 		opt = kitevent.NewProducerOptions()
 	}
 
-	evt := Event{
-		Payload:   datatypes.JSON(marshalPayload),
-		EventName: event.EventName().Name,
-		CreatedAt: pgutils.TimestampUTC(time.Now()),
-		UpdatedAt: pgutils.TimestampUTC(time.Now()),
+	opt.WithEventVersion(event)
+
+	evt, err := newEvent(event, marshalPayload, opt.Metadata)
+	if err != nil {
+		return err
 	}
 
 	processors := make([]*EventProcessingState, len(handlersConcerned))
@@ -180,29 +243,41 @@ func (p PostgresEventStore) ProduceSync(
 		opts = kitevent.NewProducerOptions()
 	}
 
-	handlers, ok := p.handlers[event.EventName()]
+	handlers := p.consumers.Get(event.EventName())
 
-	if !ok {
-		return nil
+	if p.config.RecordSyncHistory {
+		return p.produceSyncWithHistory(ctx, event, opts, handlers)
 	}
 
+	var errs []error
+
 	for _, handler := range handlers {
-		return kitevent.LocalCallHandler(kitevent.LocalCallConsumerParams{
+		// each consumer counts its own retries
+		consumerOpts := *opts
+
+		err := kitevent.LocalCallHandler(kitevent.LocalCallConsumerParams{
 			Ctx:           ctx,
 			Event:         event,
 			Producer:      p,
-			Opts:          opts,
+			Opts:          &consumerOpts,
 			Consumer:      handler,
 			Logger:        p.logger,
 			IsProduceSync: true,
 		})
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 func (p PostgresEventStore) AddConsumer(eventName kitevent.EventName, handler kitevent.Consumer) {
-	p.handlers[eventName] = append(p.handlers[eventName], handler)
+	p.consumers.Add(eventName, handler)
+
+	if concurrency := handler.Options().Concurrency; concurrency != nil {
+		p.consumerSlots[handler.Name()] = make(chan struct{}, *concurrency)
+	}
 }
 
 func (p PostgresEventStore) OnStop(_ context.Context) error {
@@ -215,43 +290,226 @@ func (p PostgresEventStore) Name() string {
 	return "postgres"
 }
 
-// run is a blocking function that will loop over handler results to find those who are in EventProcessingStateStatusAvailable status.
-// If one is found, it will call the consumer associated to the event and update the handler result accordingly.
-// If none is found, it will wait for 500ms and try again. Basic polling.
+// run is a blocking function that will claim, for each consumer, a batch of handler results in
+// EventProcessingStateStatusAvailable status, as many as there are free worker slots for this consumer.
+// Each claimed handler result is processed in its own goroutine and updated accordingly, the handler results
+// of a batch consumer (see kitevent.IsBatchConsumer) are processed together in a single goroutine.
+// If nothing is claimed, it waits for a notification, a free slot or the poll interval and tries again.
 func (p PostgresEventStore) run(ctx context.Context) {
 	for {
-		eventHandlerResult, err := p.nextEvent(ctx)
-		if err != nil || eventHandlerResult == nil {
-			time.Sleep(p.config.PollInterval)
-			continue
+		select {
+		case <-ctx.Done():
+			return
+		default:
 		}
 
-		handlers := p.handlers[kitevent.NewEventName(eventHandlerResult.Event.EventName)]
-		if len(handlers) == 0 {
-			continue
+		claimed := 0
+
+		for _, consumer := range p.consumers.All() {
+			consumerName := consumer.Name()
+			isBatchConsumer := kitevent.IsBatchConsumer(consumer)
+
+			limit := p.freeSlots(consumerName)
+			if limit == 0 {
+				continue
+			}
+
+			if isBatchConsumer {
+				limit = p.batchSize(consumer)
+			}
+
+			evtProcessingStates, err := p.nextEvents(ctx, consumerName, limit)
+			if err != nil {
+				p.logger.Error("failed to claim events", kitslog.Err(err), slog.String("consumer", consumerName))
+				continue
+			}
+
+			if isBatchConsumer && len(evtProcessingStates) > 0 {
+				p.dispatchBatch(ctx, consumer, evtProcessingStates)
+			} else {
+				for _, evtProcessingState := range evtProcessingStates {
+					p.dispatch(ctx, evtProcessingState)
+				}
+			}
+
+			claimed += len(evtProcessingStates)
+		}
+
+		if claimed == 0 && !p.notifier.events.wait(ctx, p.pollInterval()) {
+			return
 		}
+	}
+}
+
+// freeSlots returns the number of events that can be claimed right now for a consumer.
+// It is only called from run, which is the only goroutine taking slots.
+func (p PostgresEventStore) freeSlots(consumerName string) int {
+	free := min(cap(p.workerSlots)-len(p.workerSlots), p.config.BatchSize)
+
+	if slots, ok := p.consumerSlots[consumerName]; ok {
+		free = min(free, cap(slots)-len(slots))
+	}
+
+	return free
+}
+
+// batchSize returns the maximum number of handler results given at once to a batch consumer.
+func (p PostgresEventStore) batchSize(consumer kitevent.Consumer) int {
+	if batchSize := consumer.Options().BatchSize; batchSize != nil {
+		return int(*batchSize)
+	}
 
-		for _, handler := range handlers {
-			if eventHandlerResult.ConsumerName != handler.Name() {
+	return p.config.BatchSize
+}
+
+// acquireSlots takes a worker slot (and a consumer slot if any), the returned function releases them.
+func (p PostgresEventStore) acquireSlots(consumerName string) func() {
+	consumerSlots, hasConsumerSlots := p.consumerSlots[consumerName]
+
+	p.workerSlots <- struct{}{}
+	if hasConsumerSlots {
+		consumerSlots <- struct{}{}
+	}
+
+	return func() {
+		<-p.workerSlots
+		if hasConsumerSlots {
+			<-consumerSlots
+		}
+
+		// events may have been left unclaimed because the slots were full
+		p.notifier.events.wake()
+	}
+}
+
+// dispatch takes a worker slot (and a consumer slot if any) and processes the handler result in a goroutine.
+func (p PostgresEventStore) dispatch(ctx context.Context, evtProcessingState *EventProcessingState) {
+	if evtProcessingState.Event == nil {
+		p.logger.Error("event not found for handler result", slog.Int("event_id", int(evtProcessingState.EventID)))
+		return
+	}
+
+	release := p.acquireSlots(evtProcessingState.ConsumerName)
+
+	go func() {
+		defer release()
+
+		handler, ok := p.consumers.Find(
+			kitevent.NewEventName(evtProcessingState.Event.EventName),
+			evtProcessingState.ConsumerName)
+		if !ok {
+			p.logger.Warn("no consumer registered for handler result",
+				slog.String("consumer", evtProcessingState.ConsumerName),
+				slog.String("event_name", evtProcessingState.Event.EventName))
+			return
+		}
+
+		envelope := evtProcessingState.Event.Envelope()
+
+		event, err := kitevent.ConsumerEvent(handler, envelope, envelope.Metadata)
+		if err != nil {
+			p.deadLetter(ctx, evtProcessingState, fmt.Errorf("failed to convert payload to event: %w", err))
+			return
+		}
+
+		p.processConsumer(
+			kitevent.ContextWithMetadata(ctx, envelope.Metadata),
+			event,
+			handler,
+			evtProcessingState,
+		)
+	}()
+}
+
+// dispatchBatch takes a worker slot (and a consumer slot if any) and processes the handler results
+// with a single call to ConsumeBatch in a goroutine.
+func (p PostgresEventStore) dispatchBatch(
+	ctx context.Context,
+	consumer kitevent.Consumer,
+	evtProcessingStates []*EventProcessingState,
+) {
+	release := p.acquireSlots(consumer.Name())
+
+	go func() {
+		defer release()
+
+		var (
+			events      = make([]kitevent.Event, 0, len(evtProcessingStates))
+			batchStates = make([]*EventProcessingState, 0, len(evtProcessingStates))
+		)
+
+		for _, evtProcessingState := range evtProcessingStates {
+			if evtProcessingState.Event == nil {
+				p.logger.Error("event not found for handler result",
+					slog.Int("event_id", int(evtProcessingState.EventID)))
 				continue
 			}
 
-			event, err := kitevent.PayloadToEvent(handler, eventHandlerResult.Event.Payload)
+			envelope := evtProcessingState.Event.Envelope()
+
+			event, err := kitevent.ConsumerEvent(consumer, envelope, envelope.Metadata)
 			if err != nil {
-				p.logger.Error("failed to convert payload to event", kitslog.Err(err))
+				p.deadLetter(ctx, evtProcessingState, fmt.Errorf("failed to convert payload to event: %w", err))
 				continue
 			}
 
-			// TODO: process them in a pool to avoid having blocking handler
-			p.processConsumer(
-				ctx,
-				event,
-				handler,
-				eventHandlerResult,
-			)
+			events = append(events, event)
+			batchStates = append(batchStates, evtProcessingState)
+		}
+
+		if len(events) == 0 {
+			return
+		}
+
+		startHandlerAt := time.Now()
+
+		l := p.logger.With(slog.String("consumer", consumer.Name()))
+		l.Info("processing batch", slog.Int("size", len(events)))
+
+		err := p.callWithTimeout(ctx, batchTimeout(batchStates), func() error {
+			return kitevent.CallBatchConsumer(ctx, consumer, events)
+		})
 
-			break
+		var results []*EventProcessingState
+		for i, itemErr := range kitevent.BatchItemErrors(err, len(events)) {
+			results = append(results, p.result(
+				l.With(
+					slog.Int("event_id", int(batchStates[i].EventID)),
+					slog.String("event_name", batchStates[i].Event.EventName)),
+				batchStates[i],
+				itemErr,
+				startHandlerAt,
+			)...)
 		}
+
+		p.saveResults(ctx, l, results)
+	}()
+}
+
+// batchTimeout returns the smallest timeout of the handler results, the batch must return before
+// any of them is considered timed out.
+func batchTimeout(evtProcessingStates []*EventProcessingState) time.Duration {
+	timeoutMs := evtProcessingStates[0].ConsumerOptionTimeoutMs
+	for _, evtProcessingState := range evtProcessingStates[1:] {
+		timeoutMs = min(timeoutMs, evtProcessingState.ConsumerOptionTimeoutMs)
+	}
+
+	return time.Duration(timeoutMs) * time.Millisecond
+}
+
+// deadLetter marks the handler result as EventProcessingStateStatusDeadLetter, it will never be retried.
+func (p PostgresEventStore) deadLetter(ctx context.Context, evtProcessingState *EventProcessingState, err error) {
+	p.logger.Error("sending event to dead letter queue", kitslog.Err(err),
+		slog.Int("event_id", int(evtProcessingState.EventID)),
+		slog.String("consumer", evtProcessingState.ConsumerName))
+
+	evtProcessingState.UpdatedAt = pgutils.TimestampUTC(time.Now())
+	evtProcessingState.Error = lo.ToPtr(err.Error())
+	evtProcessingState.Status = EventProcessingStateStatusDeadLetter
+	evtProcessingState.FailedAt = lo.ToPtr(pgutils.TimestampUTC(time.Now()))
+
+	if err := p.store.SaveEventHandlers(ctx, []*EventProcessingState{evtProcessingState}); err != nil {
+		p.logger.Error("failed to save event consumer", kitslog.Err(err))
 	}
 }
 
@@ -259,7 +517,10 @@ func (p PostgresEventStore) monitorTimeoutEvents(ctx context.Context) {
 	for {
 		evtProcessingState, err := p.nextEventInTimeout(ctx)
 		if err != nil || evtProcessingState == nil {
-			time.Sleep(p.config.PollInterval)
+			if !p.notifier.timeouts.wait(ctx, p.pollInterval()) {
+				return
+			}
+
 			continue
 		}
 
@@ -302,6 +563,8 @@ func (p PostgresEventStore) monitorTimeoutEvents(ctx context.Context) {
 		if err != nil {
 			l.Error("failed to save event consumer", kitslog.Err(err))
 		}
+
+		p.wakeForRetries(nextEvtProcessingStateResult)
 	}
 }
 
@@ -320,8 +583,8 @@ func (p PostgresEventStore) processConsumer(
 
 	l.Info("processing event")
 
-	var err error
-	chErr := wrapResultAsChanErr(func() error {
+	timeout := time.Duration(evtProcessingState.ConsumerOptionTimeoutMs) * time.Millisecond
+	err := p.callWithTimeout(ctx, timeout, func() error {
 		return kitevent.CallConsumer(kitevent.CallConsumerParams{
 			Ctx:     ctx,
 			Event:   evt,
@@ -329,18 +592,33 @@ func (p PostgresEventStore) processConsumer(
 		})
 	})
 
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(evtProcessingState.ConsumerOptionTimeoutMs)*time.Millisecond)
+	p.saveResults(ctx, l, p.result(l, evtProcessingState, err, startHandlerAt))
+}
+
+// callWithTimeout calls the consumer and returns context.DeadlineExceeded if it does not return
+// before the timeout.
+func (p PostgresEventStore) callWithTimeout(ctx context.Context, timeout time.Duration, call func() error) error {
+	chErr := wrapResultAsChanErr(call)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	select {
 	case <-ctx.Done():
-		err = ctx.Err()
-		break
-	case cerr := <-chErr:
-		err = cerr
-		break
+		return ctx.Err()
+	case err := <-chErr:
+		return err
 	}
+}
 
+// result updates the handler result with the error returned by the consumer, it returns the handler
+// results to save: the updated one and its retry if any. Nothing must be saved on timeout.
+func (p PostgresEventStore) result(
+	l *slog.Logger,
+	evtProcessingState *EventProcessingState,
+	err error,
+	startHandlerAt time.Time,
+) []*EventProcessingState {
 	var (
 		nextEvtProcessingStateResult []*EventProcessingState
 	)
@@ -350,8 +628,10 @@ func (p PostgresEventStore) processConsumer(
 	evtProcessingState.DurationMs = time.Since(startHandlerAt).Milliseconds()
 
 	if errors.Is(err, context.DeadlineExceeded) {
-		// should be handled by monitorTimeoutEvents
-		return
+		// should be handled by monitorTimeoutEvents, timeout_at is computed by the database
+		// from pending_at so it may not be reached yet
+		p.notifier.timeouts.wakeAt(time.Now().Add(p.config.PollInterval))
+		return nil
 	}
 
 	if err != nil {
@@ -385,10 +665,29 @@ func (p PostgresEventStore) processConsumer(
 		evtProcessingState.SuccessAt = lo.ToPtr(pgutils.TimestampUTC(time.Now()))
 	}
 
-	err = p.store.SaveEventHandlers(ctx, append(nextEvtProcessingStateResult, evtProcessingState))
-	if err != nil {
+	return append(nextEvtProcessingStateResult, evtProcessingState)
+}
+
+// saveResults saves the handler results in a single query and wakes the runner for the retries.
+func (p PostgresEventStore) saveResults(ctx context.Context, l *slog.Logger, results []*EventProcessingState) {
+	if len(results) == 0 {
+		return
+	}
+
+	if err := p.store.SaveEventHandlers(ctx, results); err != nil {
 		l.Error("failed to save event consumer", kitslog.Err(err))
 	}
+
+	p.wakeForRetries(lo.Filter(results, func(result *EventProcessingState, _ int) bool {
+		return result.Status == EventProcessingStateStatusAvailable
+	}))
+}
+
+// wakeForRetries wakes the runner when the retries become processable, they are not notified.
+func (p PostgresEventStore) wakeForRetries(retries []*EventProcessingState) {
+	for _, retry := range retries {
+		p.notifier.events.wakeAt(retry.ProcessableAt.Time)
+	}
 }
 
 func (p PostgresEventStore) nextProcessableAt(evtProcessingState *EventProcessingState) pgtype.Timestamp {
@@ -396,16 +695,20 @@ func (p PostgresEventStore) nextProcessableAt(evtProcessingState *EventProcessin
 		time.Duration(evtProcessingState.ConsumerOptionRetryIntervalMs) * time.Millisecond))
 }
 
-func (p PostgresEventStore) nextEvent(ctx context.Context) (*EventProcessingState, error) {
+func (p PostgresEventStore) nextEvents(
+	ctx context.Context,
+	consumerName string,
+	limit int,
+) ([]*EventProcessingState, error) {
 	ctx, cancelCtx := context.WithTimeout(ctx, time.Second)
 	defer cancelCtx()
 
-	evtHandlerResult, err := p.store.FindAvailableEvent(ctx)
+	evtHandlerResults, err := p.store.FindAvailableEvents(ctx, consumerName, limit)
 	if err != nil {
 		return nil, err
 	}
 
-	return evtHandlerResult, nil
+	return evtHandlerResults, nil
 }
 
 func (p PostgresEventStore) nextEventInTimeout(ctx context.Context) (*EventProcessingState, error) {
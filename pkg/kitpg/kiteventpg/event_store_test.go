@@ -0,0 +1,113 @@
+package kiteventpg
+
+import (
+	"context"
+	"errors"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	ID int `json:"id"`
+}
+
+func (testEvent) EventName() kitevent.EventName {
+	return kitevent.NewEventName("test_event")
+}
+
+type testConsumer struct {
+	name    string
+	options *kitevent.ConsumerOptions
+	consume func(ctx context.Context, event *testEvent) error
+}
+
+func (c testConsumer) Consume(ctx context.Context, event *testEvent) error {
+	return c.consume(ctx, event)
+}
+
+func (c testConsumer) Options() *kitevent.ConsumerOptions {
+	if c.options != nil {
+		return c.options
+	}
+
+	return kitevent.NewConsumerOptions()
+}
+
+func (c testConsumer) Name() string {
+	return c.name
+}
+
+func TestPostgresEventStore_ProduceSync(t *testing.T) {
+	store := New(nil, slog.Default(), &PostgresEventStoreConfig{Workers: 1})
+
+	errFirst := errors.New("first consumer failed")
+	var called []string
+
+	for _, name := range []string{"first", "second"} {
+		name := name
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: name,
+			consume: func(ctx context.Context, event *testEvent) error {
+				called = append(called, name)
+				if name == "first" {
+					return errFirst
+				}
+
+				return nil
+			},
+		})
+	}
+
+	err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+	require.ErrorIs(t, err, errFirst)
+	require.Equal(t, []string{"first", "second"}, called)
+}
+
+func TestPostgresEventStore_ProduceSyncRetries(t *testing.T) {
+	store := New(nil, slog.Default(), &PostgresEventStoreConfig{Workers: 1})
+
+	errFirst := errors.New("first consumer failed")
+	calls := map[string]int{}
+
+	for _, name := range []string{"first", "second"} {
+		name := name
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name:    name,
+			options: kitevent.NewConsumerOptions().WithMaxRetry(3),
+			consume: func(ctx context.Context, event *testEvent) error {
+				calls[name]++
+				if name == "first" {
+					return errFirst
+				}
+
+				return nil
+			},
+		})
+	}
+
+	err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+	require.ErrorIs(t, err, errFirst)
+	require.Equal(t, map[string]int{"first": 4, "second": 1}, calls)
+}
+
+func TestBatchTimeout(t *testing.T) {
+	timeout := batchTimeout([]*EventProcessingState{
+		{ConsumerOptionTimeoutMs: 3000},
+		{ConsumerOptionTimeoutMs: 1000},
+		{ConsumerOptionTimeoutMs: 2000},
+	})
+
+	require.Equal(t, time.Second, timeout)
+}
+
+func TestNew_DefaultsWorkersAndBatchSize(t *testing.T) {
+	config := &PostgresEventStoreConfig{}
+	store := New(nil, slog.Default(), config)
+
+	require.Equal(t, defaultWorkers, cap(store.workerSlots))
+	require.Equal(t, defaultBatchSize, store.config.BatchSize)
+	require.Zero(t, config.Workers)
+}
@@ -0,0 +1,177 @@
+package kiteventpg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/kitcat-framework/kitcat/pkg/kitpg/pgutils"
+	"github.com/samber/lo"
+	"gorm.io/datatypes"
+	"log/slog"
+	"time"
+)
+
+// MetadataKeyProduceSync is set to true in the metadata of the events recorded by ProduceSync
+const MetadataKeyProduceSync = "produce_sync"
+
+func newEvent(event kitevent.Event, payload []byte, metadata map[string]any) (Event, error) {
+	marshalMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	return Event{
+		Payload:   datatypes.JSON(payload),
+		Metadata:  datatypes.JSON(marshalMetadata),
+		EventName: event.EventName().Name,
+		CreatedAt: pgutils.TimestampUTC(time.Now()),
+		UpdatedAt: pgutils.TimestampUTC(time.Now()),
+	}, nil
+}
+
+// produceSyncWithHistory calls the consumers like kitevent.LocalCallHandler, retrying immediately up to
+// their MaxRetries, then records the event and a handler result per attempt of every consumer.
+// The errors of the consumers are joined.
+//
+// The rows are written within the kittx transaction of the context if any, they are rolled back with it.
+func (p PostgresEventStore) produceSyncWithHistory(
+	ctx context.Context,
+	event kitevent.Event,
+	opts *kitevent.ProducerOptions,
+	consumers []kitevent.Consumer,
+) error {
+	if len(consumers) == 0 {
+		return nil
+	}
+
+	opts.WithEventVersion(event)
+
+	metadata := map[string]any{MetadataKeyProduceSync: true}
+	for key, value := range opts.Metadata {
+		metadata[key] = value
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	evt, err := newEvent(event, payload, metadata)
+	if err != nil {
+		return err
+	}
+
+	var (
+		attempts []*EventProcessingState
+		errs     []error
+	)
+
+	for _, consumer := range consumers {
+		consumerAttempts, err := p.callSync(ctx, event, opts, consumer)
+		attempts = append(attempts, consumerAttempts...)
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := p.store.AddEvent(ctx, evt, attempts); err != nil {
+		p.logger.Error("failed to record sync history", kitslog.Err(err),
+			slog.String("event_name", event.EventName().Name))
+	}
+
+	return errors.Join(errs...)
+}
+
+// callSync calls the consumer until it succeeds or reaches its MaxRetries, it returns the handler
+// result of every attempt and the error of the last one.
+func (p PostgresEventStore) callSync(
+	ctx context.Context,
+	event kitevent.Event,
+	opts *kitevent.ProducerOptions,
+	consumer kitevent.Consumer,
+) ([]*EventProcessingState, error) {
+	maxRetries := int32(1)
+	if consumer.Options().MaxRetries != nil {
+		maxRetries = *consumer.Options().MaxRetries
+	}
+
+	l := p.logger.With(
+		slog.String("consumer", consumer.Name()),
+		slog.String("event_name", event.EventName().Name))
+
+	consumerEvent, err := kitevent.ConsumerEvent(consumer, event, opts.Metadata)
+	if err != nil {
+		err = fmt.Errorf("unable to convert event for consumer %s: %w", consumer.Name(), err)
+		l.Error("sending event to dead letter queue", kitslog.Err(err))
+
+		attempt := p.syncAttempt(consumer, maxRetries, 1, time.Now(), err)
+		attempt.Status = EventProcessingStateStatusDeadLetter
+
+		return []*EventProcessingState{attempt}, err
+	}
+
+	var attempts []*EventProcessingState
+
+	for retryNumber := int32(1); ; retryNumber++ {
+		startHandlerAt := time.Now()
+
+		err = kitevent.CallConsumer(kitevent.CallConsumerParams{
+			Ctx:     kitevent.ContextWithMetadata(ctx, opts.Metadata),
+			Event:   consumerEvent,
+			Handler: consumer,
+		})
+
+		attempts = append(attempts, p.syncAttempt(consumer, maxRetries, retryNumber, startHandlerAt, err))
+
+		if err == nil || retryNumber >= maxRetries {
+			return attempts, err
+		}
+
+		l.Error("will retry event because consumer gets an error", kitslog.Err(err),
+			slog.Int("retry_number", int(retryNumber)),
+			slog.Int("max_retries", int(maxRetries)))
+	}
+}
+
+// syncAttempt returns the handler result of an attempt of ProduceSync, already processed.
+func (p PostgresEventStore) syncAttempt(
+	consumer kitevent.Consumer,
+	maxRetries int32,
+	retryNumber int32,
+	startHandlerAt time.Time,
+	err error,
+) *EventProcessingState {
+	now := pgutils.TimestampUTC(time.Now())
+
+	attempt := &EventProcessingState{
+		ConsumerName:             consumer.Name(),
+		Status:                   EventProcessingStateStatusSuccess,
+		RetryNumber:              retryNumber,
+		ConsumerOptionMaxRetries: maxRetries,
+		ConsumerOptionTimeoutMs:  consumer.Options().Timeout.Milliseconds(),
+		CreatedAt:                pgutils.TimestampUTC(startHandlerAt),
+		UpdatedAt:                now,
+		ProcessableAt:            pgutils.TimestampUTC(startHandlerAt),
+		PendingAt:                lo.ToPtr(pgutils.TimestampUTC(startHandlerAt)),
+		RunAt:                    lo.ToPtr(now),
+		DurationMs:               time.Since(startHandlerAt).Milliseconds(),
+	}
+
+	if consumer.Options().RetryInterval != nil {
+		attempt.ConsumerOptionRetryIntervalMs = consumer.Options().RetryInterval.Milliseconds()
+	}
+
+	if err != nil {
+		attempt.Status = EventProcessingStateStatusFailed
+		attempt.Error = lo.ToPtr(err.Error())
+		attempt.FailedAt = lo.ToPtr(now)
+	} else {
+		attempt.SuccessAt = lo.ToPtr(now)
+	}
+
+	return attempt
+}
@@ -0,0 +1,90 @@
+package kiteventpg
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"gorm.io/gorm"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrate applies the migrations of the kitevent schema, the schema must exist.
+// The applied versions are stored in kitevent.schema_migrations.
+func Migrate(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get db instance: %w", err)
+	}
+
+	driver, err := postgres.WithInstance(sqlDB, &postgres.Config{
+		SchemaName: "kitevent",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create migration driver: %w", err)
+	}
+
+	source, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("failed to create migration instance: %w", err)
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	return nil
+}
+
+// MigrationsSQL returns the SQL of the migrations of the kitevent schema, in the order they
+// must be applied, to apply them without PostgresEventStoreConfig.AutoMigrate.
+// The down migrations are returned in reverse order if down is true.
+func MigrationsSQL(down bool) (string, error) {
+	suffix := ".up.sql"
+	if down {
+		suffix = ".down.sql"
+	}
+
+	files, err := fs.Glob(migrations, "migrations/*"+suffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	slices.Sort(files)
+	if down {
+		slices.Reverse(files)
+	}
+
+	var sql strings.Builder
+	if !down {
+		sql.WriteString("create schema if not exists kitevent;\n")
+	}
+
+	for _, file := range files {
+		content, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+
+		fmt.Fprintf(&sql, "\n-- %s\n", strings.TrimPrefix(file, "migrations/"))
+		sql.Write(content)
+
+		if !strings.HasSuffix(string(content), "\n") {
+			sql.WriteString("\n")
+		}
+	}
+
+	return sql.String(), nil
+}
@@ -0,0 +1 @@
+alter table kitevent.events drop column if exists metadata;
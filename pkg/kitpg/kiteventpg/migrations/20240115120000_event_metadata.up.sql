@@ -0,0 +1 @@
+alter table kitevent.events add column if not exists metadata jsonb;
@@ -0,0 +1,15 @@
+create table if not exists kitevent.sagas
+(
+    id           text primary key,
+    name         text        not null,
+    status       text        not null,
+    current_step integer     not null default 0,
+    data         jsonb,
+    results      jsonb,
+    error        text,
+    version      integer     not null default 0,
+    created_at   timestamp   not null default (now() at time zone 'utc'),
+    updated_at   timestamp   not null default (now() at time zone 'utc')
+);
+
+create index if not exists sagas_name_status_idx on kitevent.sagas (name, status);
@@ -0,0 +1,3 @@
+drop table if exists kitevent.event_processing_states_archive;
+drop index if exists kitevent.event_processing_states_status_updated_at_idx;
+drop index if exists kitevent.event_processing_states_event_id_idx;
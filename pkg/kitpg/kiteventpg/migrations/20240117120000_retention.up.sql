@@ -0,0 +1,28 @@
+create index if not exists event_processing_states_event_id_idx on kitevent.event_processing_states (event_id);
+create index if not exists event_processing_states_status_updated_at_idx on kitevent.event_processing_states (status, updated_at);
+
+-- the archive is partitioned by month on archived_at by the retention job of the store when
+-- retention.partition_archive is enabled
+create table if not exists kitevent.event_processing_states_archive
+(
+    id                                int                         not null,
+    consumer_name                     varchar(255),
+    event_id                          int,
+    event_name                        varchar(255),
+    payload                           jsonb,
+    metadata                          jsonb,
+    status                            varchar(255),
+    error                             varchar(255),
+    consumer_option_max_retries       int,
+    consumer_option_retry_interval_ms int,
+    consumer_option_timeout_ms        int,
+    retry_number                      int,
+    duration_ms                       int,
+    created_at                        timestamp without time zone,
+    updated_at                        timestamp without time zone,
+    failed_at                         timestamp without time zone,
+    success_at                        timestamp without time zone,
+    archived_at                       timestamp without time zone not null default (now() at time zone 'utc')
+);
+
+create index if not exists event_processing_states_archive_archived_at_idx on kitevent.event_processing_states_archive (archived_at);
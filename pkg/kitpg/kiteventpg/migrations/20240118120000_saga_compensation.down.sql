@@ -0,0 +1 @@
+alter table kitevent.sagas drop column if exists compensated_steps;
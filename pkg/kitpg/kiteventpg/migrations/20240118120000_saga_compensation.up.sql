@@ -0,0 +1,2 @@
+-- the number of steps already compensated, a failed compensation resumes from the next one
+alter table kitevent.sagas add column if not exists compensated_steps integer not null default 0;
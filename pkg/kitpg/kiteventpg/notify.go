@@ -0,0 +1,202 @@
+package kiteventpg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNotifyUnsupported = errors.New("the database driver does not support LISTEN, only pgx is supported")
+
+// wakeup wakes a polling loop before the end of its poll interval.
+// Wakeups are coalesced, a loop woken several times while busy runs only once more.
+type wakeup struct {
+	ch chan struct{}
+
+	mu    sync.Mutex
+	next  time.Time
+	timer *time.Timer
+}
+
+func newWakeup() *wakeup {
+	return &wakeup{ch: make(chan struct{}, 1)}
+}
+
+func (w *wakeup) wake() {
+	select {
+	case w.ch <- struct{}{}:
+	default:
+	}
+}
+
+// wakeAt wakes the loop at t. Only the earliest pending wakeup is kept, the later ones
+// are caught by the poll interval.
+func (w *wakeup) wakeAt(t time.Time) {
+	if !t.After(time.Now()) {
+		w.wake()
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil && !t.Before(w.next) {
+		return
+	}
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	w.next = t
+	w.timer = time.AfterFunc(time.Until(t), func() {
+		w.mu.Lock()
+		w.timer = nil
+		w.mu.Unlock()
+
+		w.wake()
+	})
+}
+
+// wait blocks until the loop is woken, the interval is elapsed or the context is done.
+// It returns false if the context is done.
+func (w *wakeup) wait(ctx context.Context, interval time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-w.ch:
+	case <-time.After(interval):
+	}
+
+	return true
+}
+
+// notifier holds the state of the LISTEN connection of the store
+type notifier struct {
+	events   *wakeup
+	timeouts *wakeup
+
+	// listening is true while the store receives the notifications
+	listening atomic.Bool
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		events:   newWakeup(),
+		timeouts: newWakeup(),
+	}
+}
+
+func hasAvailable(processors []*EventProcessingState) bool {
+	for _, processor := range processors {
+		if processor.Status == EventProcessingStateStatusAvailable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notifyPayload is the payload sent by AddEvent, the earliest processable_at of the inserted
+// rows as unix milliseconds.
+func notifyPayload(processors []*EventProcessingState) string {
+	var earliest time.Time
+	for _, processor := range processors {
+		if earliest.IsZero() || processor.ProcessableAt.Time.Before(earliest) {
+			earliest = processor.ProcessableAt.Time
+		}
+	}
+
+	return strconv.FormatInt(earliest.UnixMilli(), 10)
+}
+
+// pollInterval returns the interval between two polls, FallbackPollInterval while the store
+// receives the notifications, PollInterval otherwise.
+func (p PostgresEventStore) pollInterval() time.Duration {
+	if p.notifier.listening.Load() {
+		return p.config.FallbackPollInterval
+	}
+
+	return p.config.PollInterval
+}
+
+// listen wakes the runner on each notification of the NotifyChannel, it reconnects when the
+// connection is lost. Missed notifications are caught by the fallback polling.
+func (p PostgresEventStore) listen(ctx context.Context) {
+	for {
+		err := p.waitForNotifications(ctx)
+		p.notifier.listening.Store(false)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, errNotifyUnsupported) {
+			p.logger.Warn("unable to listen for notifications, polling only", kitslog.Err(err))
+			return
+		}
+
+		p.logger.Error("notification connection lost, reconnecting", kitslog.Err(err))
+		p.notifier.events.wake()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.config.PollInterval):
+		}
+	}
+}
+
+func (p PostgresEventStore) waitForNotifications(ctx context.Context) error {
+	db, err := p.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get db instance: %w", err)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errNotifyUnsupported
+		}
+
+		pgConn := stdlibConn.Conn()
+
+		_, err := pgConn.Exec(ctx, "listen "+pgx.Identifier{p.config.NotifyChannel}.Sanitize())
+		if err != nil {
+			return fmt.Errorf("failed to listen to %s: %w", p.config.NotifyChannel, err)
+		}
+
+		p.notifier.listening.Store(true)
+
+		// rows inserted before the LISTEN were not notified
+		p.notifier.events.wake()
+
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+
+			processableAt, err := strconv.ParseInt(notification.Payload, 10, 64)
+			if err != nil {
+				p.notifier.events.wake()
+				continue
+			}
+
+			p.notifier.events.wakeAt(time.UnixMilli(processableAt))
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package kiteventpg
+
+import (
+	"context"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+type (
+	// RetentionConfig configures the background job removing the processed handler results
+	RetentionConfig struct {
+		// Interval is the interval between two runs of the job, 0 disables it
+		Interval time.Duration `cfg:"interval"`
+
+		// BatchSize is the maximum number of rows deleted or archived by a single query,
+		// small batches avoid long locks on the tables. Default: 1000
+		BatchSize int `cfg:"batch_size"`
+
+		// Policies are the retention policies by status (success, failed, dead_letter),
+		// a status without policy is kept forever. The age of a row is the one of its updated_at.
+		//
+		//	retention:
+		//	  policies:
+		//	    success:
+		//	      delete_after: 168h
+		//	    failed:
+		//	      archive_after: 720h
+		Policies map[string]RetentionPolicy `cfg:"policies"`
+
+		// ArchiveRetention is the duration after which the archived rows are deleted, or their monthly
+		// partitions dropped if PartitionArchive is enabled, 0 keeps them forever
+		ArchiveRetention time.Duration `cfg:"archive_retention"`
+
+		// PartitionArchive partitions the archive by month, the partitions are created ahead and dropped
+		// at once after ArchiveRetention. The rows already archived are moved to their partitions.
+		PartitionArchive bool `cfg:"partition_archive"`
+	}
+
+	// RetentionPolicy is the retention of the handler results of a status, if both durations are set,
+	// the rows are archived first and the archive is never deleted by DeleteAfter
+	RetentionPolicy struct {
+		// DeleteAfter deletes the rows older than it
+		DeleteAfter time.Duration `cfg:"delete_after"`
+
+		// ArchiveAfter moves the rows older than it to kitevent.event_processing_states_archive
+		ArchiveAfter time.Duration `cfg:"archive_after"`
+	}
+)
+
+// defaultRetentionBatchSize is the batch size of the retention job when RetentionConfig.BatchSize is not set
+const defaultRetentionBatchSize = 1000
+
+// runRetention runs the retention job every RetentionConfig.Interval until the context is done.
+func (p PostgresEventStore) runRetention(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.applyRetention(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p PostgresEventStore) applyRetention(ctx context.Context) {
+	config := p.config.Retention
+	now := time.Now().UTC()
+
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRetentionBatchSize
+	}
+
+	// the policies are applied even if the archive cannot be partitioned, its rows are then deleted
+	// like those of a plain archive
+	partitioned := config.PartitionArchive
+	if partitioned {
+		if err := p.store.PartitionArchive(ctx); err != nil {
+			p.logger.Error("retention: failed to partition archive", kitslog.Err(err))
+			partitioned = false
+		} else if err := p.store.EnsureArchivePartitions(ctx, now); err != nil {
+			p.logger.Error("retention: failed to create archive partitions", kitslog.Err(err))
+		}
+	}
+
+	for status, policy := range config.Policies {
+		status := EventProcessingStateStatus(strings.ToUpper(status))
+		l := p.logger.With(slog.String("status", string(status)))
+
+		if policy.ArchiveAfter > 0 {
+			n := p.inBatches(ctx, batchSize, func() (int64, error) {
+				return p.store.ArchiveProcessed(ctx, status, now.Add(-policy.ArchiveAfter), batchSize)
+			})
+			if n > 0 {
+				l.Info("retention: archived event handlers", slog.Int64("count", n))
+			}
+		}
+
+		if policy.DeleteAfter > 0 {
+			n := p.inBatches(ctx, batchSize, func() (int64, error) {
+				return p.store.DeleteProcessed(ctx, status, now.Add(-policy.DeleteAfter), batchSize)
+			})
+			if n > 0 {
+				l.Info("retention: deleted event handlers", slog.Int64("count", n))
+			}
+		}
+	}
+
+	if n := p.inBatches(ctx, batchSize, func() (int64, error) {
+		return p.store.DeleteOrphanEvents(ctx, batchSize)
+	}); n > 0 {
+		p.logger.Info("retention: deleted events", slog.Int64("count", n))
+	}
+
+	if config.ArchiveRetention > 0 && !partitioned {
+		if n := p.inBatches(ctx, batchSize, func() (int64, error) {
+			return p.store.DeleteArchived(ctx, now.Add(-config.ArchiveRetention), batchSize)
+		}); n > 0 {
+			p.logger.Info("retention: deleted archived event handlers", slog.Int64("count", n))
+		}
+	}
+
+	if config.ArchiveRetention > 0 && partitioned {
+		dropped, err := p.store.DropArchivePartitions(ctx, now.Add(-config.ArchiveRetention))
+		if err != nil {
+			p.logger.Error("retention: failed to drop archive partitions", kitslog.Err(err))
+		}
+
+		for _, partition := range dropped {
+			p.logger.Info("retention: dropped archive partition", slog.String("partition", partition))
+		}
+	}
+}
+
+// inBatches calls batch until it affects less than batchSize rows, it returns the total number of
+// affected rows.
+func (p PostgresEventStore) inBatches(ctx context.Context, batchSize int, batch func() (int64, error)) int64 {
+	var total int64
+
+	for ctx.Err() == nil {
+		n, err := batch()
+		if err != nil {
+			p.logger.Error("retention: batch failed", kitslog.Err(err))
+			return total
+		}
+
+		total += n
+
+		if n < int64(batchSize) {
+			return total
+		}
+	}
+
+	return total
+}
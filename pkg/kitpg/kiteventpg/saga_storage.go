@@ -0,0 +1,182 @@
+package kiteventpg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat/kitsaga"
+	"github.com/kitcat-framework/kitcat/pkg/kittx"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"time"
+)
+
+type Saga struct {
+	ID               string
+	Name             string
+	Status           string
+	CurrentStep      int
+	CompensatedSteps int
+	Data             datatypes.JSON
+	Results          datatypes.JSON
+	Error            *string
+	Version          int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (Saga) TableName() string {
+	return "kitevent.sagas"
+}
+
+// SagaStorage is the postgres kitsaga.Storage, the table is created by the migrations of the event store.
+// Writes use the kittx transaction of the context if there is one.
+type SagaStorage struct {
+	db *gorm.DB
+}
+
+func NewSagaStorage(db *gorm.DB) *SagaStorage {
+	return &SagaStorage{db: db}
+}
+
+func (s *SagaStorage) Create(ctx context.Context, saga *kitsaga.Saga) error {
+	row, err := sagaToRow(saga)
+	if err != nil {
+		return err
+	}
+
+	if err := kittx.MayTx(ctx, s.db).WithContext(ctx).Create(row).Error; err != nil {
+		return fmt.Errorf("failed to create saga: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SagaStorage) Update(ctx context.Context, saga *kitsaga.Saga) error {
+	row, err := sagaToRow(saga)
+	if err != nil {
+		return err
+	}
+
+	res := kittx.MayTx(ctx, s.db).WithContext(ctx).
+		Model(&Saga{}).
+		Where("id = ? and version = ?", saga.ID, saga.Version).
+		Updates(map[string]any{
+			"status":            row.Status,
+			"current_step":      row.CurrentStep,
+			"compensated_steps": row.CompensatedSteps,
+			"data":              row.Data,
+			"results":           row.Results,
+			"error":             row.Error,
+			"version":           saga.Version + 1,
+			"updated_at":        row.UpdatedAt,
+		})
+	if res.Error != nil {
+		return fmt.Errorf("failed to update saga: %w", res.Error)
+	}
+
+	if res.RowsAffected == 0 {
+		return kitsaga.ErrConcurrentUpdate
+	}
+
+	saga.Version++
+
+	return nil
+}
+
+func (s *SagaStorage) Get(ctx context.Context, id string) (*kitsaga.Saga, error) {
+	var row Saga
+
+	err := kittx.MayTx(ctx, s.db).WithContext(ctx).Where("id = ?", id).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, kitsaga.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saga: %w", err)
+	}
+
+	return rowToSaga(&row)
+}
+
+func (s *SagaStorage) List(ctx context.Context, filter kitsaga.ListFilter) ([]*kitsaga.Saga, error) {
+	query := kittx.MayTx(ctx, s.db).WithContext(ctx).Order("created_at")
+
+	if filter.Name != "" {
+		query = query.Where("name = ?", filter.Name)
+	}
+
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var rows []*Saga
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sagas: %w", err)
+	}
+
+	sagas := make([]*kitsaga.Saga, 0, len(rows))
+	for _, row := range rows {
+		saga, err := rowToSaga(row)
+		if err != nil {
+			return nil, err
+		}
+
+		sagas = append(sagas, saga)
+	}
+
+	return sagas, nil
+}
+
+func (s *SagaStorage) Name() string {
+	return "postgres"
+}
+
+func sagaToRow(saga *kitsaga.Saga) (*Saga, error) {
+	results, err := json.Marshal(saga.Results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal saga results: %w", err)
+	}
+
+	return &Saga{
+		ID:               saga.ID,
+		Name:             saga.Name,
+		Status:           string(saga.Status),
+		CurrentStep:      saga.CurrentStep,
+		CompensatedSteps: saga.CompensatedSteps,
+		Data:             datatypes.JSON(saga.Data),
+		Results:          results,
+		Error:            saga.Error,
+		Version:          saga.Version,
+		CreatedAt:        saga.CreatedAt,
+		UpdatedAt:        saga.UpdatedAt,
+	}, nil
+}
+
+func rowToSaga(row *Saga) (*kitsaga.Saga, error) {
+	results := make(map[string]json.RawMessage)
+	if len(row.Results) > 0 {
+		if err := json.Unmarshal(row.Results, &results); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal saga results: %w", err)
+		}
+	}
+
+	return &kitsaga.Saga{
+		ID:               row.ID,
+		Name:             row.Name,
+		Status:           kitsaga.Status(row.Status),
+		CurrentStep:      row.CurrentStep,
+		CompensatedSteps: row.CompensatedSteps,
+		Data:             json.RawMessage(row.Data),
+		Results:          results,
+		Error:            row.Error,
+		Version:          row.Version,
+		CreatedAt:        row.CreatedAt,
+		UpdatedAt:        row.UpdatedAt,
+	}, nil
+}
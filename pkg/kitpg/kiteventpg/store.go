@@ -2,28 +2,49 @@ package kiteventpg
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"github.com/kitcat-framework/kitcat/pkg/kittx"
 	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type EventStoreStorage interface {
 	AddEvent(ctx context.Context, event Event, processor []*EventProcessingState) error
-	FindAvailableEvent(ctx context.Context) (*EventProcessingState, error)
+	FindAvailableEvents(ctx context.Context, consumerName string, limit int) ([]*EventProcessingState, error)
 	FindPendingTimeoutEvent(ctx context.Context) (*EventProcessingState, error)
 	SaveEventHandlers(ctx context.Context, handler []*EventProcessingState) error
+
+	DeleteProcessed(ctx context.Context, status EventProcessingStateStatus, before time.Time, limit int) (int64, error)
+	ArchiveProcessed(ctx context.Context, status EventProcessingStateStatus, before time.Time, limit int) (int64, error)
+	DeleteOrphanEvents(ctx context.Context, limit int) (int64, error)
+	DeleteArchived(ctx context.Context, before time.Time, limit int) (int64, error)
+	PartitionArchive(ctx context.Context) error
+	EnsureArchivePartitions(ctx context.Context, at time.Time) error
+	DropArchivePartitions(ctx context.Context, before time.Time) ([]string, error)
 }
 
 type PgEventStore struct {
-	db *gorm.DB
+	db            *gorm.DB
+	notifyChannel string
 }
 
 func NewPgEventStore(db *gorm.DB) *PgEventStore {
 	return &PgEventStore{db: db}
 }
 
+// WithNotifyChannel makes AddEvent NOTIFY the channel, the notification is delivered on commit.
+func (p *PgEventStore) WithNotifyChannel(channel string) *PgEventStore {
+	p.notifyChannel = channel
+	return p
+}
+
+// AddEvent inserts the event and its processors in a single transaction.
+// If the context carries a kittx transaction, the rows are inserted in it, so the event is
+// only visible to the consumers once the business data is committed.
 func (p PgEventStore) AddEvent(ctx context.Context, event Event, processors []*EventProcessingState) error {
-	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	return kittx.MayTx(ctx, p.db).WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&event).Error; err != nil {
 			return fmt.Errorf("failed to create event: %w", err)
 		}
@@ -37,6 +58,13 @@ func (p PgEventStore) AddEvent(ctx context.Context, event Event, processors []*E
 			return fmt.Errorf("failed to create event processors: %w", err)
 		}
 
+		if p.notifyChannel != "" && hasAvailable(processors) {
+			err := tx.Exec("select pg_notify(?, ?)", p.notifyChannel, notifyPayload(processors)).Error
+			if err != nil {
+				return fmt.Errorf("failed to notify %s: %w", p.notifyChannel, err)
+			}
+		}
+
 		return nil
 	})
 }
@@ -50,107 +78,506 @@ func (p PgEventStore) SaveEventHandlers(ctx context.Context, handlers []*EventPr
 	return nil
 }
 
-func (p PgEventStore) FindAvailableEvent(ctx context.Context) (*EventProcessingState, error) {
+// FindAvailableEvents claims at most limit available handler results of the consumer, with their
+// event, in a single query.
+func (p PgEventStore) FindAvailableEvents(
+	ctx context.Context,
+	consumerName string,
+	limit int,
+) ([]*EventProcessingState, error) {
 	tx := p.db.Session(&gorm.Session{PrepareStmt: false, Context: ctx})
 	const query = `
-		update kitevent.event_processing_states
-		set status = ?, pending_at = now() at time zone 'utc'
-		where id = (
+		with claimed as (
+		  update kitevent.event_processing_states
+		  set status = ?, pending_at = now() at time zone 'utc'
+		  where id in (
+			select id
+			from kitevent.event_processing_states
+			where status = ?
+			  and consumer_name = ?
+			  and processable_at <= now() at time zone 'utc'
+			order by id
+			for update skip locked
+			limit ?
+		  )
+		  returning *
+		)
+		select claimed.*, ` + joinedEventColumns + `
+		from claimed
+		join kitevent.events e on e.id = claimed.event_id
+		order by claimed.id;
+	`
+
+	var handlers []*EventProcessingState
+
+	err := tx.Raw(query, EventProcessingStateStatusPending,
+		EventProcessingStateStatusAvailable, consumerName, limit).Scan(&handlers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event handlers: %w", err)
+	}
+
+	return handlers, nil
+}
+
+// FindPendingTimeoutEvent claims a pending handler result whose timeout is reached, with its event.
+// It returns nil if there is none.
+func (p PgEventStore) FindPendingTimeoutEvent(ctx context.Context) (*EventProcessingState, error) {
+	tx := p.db.Session(&gorm.Session{PrepareStmt: false, Context: ctx})
+	const query = `
+		with claimed as (
+		  update kitevent.event_processing_states
+		  set status = ?,
+			  updated_at = now() at time zone 'utc'
+		  where id = (
+			select id
+			from kitevent.event_processing_states
+			where status = ? and timeout_at <= now() at time zone 'utc'
+			order by id
+			for update skip locked
+			limit 1
+		  )
+		  returning *
+		)
+		select claimed.*, ` + joinedEventColumns + `
+		from claimed
+		join kitevent.events e on e.id = claimed.event_id;
+	`
+
+	var handlers []*EventProcessingState
+
+	err := tx.Raw(query, EventProcessingStateStatusFailed, EventProcessingStateStatusPending).Scan(&handlers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event handler: %w", err)
+	}
+
+	if len(handlers) == 0 {
+		return nil, nil
+	}
+
+	return handlers[0], nil
+}
+
+// joinedEventColumns selects the columns of the event aliased e, gorm scans them into EventProcessingState.Event
+const joinedEventColumns = `
+	e.id as "Event__id",
+	e.payload as "Event__payload",
+	e.metadata as "Event__metadata",
+	e.event_name as "Event__event_name",
+	e.created_at as "Event__created_at",
+	e.updated_at as "Event__updated_at"`
+
+// DeleteProcessed deletes at most limit handler results in the status, last updated before the date.
+// It returns the number of deleted rows.
+func (p PgEventStore) DeleteProcessed(
+	ctx context.Context,
+	status EventProcessingStateStatus,
+	before time.Time,
+	limit int,
+) (int64, error) {
+	const query = `
+		delete from kitevent.event_processing_states
+		where id in (
 		  select id
 		  from kitevent.event_processing_states
-		  where status = ?
-			and processable_at <= now() at time zone 'utc'
+		  where status = ? and updated_at < ?
 		  order by id
 		  for update skip locked
-		  limit 1
+		  limit ?
+		);
+	`
+
+	res := p.db.WithContext(ctx).Exec(query, status, before.UTC(), limit)
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to delete %s event handlers: %w", status, res.Error)
+	}
+
+	return res.RowsAffected, nil
+}
+
+// ArchiveProcessed moves at most limit handler results in the status, last updated before the date,
+// to kitevent.event_processing_states_archive along with their event.
+// It returns the number of archived rows.
+func (p PgEventStore) ArchiveProcessed(
+	ctx context.Context,
+	status EventProcessingStateStatus,
+	before time.Time,
+	limit int,
+) (int64, error) {
+	const query = `
+		with archived as (
+		  delete from kitevent.event_processing_states
+		  where id in (
+			select id
+			from kitevent.event_processing_states
+			where status = ? and updated_at < ?
+			order by id
+			for update skip locked
+			limit ?
+		  )
+		  returning *
+		)
+		insert into kitevent.event_processing_states_archive (
+		  id, consumer_name, event_id, event_name, payload, metadata, status, error,
+		  consumer_option_max_retries, consumer_option_retry_interval_ms, consumer_option_timeout_ms,
+		  retry_number, duration_ms, created_at, updated_at, failed_at, success_at
 		)
-		returning *;
+		select a.id, a.consumer_name, a.event_id, e.event_name, e.payload, e.metadata, a.status, a.error,
+		  a.consumer_option_max_retries, a.consumer_option_retry_interval_ms, a.consumer_option_timeout_ms,
+		  a.retry_number, a.duration_ms, a.created_at, a.updated_at, a.failed_at, a.success_at
+		from archived a
+		left join kitevent.events e on e.id = a.event_id;
+	`
+
+	res := p.db.WithContext(ctx).Exec(query, status, before.UTC(), limit)
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to archive %s event handlers: %w", status, res.Error)
+	}
+
+	return res.RowsAffected, nil
+}
+
+// DeleteOrphanEvents deletes at most limit events without handler results.
+// It returns the number of deleted rows.
+func (p PgEventStore) DeleteOrphanEvents(ctx context.Context, limit int) (int64, error) {
+	const query = `
+		delete from kitevent.events
+		where id in (
+		  select e.id
+		  from kitevent.events e
+		  where not exists (select 1 from kitevent.event_processing_states s where s.event_id = e.id)
+		  order by e.id
+		  for update skip locked
+		  limit ?
+		);
+	`
+
+	res := p.db.WithContext(ctx).Exec(query, limit)
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to delete orphan events: %w", res.Error)
+	}
+
+	return res.RowsAffected, nil
+}
+
+// DeleteArchived deletes at most limit rows of the archive archived before the date, it is used
+// when the archive is not partitioned. It returns the number of deleted rows.
+func (p PgEventStore) DeleteArchived(ctx context.Context, before time.Time, limit int) (int64, error) {
+	const query = `
+		delete from kitevent.event_processing_states_archive
+		where id in (
+		  select id
+		  from kitevent.event_processing_states_archive
+		  where archived_at < ?
+		  order by id
+		  for update skip locked
+		  limit ?
+		);
 	`
 
-	var (
-		handler EventProcessingState
-		evt     Event
-	)
+	res := p.db.WithContext(ctx).Exec(query, before.UTC(), limit)
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to delete archived event handlers: %w", res.Error)
+	}
 
-	err := tx.Transaction(func(tx *gorm.DB) error {
-		err := tx.Raw(query, EventProcessingStateStatusPending,
-			EventProcessingStateStatusAvailable).Scan(&handler).Error
+	return res.RowsAffected, nil
+}
+
+// PartitionArchive converts kitevent.event_processing_states_archive to a table partitioned by month
+// of archived_at, it does nothing if the archive is already partitioned.
+// The archived rows are moved to the partitions of their month.
+func (p PgEventStore) PartitionArchive(ctx context.Context) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("lock table kitevent.event_processing_states_archive in access exclusive mode;").Error
 		if err != nil {
-			return fmt.Errorf("failed to get event handler: %w", err)
+			return fmt.Errorf("failed to lock archive: %w", err)
 		}
 
-		if handler.ID == 0 {
-			return gorm.ErrRecordNotFound
+		var kind string
+		err = tx.Raw(`
+			select c.relkind::text
+			from pg_class c
+			join pg_namespace n on n.oid = c.relnamespace
+			where n.nspname = 'kitevent' and c.relname = 'event_processing_states_archive';
+		`).Scan(&kind).Error
+		if err != nil {
+			return fmt.Errorf("failed to get kind of archive: %w", err)
 		}
 
-		err = tx.First(&evt, handler.EventID).Error
+		if kind == "p" {
+			return nil
+		}
+
+		var archived struct {
+			FirstArchivedAt *time.Time
+			LastArchivedAt  *time.Time
+		}
+		err = tx.Raw(`
+			select min(archived_at) as first_archived_at, max(archived_at) as last_archived_at
+			from kitevent.event_processing_states_archive;
+		`).Scan(&archived).Error
 		if err != nil {
-			return fmt.Errorf("failed to get event: %w", err)
+			return fmt.Errorf("failed to get archived_at range of archive: %w", err)
+		}
+
+		err = tx.Exec(`
+			create table kitevent.event_processing_states_archive_partitioned
+			(like kitevent.event_processing_states_archive including defaults)
+			partition by range (archived_at);
+		`).Error
+		if err != nil {
+			return fmt.Errorf("failed to create partitioned archive: %w", err)
+		}
+
+		if archived.FirstArchivedAt != nil {
+			last := *archived.LastArchivedAt
+			for month := archiveMonth(*archived.FirstArchivedAt); !month.After(last); month = month.AddDate(0, 1, 0) {
+				if err := createArchivePartition(tx, "event_processing_states_archive_partitioned", month); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, query := range []string{
+			`insert into kitevent.event_processing_states_archive_partitioned
+			select * from kitevent.event_processing_states_archive;`,
+			"drop table kitevent.event_processing_states_archive;",
+			"alter table kitevent.event_processing_states_archive_partitioned rename to event_processing_states_archive;",
+			// like does not copy the indexes of a plain table to a partitioned one
+			`create index event_processing_states_archive_archived_at_idx
+			on kitevent.event_processing_states_archive (archived_at);`,
+		} {
+			if err := tx.Exec(query).Error; err != nil {
+				return fmt.Errorf("failed to partition archive: %w", err)
+			}
 		}
 
 		return nil
 	})
+}
 
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, nil
+// EnsureArchivePartitions creates the monthly partitions of the archive for the month of the date
+// and the following one, the months are the ones of UTC like archived_at.
+func (p PgEventStore) EnsureArchivePartitions(ctx context.Context, at time.Time) error {
+	month := archiveMonth(at)
+
+	for _, from := range []time.Time{month, month.AddDate(0, 1, 0)} {
+		if err := createArchivePartition(p.db.WithContext(ctx), "event_processing_states_archive", from); err != nil {
+			return err
 		}
-		return nil, err
 	}
 
-	handler.Event = &evt
+	return nil
+}
+
+// createArchivePartition creates the partition of the month in the archive table, if it does not exist.
+func createArchivePartition(db *gorm.DB, table string, month time.Time) error {
+	query := fmt.Sprintf(`
+		create table if not exists kitevent.%s
+		partition of kitevent.%s
+		for values from ('%s') to ('%s');`,
+		archivePartitionName(month), table, month.Format(time.DateOnly), month.AddDate(0, 1, 0).Format(time.DateOnly))
+
+	if err := db.Exec(query).Error; err != nil {
+		return fmt.Errorf("failed to create archive partition %s: %w", archivePartitionName(month), err)
+	}
+
+	return nil
+}
 
-	return &handler, nil
+// archiveMonth returns the first day of the UTC month of the date, like archived_at.
+func archiveMonth(at time.Time) time.Time {
+	at = at.UTC()
+	return time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
 }
 
-func (p PgEventStore) FindPendingTimeoutEvent(ctx context.Context) (*EventProcessingState, error) {
-	tx := p.db.Session(&gorm.Session{PrepareStmt: false, Context: ctx})
+// DropArchivePartitions drops the monthly partitions of the archive ending before the date.
+// It returns the names of the dropped partitions.
+func (p PgEventStore) DropArchivePartitions(ctx context.Context, before time.Time) ([]string, error) {
 	const query = `
-		update kitevent.event_processing_states
-		set status = ?, 
-		    updated_at = now() at time zone 'utc'
-		where id = (
-		  select id
-		  from kitevent.event_processing_states
-		  where status = ? and timeout_at <= now() at time zone 'utc'
-		  order by id
-		  for update skip locked
-		  limit 1
-		)
-		returning *;
+		select c.relname
+		from pg_inherits i
+		join pg_class c on c.oid = i.inhrelid
+		join pg_class parent on parent.oid = i.inhparent
+		join pg_namespace n on n.oid = parent.relnamespace
+		where n.nspname = 'kitevent' and parent.relname = 'event_processing_states_archive';
 	`
 
-	var (
-		handler EventProcessingState
-		evt     Event
-	)
+	var partitions []string
+	if err := p.db.WithContext(ctx).Raw(query).Scan(&partitions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list archive partitions: %w", err)
+	}
 
-	err := tx.Transaction(func(tx *gorm.DB) error {
-		err := tx.Raw(query, EventProcessingStateStatusFailed, EventProcessingStateStatusPending).Scan(&handler).Error
-		if err != nil {
-			return fmt.Errorf("failed to get event handler: %w", err)
+	var dropped []string
+	for _, partition := range partitions {
+		from, ok := parseArchivePartitionName(partition)
+		if !ok || from.AddDate(0, 1, 0).After(before) {
+			continue
+		}
+
+		if err := p.db.WithContext(ctx).Exec("drop table if exists kitevent." + partition).Error; err != nil {
+			return dropped, fmt.Errorf("failed to drop archive partition %s: %w", partition, err)
+		}
+
+		dropped = append(dropped, partition)
+	}
+
+	return dropped, nil
+}
+
+const archivePartitionPrefix = "event_processing_states_archive_"
+
+func archivePartitionName(month time.Time) string {
+	return archivePartitionPrefix + month.Format("2006_01")
+}
+
+func parseArchivePartitionName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, archivePartitionPrefix) {
+		return time.Time{}, false
+	}
+
+	month, err := time.Parse("2006_01", strings.TrimPrefix(name, archivePartitionPrefix))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return month, true
+}
+
+// ConsumerStats are the statistics of the handler results of a consumer
+type ConsumerStats struct {
+	ConsumerName string
+
+	Available  int64
+	Pending    int64
+	Success    int64
+	Failed     int64
+	DeadLetter int64
+	Skipped    int64
+
+	// OldestAvailableAt is the processable_at of the oldest available handler result, nil if there is none
+	OldestAvailableAt *time.Time
+
+	// SuccessLastMinute and SuccessLastHour are the number of handler results processed successfully
+	SuccessLastMinute int64
+	SuccessLastHour   int64
+
+	// SlowestDurationMs is the maximum duration of the handler results processed in the last hour
+	SlowestDurationMs *int64
+}
+
+func (p PgEventStore) ConsumerStats(ctx context.Context) ([]*ConsumerStats, error) {
+	const query = `
+		select consumer_name,
+		  count(*) filter (where status = 'AVAILABLE') as available,
+		  count(*) filter (where status = 'PENDING') as pending,
+		  count(*) filter (where status = 'SUCCESS') as success,
+		  count(*) filter (where status = 'FAILED') as failed,
+		  count(*) filter (where status = 'DEAD_LETTER') as dead_letter,
+		  count(*) filter (where status = 'SKIPPED') as skipped,
+		  min(processable_at) filter (
+		    where status = 'AVAILABLE' and processable_at <= now() at time zone 'utc'
+		  ) as oldest_available_at,
+		  count(*) filter (
+		    where status = 'SUCCESS' and success_at >= now() at time zone 'utc' - interval '1 minute'
+		  ) as success_last_minute,
+		  count(*) filter (
+		    where status = 'SUCCESS' and success_at >= now() at time zone 'utc' - interval '1 hour'
+		  ) as success_last_hour,
+		  max(duration_ms) filter (
+		    where status in ('SUCCESS', 'FAILED') and run_at >= now() at time zone 'utc' - interval '1 hour'
+		  ) as slowest_duration_ms
+		from kitevent.event_processing_states
+		group by consumer_name
+		order by consumer_name;
+	`
+
+	var stats []*ConsumerStats
+	if err := p.db.WithContext(ctx).Raw(query).Scan(&stats).Error; err != nil {
+		return nil, fmt.Errorf("failed to get consumer stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// terminalFailure matches the handler results that will not be retried by the store
+const terminalFailure = `(status = 'DEAD_LETTER' or (status = 'FAILED' and retry_number >= consumer_option_max_retries))`
+
+// FindFailed returns the last handler results that failed and will not be retried, with their event.
+func (p PgEventStore) FindFailed(ctx context.Context, limit int) ([]*EventProcessingState, error) {
+	var handlers []*EventProcessingState
+
+	err := p.db.WithContext(ctx).
+		Preload("Event").
+		Where(terminalFailure).
+		Order("updated_at desc").
+		Limit(limit).
+		Find(&handlers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find failed event handlers: %w", err)
+	}
+
+	return handlers, nil
+}
+
+// RetryFailed makes a handler result that failed available again, gorm.ErrRecordNotFound is
+// returned if it does not exist or may still be retried by the store.
+func (p PgEventStore) RetryFailed(ctx context.Context, id int32) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec(`
+			update kitevent.event_processing_states
+			set status = ?, error = null, failed_at = null, pending_at = null,
+			    processable_at = now() at time zone 'utc', updated_at = now() at time zone 'utc'
+			where id = ? and `+terminalFailure,
+			EventProcessingStateStatusAvailable, id)
+		if res.Error != nil {
+			return fmt.Errorf("failed to retry event handler: %w", res.Error)
 		}
 
-		if handler.ID == 0 {
+		if res.RowsAffected == 0 {
 			return gorm.ErrRecordNotFound
 		}
 
-		err = tx.First(&evt, handler.EventID).Error
-		if err != nil {
-			return fmt.Errorf("failed to get event: %w", err)
+		if p.notifyChannel != "" {
+			err := tx.Exec("select pg_notify(?, ?)", p.notifyChannel,
+				strconv.FormatInt(time.Now().UnixMilli(), 10)).Error
+			if err != nil {
+				return fmt.Errorf("failed to notify %s: %w", p.notifyChannel, err)
+			}
 		}
 
 		return nil
 	})
+}
 
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, nil
-		}
-		return nil, err
+// SkipFailed marks a handler result that failed as EventProcessingStateStatusSkipped, gorm.ErrRecordNotFound
+// is returned if it does not exist or may still be retried by the store.
+func (p PgEventStore) SkipFailed(ctx context.Context, id int32) error {
+	res := p.db.WithContext(ctx).Exec(`
+		update kitevent.event_processing_states
+		set status = ?, updated_at = now() at time zone 'utc'
+		where id = ? and `+terminalFailure,
+		EventProcessingStateStatusSkipped, id)
+	if res.Error != nil {
+		return fmt.Errorf("failed to skip event handler: %w", res.Error)
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
 
-	handler.Event = &evt
+	return nil
+}
+
+// PurgeFailed deletes the handler results of the consumer that failed and will not be retried.
+// It returns the number of deleted rows.
+func (p PgEventStore) PurgeFailed(ctx context.Context, consumerName string) (int64, error) {
+	res := p.db.WithContext(ctx).Exec(`
+		delete from kitevent.event_processing_states
+		where consumer_name = ? and `+terminalFailure,
+		consumerName)
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to purge event handlers: %w", res.Error)
+	}
 
-	return &handler, nil
+	return res.RowsAffected, nil
 }
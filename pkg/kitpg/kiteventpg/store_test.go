@@ -0,0 +1,180 @@
+package kiteventpg
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestDB connects to the database of KITPG_TEST_DSN, migrates it and empties the kitevent tables.
+// The test is skipped if KITPG_TEST_DSN is not set.
+func newTestDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("KITPG_TEST_DSN")
+	if dsn == "" {
+		t.Skip("KITPG_TEST_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Exec("create schema if not exists kitevent;").Error)
+	require.NoError(t, Migrate(db))
+	require.NoError(t, db.Exec(`truncate kitevent.events, kitevent.event_processing_states,
+		kitevent.event_processing_states_archive;`).Error)
+
+	return db
+}
+
+// addTestHandler inserts an event with a handler result in the status, last updated at updatedAt.
+// It returns the id of the event.
+func addTestHandler(t *testing.T, db *gorm.DB, status EventProcessingStateStatus, updatedAt time.Time) int32 {
+	var eventID int32
+	err := db.Raw(`insert into kitevent.events (payload, event_name) values ('{"id": 1}', 'test_event') returning id;`).
+		Scan(&eventID).Error
+	require.NoError(t, err)
+
+	err = db.Exec(`insert into kitevent.event_processing_states (consumer_name, event_id, status, updated_at)
+		values ('test_consumer', ?, ?, ?);`, eventID, status, updatedAt.UTC()).Error
+	require.NoError(t, err)
+
+	return eventID
+}
+
+func countRows(t *testing.T, db *gorm.DB, table string) int64 {
+	var count int64
+	require.NoError(t, db.Table(table).Count(&count).Error)
+
+	return count
+}
+
+func TestPgEventStore_DeleteProcessed(t *testing.T) {
+	db := newTestDB(t)
+	store := NewPgEventStore(db)
+	ctx := context.Background()
+
+	now := time.Now()
+	addTestHandler(t, db, EventProcessingStateStatusSuccess, now.Add(-2*time.Hour))
+	addTestHandler(t, db, EventProcessingStateStatusSuccess, now.Add(-2*time.Hour))
+	addTestHandler(t, db, EventProcessingStateStatusSuccess, now)
+	addTestHandler(t, db, EventProcessingStateStatusFailed, now.Add(-2*time.Hour))
+
+	n, err := store.DeleteProcessed(ctx, EventProcessingStateStatusSuccess, now.Add(-time.Hour), 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	n, err = store.DeleteProcessed(ctx, EventProcessingStateStatusSuccess, now.Add(-time.Hour), 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	require.Equal(t, int64(2), countRows(t, db, "kitevent.event_processing_states"))
+}
+
+func TestPgEventStore_ArchiveProcessed(t *testing.T) {
+	db := newTestDB(t)
+	store := NewPgEventStore(db)
+	ctx := context.Background()
+
+	now := time.Now()
+	eventID := addTestHandler(t, db, EventProcessingStateStatusFailed, now.Add(-2*time.Hour))
+	addTestHandler(t, db, EventProcessingStateStatusFailed, now)
+	addTestHandler(t, db, EventProcessingStateStatusSuccess, now.Add(-2*time.Hour))
+
+	n, err := store.ArchiveProcessed(ctx, EventProcessingStateStatusFailed, now.Add(-time.Hour), 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	require.Equal(t, int64(2), countRows(t, db, "kitevent.event_processing_states"))
+
+	var archived struct {
+		EventID   int32
+		EventName string
+		Status    string
+	}
+	err = db.Raw("select event_id, event_name, status from kitevent.event_processing_states_archive;").
+		Scan(&archived).Error
+	require.NoError(t, err)
+	require.Equal(t, eventID, archived.EventID)
+	require.Equal(t, "test_event", archived.EventName)
+	require.Equal(t, string(EventProcessingStateStatusFailed), archived.Status)
+}
+
+func TestPgEventStore_DeleteOrphanEvents(t *testing.T) {
+	db := newTestDB(t)
+	store := NewPgEventStore(db)
+	ctx := context.Background()
+
+	now := time.Now()
+	addTestHandler(t, db, EventProcessingStateStatusSuccess, now.Add(-2*time.Hour))
+	addTestHandler(t, db, EventProcessingStateStatusSuccess, now.Add(-2*time.Hour))
+	kept := addTestHandler(t, db, EventProcessingStateStatusSuccess, now)
+
+	_, err := store.DeleteProcessed(ctx, EventProcessingStateStatusSuccess, now.Add(-time.Hour), 10)
+	require.NoError(t, err)
+
+	n, err := store.DeleteOrphanEvents(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	n, err = store.DeleteOrphanEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	var ids []int32
+	require.NoError(t, db.Raw("select id from kitevent.events;").Scan(&ids).Error)
+	require.Equal(t, []int32{kept}, ids)
+}
+
+func TestPgEventStore_PartitionArchive(t *testing.T) {
+	db := newTestDB(t)
+	store := NewPgEventStore(db)
+	ctx := context.Background()
+
+	eventID := addTestHandler(t, db, EventProcessingStateStatusFailed, time.Now().Add(-2*time.Hour))
+	_, err := store.ArchiveProcessed(ctx, EventProcessingStateStatusFailed, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+
+	require.NoError(t, store.PartitionArchive(ctx))
+	require.NoError(t, store.PartitionArchive(ctx))
+
+	t.Cleanup(func() {
+		// the other tests archive without partitions
+		for _, query := range []string{
+			`create table kitevent.event_processing_states_archive_plain
+			(like kitevent.event_processing_states_archive including defaults);`,
+			"drop table kitevent.event_processing_states_archive;",
+			"alter table kitevent.event_processing_states_archive_plain rename to event_processing_states_archive;",
+			`create index event_processing_states_archive_archived_at_idx
+			on kitevent.event_processing_states_archive (archived_at);`,
+		} {
+			require.NoError(t, db.Exec(query).Error)
+		}
+	})
+
+	// the archived row is moved to the partition of its month
+	var archivedEventID int32
+	require.NoError(t, db.Raw("select event_id from kitevent.event_processing_states_archive;").
+		Scan(&archivedEventID).Error)
+	require.Equal(t, eventID, archivedEventID)
+
+	var indexed bool
+	err = db.Raw(`select exists (select 1 from pg_indexes where schemaname = 'kitevent'
+		and indexname = 'event_processing_states_archive_archived_at_idx');`).Scan(&indexed).Error
+	require.NoError(t, err)
+	require.True(t, indexed)
+
+	// 23:30 on January 31 at UTC-2 is in February in UTC
+	at := time.Date(2024, time.January, 31, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+	require.NoError(t, store.EnsureArchivePartitions(ctx, at))
+
+	dropped, err := store.DropArchivePartitions(ctx, time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		archivePartitionName(time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)),
+		archivePartitionName(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)),
+	}, dropped)
+}
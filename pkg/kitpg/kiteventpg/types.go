@@ -2,16 +2,19 @@ package kiteventpg
 
 import (
 	"dario.cat/mergo"
+	"encoding/json"
 	"fmt"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/kitcat-framework/kitcat/kitevent"
 	"github.com/kitcat-framework/kitcat/pkg/kitpg/pgutils"
 	"gorm.io/datatypes"
 	"time"
 )
 
 type Event struct {
-	ID      int32
-	Payload datatypes.JSON
+	ID       int32
+	Payload  datatypes.JSON
+	Metadata datatypes.JSON
 
 	EventName string
 
@@ -23,6 +26,18 @@ func (Event) TableName() string {
 	return "kitevent.events"
 }
 
+// Envelope returns the raw representation of the event, as consumed by the consumers
+func (e *Event) Envelope() *kitevent.Envelope {
+	var metadata map[string]any
+	_ = json.Unmarshal(e.Metadata, &metadata)
+
+	return &kitevent.Envelope{
+		Name:     kitevent.NewEventName(e.EventName),
+		Payload:  json.RawMessage(e.Payload),
+		Metadata: metadata,
+	}
+}
+
 type EventProcessingStateStatus string
 
 const (
@@ -30,6 +45,13 @@ const (
 	EventProcessingStateStatusSuccess   EventProcessingStateStatus = "SUCCESS"
 	EventProcessingStateStatusPending   EventProcessingStateStatus = "PENDING"
 	EventProcessingStateStatusAvailable EventProcessingStateStatus = "AVAILABLE"
+
+	// EventProcessingStateStatusDeadLetter is set when the event cannot be decoded for the consumer,
+	// for instance with an unknown version (see kitevent.ErrUnknownEventVersion). It is never retried.
+	EventProcessingStateStatusDeadLetter EventProcessingStateStatus = "DEAD_LETTER"
+
+	// EventProcessingStateStatusSkipped is set from the dashboard on a failed handler result that must not be retried
+	EventProcessingStateStatusSkipped EventProcessingStateStatus = "SKIPPED"
 )
 
 type EventProcessingState struct {
@@ -0,0 +1,185 @@
+package kitlockpg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat/kitlock"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"gorm.io/gorm"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// releaseTimeout bounds the unlock of a lock whose TTL expired
+const releaseTimeout = 5 * time.Second
+
+// AdvisoryLocker is a kitlock.Locker using the session advisory locks of Postgres. Each lock
+// holds a connection of the pool until it is released or its TTL expires, the lock is also
+// released by Postgres if the process dies. The fencing tokens come from the sequence
+// kitlock_fencing_tokens, created on the first acquisition.
+type AdvisoryLocker struct {
+	db     *gorm.DB
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	held     map[string]*advisoryLock
+	sequence bool
+}
+
+type advisoryLock struct {
+	key   string
+	conn  *sql.Conn
+	timer *time.Timer
+}
+
+func New(db *gorm.DB) *AdvisoryLocker {
+	return &AdvisoryLocker{
+		db:     db,
+		logger: slog.With(kitslog.Module("kitlock"), slog.String("locker", "postgres")),
+		held:   make(map[string]*advisoryLock),
+	}
+}
+
+func (l *AdvisoryLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (*kitlock.Lock, error) {
+	if err := l.ensureSequence(ctx); err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get db instance: %w", err)
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "select pg_try_advisory_lock(hashtextextended($1, 0))", key).Scan(&acquired)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to acquire lock %s: %w", key, err)
+	}
+
+	if !acquired {
+		_ = conn.Close()
+		return nil, kitlock.ErrNotAcquired
+	}
+
+	var token int64
+	if err := conn.QueryRowContext(ctx, "select nextval('kitlock_fencing_tokens')").Scan(&token); err != nil {
+		_ = l.unlock(ctx, &advisoryLock{key: key, conn: conn})
+		return nil, fmt.Errorf("failed to get fencing token of lock %s: %w", key, err)
+	}
+
+	lock := &kitlock.Lock{
+		Key:       key,
+		Owner:     uuid.New().String(),
+		Token:     token,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	held := &advisoryLock{key: key, conn: conn}
+	held.timer = time.AfterFunc(ttl, func() { l.expire(lock.Owner) })
+	l.held[lock.Owner] = held
+
+	return lock, nil
+}
+
+func (l *AdvisoryLocker) Release(ctx context.Context, lock *kitlock.Lock) error {
+	l.mu.Lock()
+	held, ok := l.held[lock.Owner]
+	if ok && !held.timer.Stop() {
+		// the TTL expired, expire is releasing it
+		ok = false
+	}
+
+	if ok {
+		delete(l.held, lock.Owner)
+	}
+	l.mu.Unlock()
+
+	if !ok {
+		return kitlock.ErrNotHeld
+	}
+
+	return l.unlock(ctx, held)
+}
+
+func (l *AdvisoryLocker) Extend(_ context.Context, lock *kitlock.Lock, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	held, ok := l.held[lock.Owner]
+	if !ok || !held.timer.Stop() {
+		return kitlock.ErrNotHeld
+	}
+
+	held.timer.Reset(ttl)
+	lock.ExpiresAt = time.Now().Add(ttl)
+
+	return nil
+}
+
+func (l *AdvisoryLocker) Name() string {
+	return "postgres"
+}
+
+// expire releases a lock whose TTL expired.
+func (l *AdvisoryLocker) expire(owner string) {
+	l.mu.Lock()
+	held, ok := l.held[owner]
+	delete(l.held, owner)
+	l.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	if err := l.unlock(ctx, held); err != nil {
+		l.logger.Error("unable to release expired lock", slog.String("key", held.key), kitslog.Err(err))
+	}
+}
+
+// unlock releases the advisory lock and returns the connection to the pool, the connection is
+// closed if the unlock fails so the session releases the lock.
+func (l *AdvisoryLocker) unlock(ctx context.Context, held *advisoryLock) error {
+	_, err := held.conn.ExecContext(ctx, "select pg_advisory_unlock(hashtextextended($1, 0))", held.key)
+	if err != nil {
+		// discard the connection rather than returning it to the pool with the lock
+		_ = held.conn.Raw(func(any) error { return driver.ErrBadConn })
+		_ = held.conn.Close()
+
+		return fmt.Errorf("failed to release lock %s: %w", held.key, err)
+	}
+
+	return held.conn.Close()
+}
+
+func (l *AdvisoryLocker) ensureSequence(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sequence {
+		return nil
+	}
+
+	if err := l.db.WithContext(ctx).Exec("create sequence if not exists kitlock_fencing_tokens").Error; err != nil {
+		return fmt.Errorf("failed to create kitlock_fencing_tokens sequence: %w", err)
+	}
+
+	l.sequence = true
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package kitlockpg
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat/kitlock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestLocker connects to the database of KITPG_TEST_DSN.
+// The test is skipped if KITPG_TEST_DSN is not set.
+func newTestLocker(t *testing.T) *AdvisoryLocker {
+	dsn := os.Getenv("KITPG_TEST_DSN")
+	if dsn == "" {
+		t.Skip("KITPG_TEST_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	return New(db)
+}
+
+func TestAdvisoryLocker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("grants the lock to a single owner with increasing tokens", func(t *testing.T) {
+		locker := newTestLocker(t)
+		key := uuid.New().String()
+
+		lock, err := locker.Acquire(ctx, key, time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, key, lock.Key)
+
+		_, err = locker.Acquire(ctx, key, time.Minute)
+		require.ErrorIs(t, err, kitlock.ErrNotAcquired)
+
+		// another instance shares the advisory locks of the database
+		_, err = newTestLocker(t).Acquire(ctx, key, time.Minute)
+		require.ErrorIs(t, err, kitlock.ErrNotAcquired)
+
+		require.NoError(t, locker.Release(ctx, lock))
+		require.ErrorIs(t, locker.Release(ctx, lock), kitlock.ErrNotHeld)
+		require.ErrorIs(t, locker.Extend(ctx, lock, time.Minute), kitlock.ErrNotHeld)
+
+		next, err := locker.Acquire(ctx, key, time.Minute)
+		require.NoError(t, err)
+		require.Greater(t, next.Token, lock.Token)
+		require.NoError(t, locker.Release(ctx, next))
+	})
+
+	t.Run("releases the lock when its TTL expires", func(t *testing.T) {
+		locker := newTestLocker(t)
+		key := uuid.New().String()
+
+		lock, err := locker.Acquire(ctx, key, 100*time.Millisecond)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			next, err := locker.Acquire(ctx, key, time.Minute)
+			if err != nil {
+				return false
+			}
+
+			require.NoError(t, locker.Release(ctx, next))
+			return true
+		}, 5*time.Second, 50*time.Millisecond)
+
+		require.ErrorIs(t, locker.Release(ctx, lock), kitlock.ErrNotHeld)
+		require.ErrorIs(t, locker.Extend(ctx, lock, time.Minute), kitlock.ErrNotHeld)
+	})
+
+	t.Run("extends the TTL of the lock", func(t *testing.T) {
+		locker := newTestLocker(t)
+		key := uuid.New().String()
+
+		lock, err := locker.Acquire(ctx, key, 200*time.Millisecond)
+		require.NoError(t, err)
+
+		expiresAt := lock.ExpiresAt
+		require.NoError(t, locker.Extend(ctx, lock, time.Minute))
+		require.True(t, lock.ExpiresAt.After(expiresAt))
+
+		time.Sleep(400 * time.Millisecond)
+
+		_, err = locker.Acquire(ctx, key, time.Minute)
+		require.ErrorIs(t, err, kitlock.ErrNotAcquired)
+
+		require.NoError(t, locker.Release(ctx, lock))
+	})
+}
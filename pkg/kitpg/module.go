@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitlock"
+	"github.com/kitcat-framework/kitcat/kitsaga"
+	"github.com/kitcat-framework/kitcat/kitweb"
 	"github.com/kitcat-framework/kitcat/pkg/kitpg/kiteventpg"
+	"github.com/kitcat-framework/kitcat/pkg/kitpg/kitlockpg"
 	"github.com/spf13/viper"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -55,6 +59,8 @@ func Module(app *kitcat.App, config *Config) {
 	app.Provides(
 		kitcat.ProvideConfigurableModule(m),
 		kitevent.ProvideStore(kiteventpg.New),
+		kitsaga.ProvideStorage(kiteventpg.NewSagaStorage),
+		kitlock.ProvideLocker(kitlockpg.New),
 	)
 }
 
@@ -82,10 +88,23 @@ func (m *KitPostgres) Configure(_ context.Context, app *kitcat.App) error {
 	m.connection = db
 
 	app.Provides(db)
+	app.Invoke(m.provideDashboard)
 
 	return nil
 }
 
+// provideDashboard registers the kitevent dashboard on kitweb when it is enabled and kitevent uses
+// the postgres store, it is only served if the kitweb module is used.
+func (m *KitPostgres) provideDashboard(
+	app *kitcat.App,
+	config *kiteventpg.DashboardConfig,
+	eventConfig *kitevent.Config,
+) {
+	if config.Enabled && eventConfig.StoreName == "postgres" {
+		app.Provides(kitweb.ProvideHandler(kiteventpg.NewDashboard))
+	}
+}
+
 func (m *KitPostgres) Priority() uint8 { return math.MaxUint8 }
 
 func (m *KitPostgres) Name() string {
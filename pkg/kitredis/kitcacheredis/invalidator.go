@@ -0,0 +1,81 @@
+package kitcacheredis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+)
+
+// PubSubInvalidator is a kitcache.Invalidator broadcasting the invalidations of the tiered store
+// on the Redis channel <prefix>invalidations. The invalidations published while an instance is
+// disconnected are lost, the near TTL of the tiered store bounds the staleness.
+type PubSubInvalidator struct {
+	client redis.UniversalClient
+	config *RedisStoreConfig
+	logger *slog.Logger
+}
+
+func NewPubSubInvalidator(client redis.UniversalClient, config *RedisStoreConfig, logger *slog.Logger) *PubSubInvalidator {
+	return &PubSubInvalidator{
+		client: client,
+		config: config,
+		logger: logger.With(kitslog.Module("kitcache"), slog.String("invalidator", "redis")),
+	}
+}
+
+func (i *PubSubInvalidator) channel() string {
+	return i.config.KeyPrefix + "invalidations"
+}
+
+func (i *PubSubInvalidator) Publish(ctx context.Context, invalidation kitcache.Invalidation) error {
+	payload, err := json.Marshal(invalidation)
+	if err != nil {
+		return fmt.Errorf("unable to marshal invalidation: %w", err)
+	}
+
+	if err := i.client.Publish(ctx, i.channel(), payload).Err(); err != nil {
+		return fmt.Errorf("unable to publish invalidation: %w", err)
+	}
+
+	return nil
+}
+
+// Subscribe waits for the subscription to be confirmed, then receives the invalidations in a
+// goroutine until the context is done.
+func (i *PubSubInvalidator) Subscribe(ctx context.Context, handler func(ctx context.Context, invalidation kitcache.Invalidation)) error {
+	pubsub := i.client.Subscribe(ctx, i.channel())
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return fmt.Errorf("unable to subscribe to %s: %w", i.channel(), err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var invalidation kitcache.Invalidation
+				if err := json.Unmarshal([]byte(message.Payload), &invalidation); err != nil {
+					i.logger.Error("unable to unmarshal invalidation", kitslog.Err(err))
+					continue
+				}
+
+				handler(ctx, invalidation)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package kitcacheredis
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestPubSubInvalidator(t *testing.T) {
+	ctx := context.Background()
+
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	config := &RedisStoreConfig{KeyPrefix: "app:test:"}
+	invalidator := NewPubSubInvalidator(client, config, slog.Default())
+
+	subCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	received := make(chan kitcache.Invalidation, 1)
+	require.NoError(t, invalidator.Subscribe(subCtx, func(ctx context.Context, invalidation kitcache.Invalidation) {
+		received <- invalidation
+	}))
+
+	require.NoError(t, invalidator.Publish(ctx, kitcache.Invalidation{Origin: "other", Keys: []string{"key"}}))
+
+	select {
+	case invalidation := <-received:
+		require.Equal(t, kitcache.Invalidation{Origin: "other", Keys: []string{"key"}}, invalidation)
+	case <-time.After(2 * time.Second):
+		t.Fatal("invalidation was not received")
+	}
+}
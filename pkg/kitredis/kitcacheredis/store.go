@@ -0,0 +1,502 @@
+package kitcacheredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+type RedisStoreConfig struct {
+	// KeyPrefix prefixes every key of the store, set it per application and environment when
+	// they share a Redis server, e.g. "myapp:production:"
+	KeyPrefix string `cfg:"key_prefix"`
+}
+
+func (c *RedisStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitcache.config_stores.redis"
+
+	viper.SetDefault(prefix+".key_prefix", "kitcache:")
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal redis cache store config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(RedisStoreConfig))
+}
+
+var (
+	// compareAndSwapScript sets ARGV[2] if the key holds ARGV[1], with a TTL of ARGV[3] milliseconds
+	// or no TTL when 0
+	compareAndSwapScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+	// expireScript sets the TTL of an existing key to ARGV[1] milliseconds, or removes it when 0
+	expireScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+else
+	redis.call("PERSIST", KEYS[1])
+end
+return 1
+`)
+
+	// tagScript adds ARGV[1] to the set of a tag, the set lives as long as its longest member:
+	// a new set takes the TTL of the member (ARGV[2] milliseconds), an existing one is extended to it,
+	// and a member without TTL makes the set persistent
+	tagScript = redis.NewScript(`
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local current = redis.call("PTTL", KEYS[1])
+if existed == 0 or (current >= 0 and current < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+
+	// extendScript extends the TTL of an existing set to ARGV[1] milliseconds if it expires before,
+	// or makes it persistent when 0
+	extendScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local ttl = tonumber(ARGV[1])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local current = redis.call("PTTL", KEYS[1])
+if current >= 0 and current < ttl then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+)
+
+const (
+	// tagKeyPrefix prefixes the sets of the keys of each tag
+	tagKeyPrefix = "kitcache_tag:"
+
+	// keyTagsKeyPrefix prefixes the sets of the tags of each key
+	keyTagsKeyPrefix = "kitcache_key_tags:"
+)
+
+// RedisStore is a kitcache.Store on top of Redis, the connections are pooled by the
+// redis.UniversalClient provided by kitredis.
+//
+// The keys of a tag are kept in the set <prefix>kitcache_tag:<tag>, and the tags of a key in the
+// set <prefix>kitcache_key_tags:<key>. The sets live as long as their longest member: they are
+// extended when a member is set, and when Expire or Update extends the TTL of a key. A key is
+// removed from the sets of its tags only by InvalidateTags, so invalidating a tag may delete a key
+// set again without the tag since.
+//
+// The values are given to Redis as is, so they must be strings, bytes, numbers or implement
+// encoding.BinaryMarshaler: use a kitcache.Cache created with kitcache.WithCodec for other types.
+// Get and GetMany return []byte.
+type RedisStore struct {
+	client redis.UniversalClient
+	config *RedisStoreConfig
+}
+
+func New(client redis.UniversalClient, config *RedisStoreConfig) *RedisStore {
+	return &RedisStore{client: client, config: config}
+}
+
+func (s *RedisStore) key(key string) string {
+	return s.config.KeyPrefix + key
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (any, error) {
+	value, err := s.client.Get(ctx, s.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, kitcache.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to get key %s: %w", key, err)
+	}
+
+	return value, nil
+}
+
+// GetMany pipelines a GET per key rather than a MGET, so the keys may be in different slots
+// of a Redis Cluster.
+func (s *RedisStore) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, s.key(key))
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("unable to get keys: %w", err)
+	}
+
+	values := make(map[string]any, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to get key %s: %w", keys[i], err)
+		}
+
+		values[keys[i]] = value
+	}
+
+	return values, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value any, opts *kitcache.SetOptions) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.key(key), value, setTTL(opts))
+		s.tag(ctx, pipe, key, opts)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", kitcache.ErrUnableToSet, key, err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) SetMany(ctx context.Context, values map[string]any, opts *kitcache.SetOptions) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, s.key(key), value, setTTL(opts))
+			s.tag(ctx, pipe, key, opts)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", kitcache.ErrUnableToSet, err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) SetNX(ctx context.Context, key string, value any, opts *kitcache.SetOptions) (bool, error) {
+	ok, err := s.client.SetNX(ctx, s.key(key), value, setTTL(opts)).Result()
+	if err != nil {
+		return false, fmt.Errorf("%w %s: %w", kitcache.ErrUnableToSet, key, err)
+	}
+
+	if ok {
+		return true, s.tagKey(ctx, key, opts)
+	}
+
+	return false, nil
+}
+
+func (s *RedisStore) Del(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, s.key(key)).Err(); err != nil {
+		return fmt.Errorf("unable to delete key %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) DelMany(ctx context.Context, keys []string) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, s.key(key))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to delete keys: %w", err)
+	}
+
+	return nil
+}
+
+// Update replaces the value of an existing key with SET XX, keeping its TTL unless
+// UpdateOption.TTL is set. It returns kitcache.ErrNotFound if the key is missing.
+func (s *RedisStore) Update(ctx context.Context, key string, value any, opts *kitcache.UpdateOption) error {
+	args := redis.SetArgs{Mode: "XX", KeepTTL: true}
+	if opts != nil && opts.TTL != nil {
+		args = redis.SetArgs{Mode: "XX", TTL: *opts.TTL}
+	}
+
+	err := s.client.SetArgs(ctx, s.key(key), value, args).Err()
+	if errors.Is(err, redis.Nil) {
+		return kitcache.ErrNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", kitcache.ErrUnableToUpdate, key, err)
+	}
+
+	if opts != nil && opts.TTL != nil {
+		return s.extendTags(ctx, key, *opts.TTL)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	value, err := s.client.IncrBy(ctx, s.key(key), delta).Result()
+	if err != nil {
+		return 0, incrementError(key, err)
+	}
+
+	return value, nil
+}
+
+func (s *RedisStore) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	value, err := s.client.DecrBy(ctx, s.key(key), delta).Result()
+	if err != nil {
+		return 0, incrementError(key, err)
+	}
+
+	return value, nil
+}
+
+func (s *RedisStore) CompareAndSwap(ctx context.Context, key string, oldValue, newValue any, opts *kitcache.SetOptions) (bool, error) {
+	swapped, err := compareAndSwapScript.Run(ctx, s.client, []string{s.key(key)},
+		oldValue, newValue, setTTL(opts).Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("unable to compare and swap key %s: %w", key, err)
+	}
+
+	if swapped == 1 {
+		return true, s.tagKey(ctx, key, opts)
+	}
+
+	return false, nil
+}
+
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s.key(key)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get ttl of key %s: %w", key, err)
+	}
+
+	// go-redis returns the special values -1 (no TTL) and -2 (missing key) as is
+	switch ttl {
+	case -2:
+		return 0, kitcache.ErrNotFound
+	case -1:
+		return 0, nil
+	default:
+		return ttl, nil
+	}
+}
+
+func (s *RedisStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	exists, err := expireScript.Run(ctx, s.client, []string{s.key(key)}, ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("unable to expire key %s: %w", key, err)
+	}
+
+	if exists == 0 {
+		return kitcache.ErrNotFound
+	}
+
+	return s.extendTags(ctx, key, ttl)
+}
+
+// InvalidateTags deletes the keys of each tag and the sets of their tags, then the set of the tag.
+func (s *RedisStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		keys, err := s.client.SMembers(ctx, s.key(tagKeyPrefix+tag)).Result()
+		if err != nil {
+			return fmt.Errorf("unable to get keys of tag %s: %w", tag, err)
+		}
+
+		deleted := append([]string{tagKeyPrefix + tag}, keys...)
+		for _, key := range keys {
+			deleted = append(deleted, keyTagsKeyPrefix+key)
+		}
+
+		if err := s.DelMany(ctx, deleted); err != nil {
+			return fmt.Errorf("unable to invalidate tag %s: %w", tag, err)
+		}
+	}
+
+	return nil
+}
+
+// DeletePrefix scans the keys matching the prefix, on every master node of a Redis Cluster.
+func (s *RedisStore) DeletePrefix(ctx context.Context, prefix string) error {
+	match := escapePattern(s.key(prefix)) + "*"
+
+	deletePrefix := func(ctx context.Context, client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, match, scanCount).Iterator()
+
+		var keys []string
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+
+			if len(keys) == scanCount {
+				if err := deleteKeys(ctx, client, keys); err != nil {
+					return err
+				}
+
+				keys = keys[:0]
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		return deleteKeys(ctx, client, keys)
+	}
+
+	var err error
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return deletePrefix(ctx, client)
+		})
+	} else {
+		err = deletePrefix(ctx, s.client)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to delete prefix %s: %w", prefix, err)
+	}
+
+	return nil
+}
+
+// OnStart checks the connection to Redis, so a misconfigured store fails the start rather than
+// the first requests.
+func (s *RedisStore) OnStart(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("unable to connect to redis: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) Name() string {
+	return "redis"
+}
+
+// tag adds the key to the sets of its tags, the sets expire after their last member (see tagScript).
+func (s *RedisStore) tag(ctx context.Context, pipe redis.Pipeliner, key string, opts *kitcache.SetOptions) {
+	if opts == nil {
+		return
+	}
+
+	for _, tag := range opts.Tags {
+		tagScript.Eval(ctx, pipe, []string{s.key(tagKeyPrefix + tag)}, key, setTTL(opts).Milliseconds())
+		tagScript.Eval(ctx, pipe, []string{s.key(keyTagsKeyPrefix + key)}, tag, setTTL(opts).Milliseconds())
+	}
+}
+
+// extendTags extends the sets of the tags of the key, and the set of its tags, to its new TTL so
+// they do not expire before it.
+func (s *RedisStore) extendTags(ctx context.Context, key string, ttl time.Duration) error {
+	tags, err := s.client.SMembers(ctx, s.key(keyTagsKeyPrefix+key)).Result()
+	if err != nil {
+		return fmt.Errorf("unable to get tags of key %s: %w", key, err)
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		extendScript.Eval(ctx, pipe, []string{s.key(keyTagsKeyPrefix + key)}, ttl.Milliseconds())
+		for _, tag := range tags {
+			extendScript.Eval(ctx, pipe, []string{s.key(tagKeyPrefix + tag)}, ttl.Milliseconds())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to extend tags of key %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// tagKey adds the key to the sets of its tags, for the operations not run in a pipeline.
+func (s *RedisStore) tagKey(ctx context.Context, key string, opts *kitcache.SetOptions) error {
+	if opts == nil || len(opts.Tags) == 0 {
+		return nil
+	}
+
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.tag(ctx, pipe, key, opts)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to tag key %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// scanCount is the number of keys scanned and deleted at once by DeletePrefix
+const scanCount = 100
+
+// deleteKeys deletes the full keys one by one in a pipeline, as they may be in different slots.
+func deleteKeys(ctx context.Context, client redis.UniversalClient, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// escapePattern escapes the glob characters of a SCAN pattern.
+func escapePattern(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(pattern)
+}
+
+func setTTL(opts *kitcache.SetOptions) time.Duration {
+	if opts == nil || opts.TTL == nil {
+		return 0
+	}
+
+	return *opts.TTL
+}
+
+func incrementError(key string, err error) error {
+	if strings.Contains(err.Error(), "not an integer") {
+		return kitcache.ErrNotAnInteger
+	}
+
+	return fmt.Errorf("unable to increment key %s: %w", key, err)
+}
@@ -0,0 +1,198 @@
+package kitcacheredis
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return New(client, &RedisStoreConfig{KeyPrefix: "app:test:"}), server
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("prefixes the keys and sets the TTL", func(t *testing.T) {
+		store, server := newTestRedisStore(t)
+		require.NoError(t, store.OnStart(ctx))
+
+		require.NoError(t, store.Set(ctx, "key", "value", kitcache.NewSetOptions().WithTTL(time.Minute)))
+		require.True(t, server.Exists("app:test:key"))
+
+		value, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+
+		ttl, err := store.TTL(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, ttl)
+
+		require.NoError(t, store.Expire(ctx, "key", 0))
+
+		ttl, err = store.TTL(ctx, "key")
+		require.NoError(t, err)
+		require.Zero(t, ttl)
+
+		server.FastForward(2 * time.Minute)
+		_, err = store.Get(ctx, "key")
+		require.NoError(t, err)
+
+		_, err = store.TTL(ctx, "missing")
+		require.ErrorIs(t, err, kitcache.ErrNotFound)
+		require.ErrorIs(t, store.Expire(ctx, "missing", time.Minute), kitcache.ErrNotFound)
+	})
+
+	t.Run("gets, sets and deletes many keys", func(t *testing.T) {
+		store, _ := newTestRedisStore(t)
+
+		require.NoError(t, store.SetMany(ctx, map[string]any{"a": "1", "b": "2"}, nil))
+
+		values, err := store.GetMany(ctx, []string{"a", "b", "c"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"a": []byte("1"), "b": []byte("2")}, values)
+
+		require.NoError(t, store.DelMany(ctx, []string{"a", "b"}))
+
+		values, err = store.GetMany(ctx, []string{"a", "b"})
+		require.NoError(t, err)
+		require.Empty(t, values)
+	})
+
+	t.Run("runs the atomic operations", func(t *testing.T) {
+		store, _ := newTestRedisStore(t)
+
+		ok, err := store.SetNX(ctx, "key", "old", nil)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = store.SetNX(ctx, "key", "other", nil)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		ok, err = store.CompareAndSwap(ctx, "key", "other", "new", nil)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		ok, err = store.CompareAndSwap(ctx, "key", "old", "new", nil)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		_, err = store.Increment(ctx, "key", 1)
+		require.ErrorIs(t, err, kitcache.ErrNotAnInteger)
+
+		value, err := store.Increment(ctx, "counter", 5)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), value)
+
+		value, err = store.Decrement(ctx, "counter", 2)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), value)
+	})
+
+	t.Run("stores typed values with a codec", func(t *testing.T) {
+		store, _ := newTestRedisStore(t)
+
+		type user struct {
+			Name string
+		}
+
+		cache := kitcache.NewCache[user](store, kitcache.WithCodec(kitcache.JSONCodec{}))
+		require.NoError(t, cache.Set(ctx, "user", user{Name: "kitcat"}, nil))
+
+		value, err := cache.Get(ctx, "user")
+		require.NoError(t, err)
+		require.Equal(t, user{Name: "kitcat"}, value)
+	})
+
+	t.Run("invalidates the keys of a tag and of a prefix", func(t *testing.T) {
+		store, server := newTestRedisStore(t)
+
+		require.NoError(t, store.Set(ctx, "product:42:page", "page", kitcache.NewSetOptions().WithTags("product:42")))
+		require.NoError(t, store.Set(ctx, "product:42:price", "10", kitcache.NewSetOptions().WithTags("product:42")))
+		require.NoError(t, store.Set(ctx, "product:43:page", "page", kitcache.NewSetOptions().WithTags("product:43")))
+		require.NoError(t, store.Set(ctx, "product*:page", "page", nil))
+
+		require.NoError(t, store.InvalidateTags(ctx, "product:42"))
+		require.False(t, server.Exists("app:test:product:42:page"))
+		require.False(t, server.Exists("app:test:product:42:price"))
+		require.False(t, server.Exists("app:test:kitcache_tag:product:42"))
+		require.True(t, server.Exists("app:test:product:43:page"))
+
+		require.NoError(t, store.DeletePrefix(ctx, "product*"))
+		require.True(t, server.Exists("app:test:product:43:page"))
+		require.False(t, server.Exists("app:test:product*:page"))
+
+		require.NoError(t, store.DeletePrefix(ctx, "product:"))
+		require.False(t, server.Exists("app:test:product:43:page"))
+	})
+
+	t.Run("expires the set of a tag after its last member", func(t *testing.T) {
+		store, server := newTestRedisStore(t)
+
+		require.NoError(t, store.Set(ctx, "a", "a", kitcache.NewSetOptions().WithTTL(time.Minute).WithTags("tag")))
+		require.Equal(t, time.Minute, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.Set(ctx, "b", "b", kitcache.NewSetOptions().WithTTL(time.Hour).WithTags("tag")))
+		require.Equal(t, time.Hour, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.Set(ctx, "c", "c", kitcache.NewSetOptions().WithTTL(time.Second).WithTags("tag")))
+		require.Equal(t, time.Hour, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.Set(ctx, "d", "d", kitcache.NewSetOptions().WithTags("tag")))
+		require.Zero(t, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.Set(ctx, "e", "e", kitcache.NewSetOptions().WithTTL(time.Minute).WithTags("tag")))
+		require.Zero(t, server.TTL("app:test:kitcache_tag:tag"))
+	})
+
+	t.Run("extends the set of a tag with the TTL of its members", func(t *testing.T) {
+		store, server := newTestRedisStore(t)
+
+		require.NoError(t, store.Set(ctx, "a", "a", kitcache.NewSetOptions().WithTTL(time.Minute).WithTags("tag")))
+		require.Equal(t, time.Minute, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.Expire(ctx, "a", time.Hour))
+		require.Equal(t, time.Hour, server.TTL("app:test:kitcache_tag:tag"))
+		require.Equal(t, time.Hour, server.TTL("app:test:kitcache_key_tags:a"))
+
+		ttl := 2 * time.Hour
+		require.NoError(t, store.Update(ctx, "a", "a", &kitcache.UpdateOption{TTL: &ttl}))
+		require.Equal(t, 2*time.Hour, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.Expire(ctx, "a", time.Second))
+		require.Equal(t, 2*time.Hour, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.Expire(ctx, "a", 0))
+		require.Zero(t, server.TTL("app:test:kitcache_tag:tag"))
+
+		require.NoError(t, store.InvalidateTags(ctx, "tag"))
+		require.False(t, server.Exists("app:test:a"))
+		require.False(t, server.Exists("app:test:kitcache_key_tags:a"))
+	})
+
+	t.Run("updates only an existing key and keeps its TTL", func(t *testing.T) {
+		store, _ := newTestRedisStore(t)
+
+		require.ErrorIs(t, store.Update(ctx, "key", "value", nil), kitcache.ErrNotFound)
+
+		require.NoError(t, store.Set(ctx, "key", "old", kitcache.NewSetOptions().WithTTL(time.Minute)))
+		require.NoError(t, store.Update(ctx, "key", "new", nil))
+
+		value, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("new"), value)
+
+		ttl, err := store.TTL(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, ttl)
+	})
+}
@@ -0,0 +1,698 @@
+package kiteventredis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RedisEventStoreConfig struct {
+	// KeyPrefix prefixes every key of the store, see RedisEventStore
+	KeyPrefix string `cfg:"key_prefix"`
+
+	// ConsumerID identifies this process in the consumer groups, a random one is used when empty
+	ConsumerID string `cfg:"consumer_id"`
+
+	// BlockTimeout is the maximum duration of a XREADGROUP call
+	BlockTimeout time.Duration `cfg:"block_timeout"`
+
+	// PollInterval is the interval between two claims of the idle pending entries, and between
+	// two moves of the delayed events to their stream
+	PollInterval time.Duration `cfg:"poll_interval"`
+
+	// Workers is the maximum number of events processed in parallel by the store
+	Workers int `cfg:"workers"`
+
+	// BatchSize is the maximum number of entries read or claimed per consumer in a single call
+	BatchSize int `cfg:"batch_size"`
+
+	// MaxLen trims the streams to approximately MaxLen entries, 0 disables trimming
+	MaxLen int64 `cfg:"max_len"`
+}
+
+// defaultWorkers and defaultBatchSize replace a Workers or BatchSize lower than 1, with which no
+// event would ever be claimed
+const (
+	defaultWorkers   = 10
+	defaultBatchSize = 10
+)
+
+func (c *RedisEventStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitevent.config_stores.redis"
+
+	viper.SetDefault(prefix+".key_prefix", "kitevent:")
+	viper.SetDefault(prefix+".consumer_id", "")
+	viper.SetDefault(prefix+".block_timeout", time.Second*5)
+	viper.SetDefault(prefix+".poll_interval", time.Second)
+	viper.SetDefault(prefix+".workers", defaultWorkers)
+	viper.SetDefault(prefix+".batch_size", defaultBatchSize)
+	viper.SetDefault(prefix+".max_len", 0)
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal redis event store config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(RedisEventStoreConfig))
+}
+
+// RedisEventStore is a kitevent.Store on top of Redis Streams, with the keys:
+//   - <prefix>stream:<event name>: the stream of an EventName, each Consumer is a consumer group of it
+//   - <prefix>dlq:<event name>: the dead letter stream of an EventName, with the consumer and the error
+//   - <prefix>delayed: the sorted set of the events produced with a ProduceAt in the future, by timestamp
+//   - <prefix>streams: the set of the event names produced, to read the streams matching a pattern
+//
+// An entry is acknowledged once processed or sent to the dead letter stream. A failed entry stays
+// pending and is claimed again (XAUTOCLAIM) after the retry interval of the consumer, so is an entry
+// whose consumer timed out or whose process crashed, once idle for the timeout of the consumer.
+type RedisEventStore struct {
+	consumers  *kitevent.ConsumerRegistry
+	client     redis.UniversalClient
+	logger     *slog.Logger
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+
+	config     *RedisEventStoreConfig
+	consumerID string
+
+	// subscriptions are the event names (or patterns) of each consumer
+	subscriptions map[string][]kitevent.EventName
+
+	// groups are the consumer groups known to exist, by stream and group
+	groups sync.Map
+
+	// workerSlots and consumerSlots are semaphores, entries are read only when a slot is free
+	// in both of them, so read entries never wait for a worker
+	workerSlots   chan struct{}
+	consumerSlots map[string]chan struct{}
+
+	slotsMu       sync.Mutex
+	slotsReleased chan struct{}
+}
+
+func New(client redis.UniversalClient, logger *slog.Logger, config *RedisEventStoreConfig) *RedisEventStore {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	config = withDefaults(config)
+
+	consumerID := config.ConsumerID
+	if consumerID == "" {
+		consumerID = uuid.New().String()
+	}
+
+	return &RedisEventStore{
+		client: client,
+		logger: logger.With(
+			kitslog.Module("kitevent"),
+			slog.String("store", "redis")),
+		consumers:     kitevent.NewConsumerRegistry(),
+		ctx:           ctx,
+		cancelFunc:    cancelFunc,
+		config:        config,
+		consumerID:    consumerID,
+		subscriptions: make(map[string][]kitevent.EventName),
+		workerSlots:   make(chan struct{}, config.Workers),
+		consumerSlots: make(map[string]chan struct{}),
+		slotsReleased: make(chan struct{}),
+	}
+}
+
+// withDefaults returns a copy of the config with the defaults of the Workers and the BatchSize
+// lower than 1.
+func withDefaults(config *RedisEventStoreConfig) *RedisEventStoreConfig {
+	c := *config
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+
+	return &c
+}
+
+func (p *RedisEventStore) OnStart(_ context.Context) error {
+	for _, consumer := range p.consumers.All() {
+		go p.read(p.ctx, consumer)
+	}
+
+	go p.claimIdle(p.ctx)
+	go p.moveDelayed(p.ctx)
+
+	return nil
+}
+
+func (p *RedisEventStore) Produce(ctx context.Context, event kitevent.Event, opt *kitevent.ProducerOptions) error {
+	handlersConcerned := p.consumers.Get(event.EventName())
+	if len(handlersConcerned) == 0 {
+		return errors.New("no consumer found for event")
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	if opt == nil {
+		opt = kitevent.NewProducerOptions()
+	}
+
+	opt.WithEventVersion(event)
+
+	metadata, err := json.Marshal(opt.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	msg := message{
+		Name:     event.EventName().Name,
+		Payload:  string(payload),
+		Metadata: string(metadata),
+	}
+
+	// the groups are created before the entry is added, so the consumers known by this process receive it
+	for _, consumer := range handlersConcerned {
+		if err := p.ensureGroup(ctx, p.streamKey(msg.Name), consumer.Name()); err != nil {
+			return err
+		}
+	}
+
+	if opt.ProduceAt != nil && opt.ProduceAt.After(time.Now()) {
+		return p.delay(ctx, msg, *opt.ProduceAt)
+	}
+
+	_, err = p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, p.key("streams"), msg.Name)
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: p.streamKey(msg.Name),
+			MaxLen: p.config.MaxLen,
+			Approx: p.config.MaxLen > 0,
+			Values: msg.values(),
+		})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add event: %w", err)
+	}
+
+	return nil
+}
+
+func (p *RedisEventStore) ProduceSync(
+	ctx context.Context,
+	event kitevent.Event,
+	opts *kitevent.ProducerOptions,
+) error {
+	if opts == nil {
+		opts = kitevent.NewProducerOptions()
+	}
+
+	handlers := p.consumers.Get(event.EventName())
+
+	var errs []error
+
+	for _, handler := range handlers {
+		// each consumer counts its own retries
+		consumerOpts := *opts
+
+		err := kitevent.LocalCallHandler(kitevent.LocalCallConsumerParams{
+			Ctx:           ctx,
+			Event:         event,
+			Producer:      p,
+			Opts:          &consumerOpts,
+			Consumer:      handler,
+			Logger:        p.logger,
+			IsProduceSync: true,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *RedisEventStore) AddConsumer(eventName kitevent.EventName, handler kitevent.Consumer) {
+	p.consumers.Add(eventName, handler)
+	p.subscriptions[handler.Name()] = append(p.subscriptions[handler.Name()], eventName)
+
+	if concurrency := handler.Options().Concurrency; concurrency != nil {
+		p.consumerSlots[handler.Name()] = make(chan struct{}, *concurrency)
+	}
+}
+
+func (p *RedisEventStore) OnStop(_ context.Context) error {
+	p.cancelFunc()
+
+	return nil
+}
+
+func (p *RedisEventStore) Name() string {
+	return "redis"
+}
+
+func (p *RedisEventStore) key(name string) string {
+	return p.config.KeyPrefix + name
+}
+
+func (p *RedisEventStore) streamKey(eventName string) string {
+	return p.key("stream:" + eventName)
+}
+
+func (p *RedisEventStore) dlqKey(eventName string) string {
+	return p.key("dlq:" + eventName)
+}
+
+// ensureGroup creates the consumer group of the stream if it does not exist, it only receives the new entries.
+func (p *RedisEventStore) ensureGroup(ctx context.Context, stream string, group string) error {
+	if _, ok := p.groups.Load(stream + "\x00" + group); ok {
+		return nil
+	}
+
+	err := p.client.XGroupCreateMkStream(ctx, stream, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s of %s: %w", group, stream, err)
+	}
+
+	p.groups.Store(stream+"\x00"+group, struct{}{})
+
+	return nil
+}
+
+// streams returns the streams read by the consumer, with their consumer group.
+func (p *RedisEventStore) streams(ctx context.Context, consumer kitevent.Consumer) ([]string, error) {
+	var (
+		eventNames []string
+		produced   []string
+	)
+
+	for _, eventName := range p.subscriptions[consumer.Name()] {
+		if !eventName.IsPattern() {
+			eventNames = append(eventNames, eventName.Name)
+			continue
+		}
+
+		if produced == nil {
+			var err error
+
+			produced, err = p.client.SMembers(ctx, p.key("streams")).Result()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get streams: %w", err)
+			}
+		}
+
+		for _, name := range produced {
+			if eventName.Match(kitevent.NewEventName(name)) {
+				eventNames = append(eventNames, name)
+			}
+		}
+	}
+
+	streams := make([]string, 0, len(eventNames))
+	seen := make(map[string]bool)
+
+	for _, name := range eventNames {
+		stream := p.streamKey(name)
+		if seen[stream] {
+			continue
+		}
+
+		if err := p.ensureGroup(ctx, stream, consumer.Name()); err != nil {
+			return nil, err
+		}
+
+		seen[stream] = true
+		streams = append(streams, stream)
+	}
+
+	return streams, nil
+}
+
+// read is a blocking function that reads the new entries of the streams of the consumer, as many as
+// there are free slots, and processes each of them in its own goroutine.
+func (p *RedisEventStore) read(ctx context.Context, consumer kitevent.Consumer) {
+	l := p.logger.With(slog.String("consumer", consumer.Name()))
+
+	for ctx.Err() == nil {
+		streams, err := p.streams(ctx, consumer)
+		if err != nil && ctx.Err() == nil {
+			l.Error("failed to get streams", kitslog.Err(err))
+		}
+
+		if len(streams) == 0 {
+			sleep(ctx, p.config.PollInterval)
+			continue
+		}
+
+		limit := p.freeSlots(consumer.Name())
+		if limit == 0 {
+			p.waitForSlots(ctx)
+			continue
+		}
+
+		args := &redis.XReadGroupArgs{
+			Group:    consumer.Name(),
+			Consumer: p.consumerID,
+			Count:    int64(limit),
+			Block:    p.config.BlockTimeout,
+		}
+
+		args.Streams = append(args.Streams, streams...)
+		for range streams {
+			args.Streams = append(args.Streams, ">")
+		}
+
+		results, err := p.client.XReadGroup(ctx, args).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+				l.Error("failed to read streams", kitslog.Err(err))
+				sleep(ctx, p.config.PollInterval)
+			}
+
+			continue
+		}
+
+		for _, result := range results {
+			for _, entry := range result.Messages {
+				p.dispatch(ctx, consumer, result.Stream, entry, 1)
+			}
+		}
+	}
+}
+
+// claimIdle is a blocking function that claims, for each consumer, the pending entries idle for
+// longer than minIdle: the failed entries to retry, and the entries whose consumer timed out or crashed.
+func (p *RedisEventStore) claimIdle(ctx context.Context) {
+	for sleep(ctx, p.config.PollInterval) {
+		for _, consumer := range p.consumers.All() {
+			l := p.logger.With(slog.String("consumer", consumer.Name()))
+
+			streams, err := p.streams(ctx, consumer)
+			if err != nil && ctx.Err() == nil {
+				l.Error("failed to get streams", kitslog.Err(err))
+			}
+
+			for _, stream := range streams {
+				if err := p.claimStream(ctx, consumer, stream); err != nil && ctx.Err() == nil {
+					l.Error("failed to claim idle entries", kitslog.Err(err), slog.String("stream", stream))
+				}
+			}
+		}
+	}
+}
+
+func (p *RedisEventStore) claimStream(ctx context.Context, consumer kitevent.Consumer, stream string) error {
+	limit := p.freeSlots(consumer.Name())
+	if limit == 0 {
+		return nil
+	}
+
+	entries, _, err := p.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    consumer.Name(),
+		Consumer: p.consumerID,
+		MinIdle:  minIdle(consumer),
+		Start:    "0-0",
+		Count:    int64(limit),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		pending, err := p.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  consumer.Name(),
+			Start:  entry.ID,
+			End:    entry.ID,
+			Count:  1,
+		}).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			continue
+		}
+
+		attempt := int32(pending[0].RetryCount)
+		if attempt > maxRetries(consumer) {
+			p.deadLetter(ctx, consumer, stream, entry, errors.New("consumer timeout reached"))
+			continue
+		}
+
+		p.dispatch(ctx, consumer, stream, entry, attempt)
+	}
+
+	return nil
+}
+
+// dispatch takes a worker slot (and a consumer slot if any) and processes the entry in a goroutine.
+//
+// The slots freed before reading the entries may have been taken since by another consumer or by
+// claimIdle. dispatch does not wait for a slot: waiting could outlast the minIdle of the entry, which
+// would then be claimed and processed twice. The entry is requeued instead.
+func (p *RedisEventStore) dispatch(
+	ctx context.Context,
+	consumer kitevent.Consumer,
+	stream string,
+	entry redis.XMessage,
+	attempt int32,
+) {
+	release, ok := p.tryAcquireSlots(consumer.Name())
+	if !ok {
+		if err := p.requeue(ctx, consumer, stream, entry, attempt); err != nil {
+			p.logger.Error("failed to requeue entry", kitslog.Err(err),
+				slog.String("consumer", consumer.Name()),
+				slog.String("entry_id", entry.ID))
+		}
+
+		return
+	}
+
+	go func() {
+		defer release()
+
+		msg := messageFromValues(entry.Values)
+		envelope := msg.envelope()
+
+		l := p.logger.With(
+			slog.String("consumer", consumer.Name()),
+			slog.String("event_name", msg.Name),
+			slog.String("entry_id", entry.ID),
+			slog.Int("attempt", int(attempt)))
+
+		event, err := kitevent.ConsumerEvent(consumer, envelope, envelope.Metadata)
+		if err != nil {
+			p.deadLetter(ctx, consumer, stream, entry, fmt.Errorf("failed to convert payload to event: %w", err))
+			return
+		}
+
+		l.Info("processing event")
+
+		err = callWithTimeout(ctx, *consumer.Options().Timeout, func() error {
+			return kitevent.CallConsumer(kitevent.CallConsumerParams{
+				Ctx:     kitevent.ContextWithMetadata(ctx, envelope.Metadata),
+				Event:   event,
+				Handler: consumer,
+			})
+		})
+
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			// the entry stays pending, it is claimed by claimIdle once idle for the timeout
+			l.Error("consumer timeout reached")
+		case err == nil:
+			if err := p.client.XAck(ctx, stream, consumer.Name(), entry.ID).Err(); err != nil {
+				l.Error("failed to acknowledge entry", kitslog.Err(err))
+			}
+		case attempt >= maxRetries(consumer):
+			l.Error("failed to process event and max retries reached", kitslog.Err(err))
+			p.deadLetter(ctx, consumer, stream, entry, err)
+		default:
+			l.Error("failed to process event, will retry", kitslog.Err(err))
+
+			if err := p.retryLater(ctx, consumer, stream, entry, attempt); err != nil {
+				l.Error("failed to schedule retry", kitslog.Err(err))
+			}
+		}
+	}()
+}
+
+// retryLater resets the idle time of the pending entry so it is claimed once the retry interval is elapsed.
+func (p *RedisEventStore) retryLater(
+	ctx context.Context,
+	consumer kitevent.Consumer,
+	stream string,
+	entry redis.XMessage,
+	attempt int32,
+) error {
+	idle := minIdle(consumer)
+	if retryInterval := consumer.Options().RetryInterval; retryInterval != nil {
+		idle -= *retryInterval
+	}
+
+	return p.client.Do(ctx, "XCLAIM", stream, consumer.Name(), p.consumerID, 0, entry.ID,
+		"IDLE", idle.Milliseconds(), "RETRYCOUNT", attempt, "JUSTID").Err()
+}
+
+// requeue makes the pending entry idle for minIdle and restores its delivery count, so it is
+// claimed again by claimIdle without counting this delivery as an attempt.
+func (p *RedisEventStore) requeue(
+	ctx context.Context,
+	consumer kitevent.Consumer,
+	stream string,
+	entry redis.XMessage,
+	attempt int32,
+) error {
+	return p.client.Do(ctx, "XCLAIM", stream, consumer.Name(), p.consumerID, 0, entry.ID,
+		"IDLE", minIdle(consumer).Milliseconds(), "RETRYCOUNT", attempt-1, "JUSTID").Err()
+}
+
+// deadLetter adds the entry to the dead letter stream of its event with the consumer and the error,
+// and acknowledges it, it will never be retried.
+func (p *RedisEventStore) deadLetter(
+	ctx context.Context,
+	consumer kitevent.Consumer,
+	stream string,
+	entry redis.XMessage,
+	err error,
+) {
+	msg := messageFromValues(entry.Values)
+
+	p.logger.Error("sending event to dead letter queue", kitslog.Err(err),
+		slog.String("consumer", consumer.Name()),
+		slog.String("event_name", msg.Name),
+		slog.String("entry_id", entry.ID))
+
+	values := msg.values()
+	values["consumer"] = consumer.Name()
+	values["entry_id"] = entry.ID
+	values["error"] = err.Error()
+	values["failed_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	_, errPipe := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: p.dlqKey(msg.Name), Values: values})
+		pipe.XAck(ctx, stream, consumer.Name(), entry.ID)
+		return nil
+	})
+	if errPipe != nil {
+		p.logger.Error("failed to send event to dead letter queue", kitslog.Err(errPipe),
+			slog.String("entry_id", entry.ID))
+	}
+}
+
+// freeSlots returns the number of entries that can be processed right now by a consumer.
+func (p *RedisEventStore) freeSlots(consumerName string) int {
+	free := min(cap(p.workerSlots)-len(p.workerSlots), p.config.BatchSize)
+
+	if slots, ok := p.consumerSlots[consumerName]; ok {
+		free = min(free, cap(slots)-len(slots))
+	}
+
+	return free
+}
+
+// tryAcquireSlots takes a worker slot (and a consumer slot if any) without waiting, the returned
+// function releases them. It returns false if a slot is not free.
+func (p *RedisEventStore) tryAcquireSlots(consumerName string) (func(), bool) {
+	consumerSlots, hasConsumerSlots := p.consumerSlots[consumerName]
+
+	select {
+	case p.workerSlots <- struct{}{}:
+	default:
+		return nil, false
+	}
+
+	if hasConsumerSlots {
+		select {
+		case consumerSlots <- struct{}{}:
+		default:
+			<-p.workerSlots
+			return nil, false
+		}
+	}
+
+	return func() {
+		<-p.workerSlots
+		if hasConsumerSlots {
+			<-consumerSlots
+		}
+
+		p.slotsMu.Lock()
+		close(p.slotsReleased)
+		p.slotsReleased = make(chan struct{})
+		p.slotsMu.Unlock()
+	}, true
+}
+
+// waitForSlots waits until slots are released or the poll interval is elapsed.
+func (p *RedisEventStore) waitForSlots(ctx context.Context) {
+	p.slotsMu.Lock()
+	released := p.slotsReleased
+	p.slotsMu.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-released:
+	case <-time.After(p.config.PollInterval):
+	}
+}
+
+// minIdle is the idle time after which a pending entry of the consumer is claimed: an entry is never
+// claimed while its consumer may still be processing it.
+func minIdle(consumer kitevent.Consumer) time.Duration {
+	idle := *consumer.Options().Timeout
+	if retryInterval := consumer.Options().RetryInterval; retryInterval != nil {
+		idle = max(idle, *retryInterval)
+	}
+
+	return idle
+}
+
+func maxRetries(consumer kitevent.Consumer) int32 {
+	if consumer.Options().MaxRetries != nil {
+		return *consumer.Options().MaxRetries
+	}
+
+	return 1
+}
+
+// callWithTimeout calls the consumer and returns context.DeadlineExceeded if it does not return before the timeout.
+func callWithTimeout(ctx context.Context, timeout time.Duration, call func() error) error {
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- call()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-chErr:
+		return err
+	}
+}
+
+// sleep waits for the duration, it returns false if the context is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
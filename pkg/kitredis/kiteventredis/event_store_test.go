@@ -0,0 +1,285 @@
+package kiteventredis
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	ID int
+}
+
+func (testEvent) EventName() kitevent.EventName {
+	return kitevent.NewEventName("test_event")
+}
+
+type testConsumer struct {
+	name    string
+	opts    *kitevent.ConsumerOptions
+	consume func(ctx context.Context, event *testEvent) error
+}
+
+func (c testConsumer) Consume(ctx context.Context, event *testEvent) error {
+	return c.consume(ctx, event)
+}
+
+func (c testConsumer) Options() *kitevent.ConsumerOptions {
+	return c.opts
+}
+
+func (c testConsumer) Name() string {
+	return c.name
+}
+
+func newTestRedisEventStore(t *testing.T) (*RedisEventStore, redis.UniversalClient) {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	store := New(client, slog.Default(), &RedisEventStoreConfig{
+		KeyPrefix:    "kitevent:",
+		BlockTimeout: 50 * time.Millisecond,
+		PollInterval: 20 * time.Millisecond,
+		Workers:      2,
+		BatchSize:    10,
+	})
+
+	t.Cleanup(func() { _ = store.OnStop(context.Background()) })
+
+	return store, client
+}
+
+func TestRedisEventStore(t *testing.T) {
+	t.Run("consumes an event", func(t *testing.T) {
+		store, _ := newTestRedisEventStore(t)
+
+		received := make(chan int, 1)
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				received <- event.ID
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		select {
+		case id := <-received:
+			require.Equal(t, 1, id)
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not consumed")
+		}
+	})
+
+	t.Run("retries a failed event by claiming it", func(t *testing.T) {
+		store, _ := newTestRedisEventStore(t)
+
+		var calls atomic.Int32
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions().
+				WithMaxRetry(2).
+				WithRetryInterval(50 * time.Millisecond),
+			consume: func(ctx context.Context, event *testEvent) error {
+				if calls.Add(1) == 1 {
+					return errors.New("first call fails")
+				}
+
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		require.Eventually(t, func() bool {
+			return calls.Load() == 2
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("sends the event to the dead letter stream when max retries is reached", func(t *testing.T) {
+		store, client := newTestRedisEventStore(t)
+
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				return errors.New("always fails")
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		var entries []redis.XMessage
+		require.Eventually(t, func() bool {
+			entries, _ = client.XRange(context.Background(), "kitevent:dlq:test_event", "-", "+").Result()
+			return len(entries) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+
+		require.Equal(t, "test_consumer", entries[0].Values["consumer"])
+		require.Equal(t, "always fails", entries[0].Values["error"])
+
+		pending, err := client.XPending(context.Background(), "kitevent:stream:test_event", "test_consumer").Result()
+		require.NoError(t, err)
+		require.Zero(t, pending.Count)
+	})
+
+	t.Run("requeues the entry read without a free slot", func(t *testing.T) {
+		store, client := newTestRedisEventStore(t)
+		ctx := context.Background()
+		stream := "kitevent:stream:test_event"
+
+		received := make(chan int, 1)
+		consumer := testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				received <- event.ID
+				return nil
+			},
+		}
+		store.AddConsumer(testEvent{}.EventName(), consumer)
+
+		require.NoError(t, store.ensureGroup(ctx, stream, consumer.Name()))
+		require.NoError(t, store.Produce(ctx, &testEvent{ID: 1}, nil))
+
+		results, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    consumer.Name(),
+			Consumer: store.consumerID,
+			Streams:  []string{stream, ">"},
+			Count:    1,
+		}).Result()
+		require.NoError(t, err)
+
+		// the slots freed before the read are taken by other entries
+		for i := 0; i < cap(store.workerSlots); i++ {
+			store.workerSlots <- struct{}{}
+		}
+
+		store.dispatch(ctx, consumer, stream, results[0].Messages[0], 1)
+
+		pending, err := client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  consumer.Name(),
+			Start:  "-",
+			End:    "+",
+			Count:  10,
+		}).Result()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		require.Zero(t, pending[0].RetryCount)
+
+		for i := 0; i < cap(store.workerSlots); i++ {
+			<-store.workerSlots
+		}
+
+		// the entry is claimed as its first attempt, it would be dead-lettered otherwise
+		require.NoError(t, store.OnStart(ctx))
+
+		select {
+		case id := <-received:
+			require.Equal(t, 1, id)
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not consumed")
+		}
+	})
+
+	t.Run("delays the event until ProduceAt", func(t *testing.T) {
+		store, _ := newTestRedisEventStore(t)
+
+		consumed := make(chan time.Time, 1)
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				consumed <- time.Now()
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+
+		produceAt := time.Now().Add(200 * time.Millisecond)
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1},
+			kitevent.NewProducerOptions().WithProduceAt(produceAt)))
+
+		select {
+		case at := <-consumed:
+			require.False(t, at.Before(produceAt))
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not consumed")
+		}
+	})
+	t.Run("calls every consumer in ProduceSync", func(t *testing.T) {
+		store, _ := newTestRedisEventStore(t)
+
+		errFirst := errors.New("first consumer fails")
+		var called []string
+
+		for _, name := range []string{"first", "second"} {
+			name := name
+			store.AddConsumer(testEvent{}.EventName(), testConsumer{
+				name: name,
+				opts: kitevent.NewConsumerOptions(),
+				consume: func(ctx context.Context, event *testEvent) error {
+					called = append(called, name)
+					if name == "first" {
+						return errFirst
+					}
+
+					return nil
+				},
+			})
+		}
+
+		err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+		require.ErrorIs(t, err, errFirst)
+		require.Equal(t, []string{"first", "second"}, called)
+	})
+
+	t.Run("retries each consumer on its own in ProduceSync", func(t *testing.T) {
+		store, _ := newTestRedisEventStore(t)
+
+		errFirst := errors.New("first consumer fails")
+		calls := map[string]int{}
+
+		for _, name := range []string{"first", "second"} {
+			name := name
+			store.AddConsumer(testEvent{}.EventName(), testConsumer{
+				name: name,
+				opts: kitevent.NewConsumerOptions().WithMaxRetry(3),
+				consume: func(ctx context.Context, event *testEvent) error {
+					calls[name]++
+					if name == "first" {
+						return errFirst
+					}
+
+					return nil
+				},
+			})
+		}
+
+		err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+		require.ErrorIs(t, err, errFirst)
+		require.Equal(t, map[string]int{"first": 4, "second": 1}, calls)
+	})
+}
+
+func TestNew_DefaultsWorkersAndBatchSize(t *testing.T) {
+	config := &RedisEventStoreConfig{}
+	store := New(nil, slog.Default(), config)
+
+	require.Equal(t, defaultWorkers, cap(store.workerSlots))
+	require.Equal(t, defaultBatchSize, store.config.BatchSize)
+	require.Zero(t, config.Workers)
+}
@@ -0,0 +1,116 @@
+package kiteventredis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// message is the content of a stream entry, and of a member of the delayed sorted set
+type message struct {
+	// ID makes the members of the delayed sorted set unique, it is empty in the streams
+	ID string `json:"id,omitempty"`
+
+	Name     string `json:"name"`
+	Payload  string `json:"payload"`
+	Metadata string `json:"metadata"`
+}
+
+func messageFromValues(values map[string]any) message {
+	str := func(key string) string {
+		value, _ := values[key].(string)
+		return value
+	}
+
+	return message{
+		Name:     str("name"),
+		Payload:  str("payload"),
+		Metadata: str("metadata"),
+	}
+}
+
+func (m message) values() map[string]any {
+	return map[string]any{
+		"name":     m.Name,
+		"payload":  m.Payload,
+		"metadata": m.Metadata,
+	}
+}
+
+// envelope returns the raw representation of the event, as consumed by the consumers
+func (m message) envelope() *kitevent.Envelope {
+	var metadata map[string]any
+	_ = json.Unmarshal([]byte(m.Metadata), &metadata)
+
+	return &kitevent.Envelope{
+		Name:     kitevent.NewEventName(m.Name),
+		Payload:  json.RawMessage(m.Payload),
+		Metadata: metadata,
+	}
+}
+
+// delay adds the message to the delayed sorted set, it is moved to its stream by moveDelayed once due.
+func (p *RedisEventStore) delay(ctx context.Context, msg message, at time.Time) error {
+	msg.ID = uuid.New().String()
+
+	member, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delayed event: %w", err)
+	}
+
+	_, err = p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, p.key("streams"), msg.Name)
+		pipe.ZAdd(ctx, p.key("delayed"), redis.Z{Score: float64(at.UnixMilli()), Member: member})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add delayed event: %w", err)
+	}
+
+	return nil
+}
+
+// moveDelayedScript moves the due members of the delayed sorted set KEYS[1] to their stream, prefixed
+// by ARGV[3], atomically so a member is never lost nor moved twice by concurrent stores.
+var moveDelayedScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+
+for _, member in ipairs(due) do
+	local msg = cjson.decode(member)
+
+	redis.call('XADD', ARGV[3] .. msg.name, '*',
+		'name', msg.name,
+		'payload', msg.payload,
+		'metadata', msg.metadata)
+	redis.call('ZREM', KEYS[1], member)
+end
+
+return #due
+`)
+
+// moveDelayed is a blocking function that moves the due delayed events to their stream.
+func (p *RedisEventStore) moveDelayed(ctx context.Context) {
+	for sleep(ctx, p.config.PollInterval) {
+		for {
+			moved, err := moveDelayedScript.Run(ctx, p.client,
+				[]string{p.key("delayed")},
+				time.Now().UnixMilli(), p.config.BatchSize, p.streamKey("")).Int()
+			if err != nil {
+				if ctx.Err() == nil {
+					p.logger.Error("failed to move delayed events", kitslog.Err(err))
+				}
+
+				break
+			}
+
+			if moved < p.config.BatchSize {
+				break
+			}
+		}
+	}
+}
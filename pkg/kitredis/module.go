@@ -0,0 +1,88 @@
+package kitredis
+
+import (
+	"context"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitcache"
+	"github.com/kitcat-framework/kitcat/kitdi"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/pkg/kitredis/kitcacheredis"
+	"github.com/kitcat-framework/kitcat/pkg/kitredis/kiteventredis"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"math"
+)
+
+type Config struct {
+	Addr     string `cfg:"addr"`
+	Username string `cfg:"username"`
+	Password string `cfg:"password"`
+	DB       int    `cfg:"db"`
+
+	// PoolSize is the maximum number of connections, 0 uses the go-redis default (10 per CPU)
+	PoolSize int `cfg:"pool_size"`
+
+	// MinIdleConns is the number of idle connections kept open
+	MinIdleConns int `cfg:"min_idle_conns"`
+
+	Options *redis.Options // manually configurable, the fields of the config are overridden
+}
+
+func (c *Config) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".database.redis"
+
+	viper.SetDefault(prefix+".addr", "localhost:6333")
+	viper.SetDefault(prefix+".username", "")
+	viper.SetDefault(prefix+".password", "")
+	viper.SetDefault(prefix+".db", 0)
+	viper.SetDefault(prefix+".pool_size", 0)
+	viper.SetDefault(prefix+".min_idle_conns", 0)
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal kitredis config: %w")
+}
+
+func init() {
+	kitcat.RegisterConfig(new(Config))
+}
+
+type KitRedis struct {
+	config *Config
+	client *redis.Client
+}
+
+func Module(app *kitcat.App, config *Config) {
+	m := &KitRedis{config: config}
+
+	app.Provides(
+		kitcat.ProvideConfigurableModule(m),
+		kitevent.ProvideStore(kiteventredis.New),
+		kitcache.ProvideStore(kitcacheredis.New),
+		kitdi.Annotate(kitcacheredis.NewPubSubInvalidator, kitdi.As(new(kitcache.Invalidator))),
+	)
+}
+
+func (m *KitRedis) Configure(_ context.Context, app *kitcat.App) error {
+	opts := m.config.Options
+	if opts == nil {
+		opts = &redis.Options{}
+	}
+
+	opts.Addr = m.config.Addr
+	opts.Username = m.config.Username
+	opts.Password = m.config.Password
+	opts.DB = m.config.DB
+	opts.PoolSize = m.config.PoolSize
+	opts.MinIdleConns = m.config.MinIdleConns
+
+	m.client = redis.NewClient(opts)
+
+	app.Provides(kitdi.Annotate(m.client, kitdi.As(new(redis.UniversalClient))))
+
+	return nil
+}
+
+func (m *KitRedis) Priority() uint8 { return math.MaxUint8 }
+
+func (m *KitRedis) Name() string {
+	return "kitredis"
+}
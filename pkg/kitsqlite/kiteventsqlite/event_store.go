@@ -0,0 +1,485 @@
+package kiteventsqlite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kitcat-framework/kitcat"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/kitslog"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"log/slog"
+	"time"
+)
+
+type SqliteEventStoreConfig struct {
+	// PollInterval is the interval between two polls when there is nothing to process,
+	// the events produced by this store are processed without waiting for it
+	PollInterval time.Duration `cfg:"poll_interval"`
+
+	// AutoMigrate creates the kitevent tables on start
+	AutoMigrate bool `cfg:"auto_migrate"`
+
+	// Workers is the maximum number of events processed in parallel by the store
+	Workers int `cfg:"workers"`
+
+	// BatchSize is the maximum number of events claimed per consumer in a single query
+	BatchSize int `cfg:"batch_size"`
+}
+
+// defaultWorkers and defaultBatchSize replace a Workers or BatchSize lower than 1, with which no
+// event would ever be claimed
+const (
+	defaultWorkers   = 10
+	defaultBatchSize = 10
+)
+
+func (c *SqliteEventStoreConfig) InitConfig(prefix string) kitcat.ConfigUnmarshal {
+	prefix = prefix + ".kitevent.config_stores.sqlite"
+
+	viper.SetDefault(prefix+".poll_interval", time.Millisecond*500)
+	viper.SetDefault(prefix+".auto_migrate", true)
+	viper.SetDefault(prefix+".workers", defaultWorkers)
+	viper.SetDefault(prefix+".batch_size", defaultBatchSize)
+
+	return kitcat.ConfigUnmarshalHandler(prefix, c, "unable to unmarshal sqlite event store config: %w")
+}
+
+// SqliteEventStore is a durable kitevent.Store for single node deployments, the events and their
+// handler results are stored in the kitsqlite database with the same status model as the postgres store.
+type SqliteEventStore struct {
+	consumers  *kitevent.ConsumerRegistry
+	db         *gorm.DB
+	store      EventStoreStorage
+	logger     *slog.Logger
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+
+	config *SqliteEventStoreConfig
+
+	// workerSlots and consumerSlots are semaphores, an event is claimed only when a slot is free
+	// in both of them, so claimed events never wait for a worker
+	workerSlots   chan struct{}
+	consumerSlots map[string]chan struct{}
+
+	// wakeup is signaled when events may be available before the next poll
+	wakeup chan struct{}
+}
+
+func New(db *gorm.DB, logger *slog.Logger, config *SqliteEventStoreConfig) *SqliteEventStore {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	config = withDefaults(config)
+
+	return &SqliteEventStore{
+		db: db,
+		logger: logger.With(
+			kitslog.Module("kitevent"),
+			slog.String("store", "sqlite")),
+		consumers:     kitevent.NewConsumerRegistry(),
+		store:         NewSqliteStorage(db),
+		ctx:           ctx,
+		cancelFunc:    cancelFunc,
+		config:        config,
+		workerSlots:   make(chan struct{}, config.Workers),
+		consumerSlots: make(map[string]chan struct{}),
+		wakeup:        make(chan struct{}, 1),
+	}
+}
+
+// withDefaults returns a copy of the config with the defaults of the Workers and the BatchSize
+// lower than 1.
+func withDefaults(config *SqliteEventStoreConfig) *SqliteEventStoreConfig {
+	c := *config
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+
+	return &c
+}
+
+func (p *SqliteEventStore) OnStart(_ context.Context) error {
+	if p.config.AutoMigrate {
+		if err := Migrate(p.db); err != nil {
+			return err
+		}
+	}
+
+	go p.run(p.ctx)
+	go p.monitorTimeoutEvents(p.ctx)
+
+	return nil
+}
+
+func (p *SqliteEventStore) Produce(ctx context.Context, event kitevent.Event, opt *kitevent.ProducerOptions) error {
+	handlersConcerned := p.consumers.Get(event.EventName())
+	if len(handlersConcerned) == 0 {
+		return errors.New("no consumer found for event")
+	}
+
+	marshalPayload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	if opt == nil {
+		opt = kitevent.NewProducerOptions()
+	}
+
+	opt.WithEventVersion(event)
+
+	marshalMetadata, err := json.Marshal(opt.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	now := time.Now().UTC()
+
+	processableAt := now
+	if opt.ProduceAt != nil {
+		processableAt = opt.ProduceAt.UTC()
+	}
+
+	evt := Event{
+		Payload:   marshalPayload,
+		Metadata:  marshalMetadata,
+		EventName: event.EventName().Name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	processors := make([]*EventProcessingState, len(handlersConcerned))
+	for i, consumer := range handlersConcerned {
+		maxRetries := int32(1)
+		if consumer.Options().MaxRetries != nil {
+			maxRetries = *consumer.Options().MaxRetries
+		}
+
+		retryIntervalMs := int64(0)
+		if consumer.Options().RetryInterval != nil {
+			retryIntervalMs = consumer.Options().RetryInterval.Milliseconds()
+		}
+
+		processors[i] = &EventProcessingState{
+			ConsumerName:                  consumer.Name(),
+			Status:                        EventProcessingStateStatusAvailable,
+			RetryNumber:                   1,
+			ConsumerOptionMaxRetries:      maxRetries,
+			ConsumerOptionRetryIntervalMs: retryIntervalMs,
+			ConsumerOptionTimeoutMs:       consumer.Options().Timeout.Milliseconds(),
+			CreatedAt:                     now,
+			UpdatedAt:                     now,
+			ProcessableAt:                 processableAt,
+		}
+	}
+
+	if err := p.store.AddEvent(ctx, evt, processors); err != nil {
+		return fmt.Errorf("failed to add event: %w", err)
+	}
+
+	// the event may not be committed yet if the context carries a transaction, the poll catches it
+	if opt.ProduceAt == nil {
+		p.wake()
+	}
+
+	return nil
+}
+
+func (p *SqliteEventStore) ProduceSync(
+	ctx context.Context,
+	event kitevent.Event,
+	opts *kitevent.ProducerOptions,
+) error {
+	if opts == nil {
+		opts = kitevent.NewProducerOptions()
+	}
+
+	handlers := p.consumers.Get(event.EventName())
+
+	var errs []error
+
+	for _, handler := range handlers {
+		// each consumer counts its own retries
+		consumerOpts := *opts
+
+		err := kitevent.LocalCallHandler(kitevent.LocalCallConsumerParams{
+			Ctx:           ctx,
+			Event:         event,
+			Producer:      p,
+			Opts:          &consumerOpts,
+			Consumer:      handler,
+			Logger:        p.logger,
+			IsProduceSync: true,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *SqliteEventStore) AddConsumer(eventName kitevent.EventName, handler kitevent.Consumer) {
+	p.consumers.Add(eventName, handler)
+
+	if concurrency := handler.Options().Concurrency; concurrency != nil {
+		p.consumerSlots[handler.Name()] = make(chan struct{}, *concurrency)
+	}
+}
+
+func (p *SqliteEventStore) OnStop(_ context.Context) error {
+	p.cancelFunc()
+
+	return nil
+}
+
+func (p *SqliteEventStore) Name() string {
+	return "sqlite"
+}
+
+// run is a blocking function that claims, for each consumer, the handler results in
+// EventProcessingStateStatusAvailable status, as many as there are free worker slots for this consumer.
+// Each claimed handler result is processed in its own goroutine and updated accordingly.
+// If nothing is claimed, it waits for a wakeup or the poll interval and tries again.
+func (p *SqliteEventStore) run(ctx context.Context) {
+	for {
+		claimed := 0
+
+		for _, consumer := range p.consumers.All() {
+			limit := p.freeSlots(consumer.Name())
+			if limit == 0 {
+				continue
+			}
+
+			evtProcessingStates, err := p.store.FindAvailableEvents(ctx, consumer.Name(), limit)
+			if err != nil && ctx.Err() == nil {
+				p.logger.Error("failed to claim events", kitslog.Err(err), slog.String("consumer", consumer.Name()))
+				continue
+			}
+
+			for _, evtProcessingState := range evtProcessingStates {
+				p.dispatch(ctx, evtProcessingState)
+			}
+
+			claimed += len(evtProcessingStates)
+		}
+
+		if claimed > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wakeup:
+		case <-time.After(p.config.PollInterval):
+		}
+	}
+}
+
+func (p *SqliteEventStore) wake() {
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// freeSlots returns the number of events that can be claimed right now for a consumer.
+// It is only called from run, which is the only goroutine taking slots.
+func (p *SqliteEventStore) freeSlots(consumerName string) int {
+	free := min(cap(p.workerSlots)-len(p.workerSlots), p.config.BatchSize)
+
+	if slots, ok := p.consumerSlots[consumerName]; ok {
+		free = min(free, cap(slots)-len(slots))
+	}
+
+	return free
+}
+
+// dispatch takes a worker slot (and a consumer slot if any) and processes the handler result in a goroutine.
+func (p *SqliteEventStore) dispatch(ctx context.Context, evtProcessingState *EventProcessingState) {
+	if evtProcessingState.Event == nil {
+		p.logger.Error("event not found for handler result", slog.Int("event_id", int(evtProcessingState.EventID)))
+		return
+	}
+
+	consumerSlots, hasConsumerSlots := p.consumerSlots[evtProcessingState.ConsumerName]
+
+	p.workerSlots <- struct{}{}
+	if hasConsumerSlots {
+		consumerSlots <- struct{}{}
+	}
+
+	go func() {
+		defer func() {
+			<-p.workerSlots
+			if hasConsumerSlots {
+				<-consumerSlots
+			}
+
+			// events may have been left unclaimed because the slots were full
+			p.wake()
+		}()
+
+		handler, ok := p.consumers.Find(
+			kitevent.NewEventName(evtProcessingState.Event.EventName),
+			evtProcessingState.ConsumerName)
+		if !ok {
+			p.logger.Warn("no consumer registered for handler result",
+				slog.String("consumer", evtProcessingState.ConsumerName),
+				slog.String("event_name", evtProcessingState.Event.EventName))
+			return
+		}
+
+		envelope := evtProcessingState.Event.Envelope()
+
+		event, err := kitevent.ConsumerEvent(handler, envelope, envelope.Metadata)
+		if err != nil {
+			p.deadLetter(ctx, evtProcessingState, fmt.Errorf("failed to convert payload to event: %w", err))
+			return
+		}
+
+		p.processConsumer(kitevent.ContextWithMetadata(ctx, envelope.Metadata), event, handler, evtProcessingState)
+	}()
+}
+
+// deadLetter marks the handler result as EventProcessingStateStatusDeadLetter, it will never be retried.
+func (p *SqliteEventStore) deadLetter(ctx context.Context, evtProcessingState *EventProcessingState, err error) {
+	p.logger.Error("sending event to dead letter queue", kitslog.Err(err),
+		slog.Int("event_id", int(evtProcessingState.EventID)),
+		slog.String("consumer", evtProcessingState.ConsumerName))
+
+	evtProcessingState.UpdatedAt = time.Now().UTC()
+	evtProcessingState.Error = lo.ToPtr(err.Error())
+	evtProcessingState.Status = EventProcessingStateStatusDeadLetter
+	evtProcessingState.FailedAt = lo.ToPtr(time.Now().UTC())
+
+	if err := p.store.SaveEventHandlers(ctx, []*EventProcessingState{evtProcessingState}); err != nil {
+		p.logger.Error("failed to save event consumer", kitslog.Err(err))
+	}
+}
+
+// monitorTimeoutEvents fails the pending handler results whose timeout is reached, and retries them
+// if they have retries left. The handler results of a crashed process are recovered the same way.
+func (p *SqliteEventStore) monitorTimeoutEvents(ctx context.Context) {
+	for {
+		evtProcessingState, err := p.store.FindPendingTimeoutEvent(ctx)
+		if err != nil && ctx.Err() == nil {
+			p.logger.Error("failed to claim timed out events", kitslog.Err(err))
+		}
+
+		if err != nil || evtProcessingState == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.config.PollInterval):
+			}
+
+			continue
+		}
+
+		l := p.logger.With(
+			slog.Int("event_id", int(evtProcessingState.EventID)),
+			slog.String("consumer", evtProcessingState.ConsumerName))
+
+		evtProcessingState.RunAt = lo.ToPtr(time.Now().UTC())
+		if evtProcessingState.PendingAt != nil {
+			evtProcessingState.DurationMs = time.Since(*evtProcessingState.PendingAt).Milliseconds()
+		}
+
+		p.saveResults(ctx, l, p.result(l, evtProcessingState, errors.New("consumer timeout reached")))
+	}
+}
+
+func (p *SqliteEventStore) processConsumer(
+	ctx context.Context,
+	evt kitevent.Event,
+	consumer kitevent.Consumer,
+	evtProcessingState *EventProcessingState,
+) {
+	startHandlerAt := time.Now()
+
+	l := p.logger.With(
+		slog.Int("event_id", int(evtProcessingState.EventID)),
+		slog.String("consumer", consumer.Name()),
+		slog.String("event_name", evt.EventName().Name))
+
+	l.Info("processing event")
+
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- kitevent.CallConsumer(kitevent.CallConsumerParams{
+			Ctx:     ctx,
+			Event:   evt,
+			Handler: consumer,
+		})
+	}()
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(evtProcessingState.ConsumerOptionTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	select {
+	case <-timeoutCtx.Done():
+		// handled by monitorTimeoutEvents, from timeout_at
+		return
+	case err := <-chErr:
+		evtProcessingState.RunAt = lo.ToPtr(time.Now().UTC())
+		evtProcessingState.DurationMs = time.Since(startHandlerAt).Milliseconds()
+
+		p.saveResults(ctx, l, p.result(l, evtProcessingState, err))
+	}
+}
+
+// result updates the handler result with the error returned by the consumer, it returns the handler
+// results to save: the updated one and its retry if any.
+func (p *SqliteEventStore) result(
+	l *slog.Logger,
+	evtProcessingState *EventProcessingState,
+	err error,
+) []*EventProcessingState {
+	evtProcessingState.UpdatedAt = time.Now().UTC()
+
+	if err == nil {
+		evtProcessingState.Status = EventProcessingStateStatusSuccess
+		evtProcessingState.SuccessAt = lo.ToPtr(time.Now().UTC())
+
+		return []*EventProcessingState{evtProcessingState}
+	}
+
+	evtProcessingState.Error = lo.ToPtr(err.Error())
+	evtProcessingState.Status = EventProcessingStateStatusFailed
+	evtProcessingState.FailedAt = lo.ToPtr(time.Now().UTC())
+
+	l = l.With(kitslog.Err(err),
+		slog.Int("retry_number", int(evtProcessingState.RetryNumber)),
+		slog.Int("max_retries", int(evtProcessingState.ConsumerOptionMaxRetries)),
+		slog.Int64("retry_interval_ms", evtProcessingState.ConsumerOptionRetryIntervalMs))
+
+	if evtProcessingState.RetryNumber >= evtProcessingState.ConsumerOptionMaxRetries {
+		l.Error("failed to process event and max retries reached")
+		return []*EventProcessingState{evtProcessingState}
+	}
+
+	l.Error("failed to process event, will retry")
+
+	retry := evtProcessingState.Next()
+	retry.ProcessableAt = time.Now().UTC().Add(
+		time.Duration(evtProcessingState.ConsumerOptionRetryIntervalMs) * time.Millisecond)
+	retry.RetryNumber += 1
+
+	return []*EventProcessingState{retry, evtProcessingState}
+}
+
+// saveResults saves the handler results in a single query.
+func (p *SqliteEventStore) saveResults(ctx context.Context, l *slog.Logger, results []*EventProcessingState) {
+	if err := p.store.SaveEventHandlers(ctx, results); err != nil {
+		l.Error("failed to save event consumer", kitslog.Err(err))
+	}
+}
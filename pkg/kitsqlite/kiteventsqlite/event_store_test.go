@@ -0,0 +1,221 @@
+package kiteventsqlite
+
+import (
+	"context"
+	"errors"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log/slog"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	ID int
+}
+
+func (testEvent) EventName() kitevent.EventName {
+	return kitevent.NewEventName("test_event")
+}
+
+type testConsumer struct {
+	name    string
+	opts    *kitevent.ConsumerOptions
+	consume func(ctx context.Context, event *testEvent) error
+}
+
+func (c testConsumer) Consume(ctx context.Context, event *testEvent) error {
+	return c.consume(ctx, event)
+}
+
+func (c testConsumer) Options() *kitevent.ConsumerOptions {
+	return c.opts
+}
+
+func (c testConsumer) Name() string {
+	return c.name
+}
+
+func newTestSqliteEventStore(t *testing.T) (*SqliteEventStore, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "kitevent.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	store := New(db, slog.Default(), &SqliteEventStoreConfig{
+		PollInterval: 10 * time.Millisecond,
+		AutoMigrate:  true,
+		Workers:      2,
+		BatchSize:    10,
+	})
+
+	t.Cleanup(func() { _ = store.OnStop(context.Background()) })
+
+	return store, db
+}
+
+func statuses(t *testing.T, db *gorm.DB) []EventProcessingStateStatus {
+	var states []*EventProcessingState
+	require.NoError(t, db.Order("id").Find(&states).Error)
+
+	result := make([]EventProcessingStateStatus, len(states))
+	for i, state := range states {
+		result[i] = state.Status
+	}
+
+	return result
+}
+
+func TestSqliteEventStore(t *testing.T) {
+	t.Run("retries a failed event", func(t *testing.T) {
+		store, db := newTestSqliteEventStore(t)
+
+		var calls atomic.Int32
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions().WithMaxRetry(2),
+			consume: func(ctx context.Context, event *testEvent) error {
+				if calls.Add(1) == 1 {
+					return errors.New("first call fails")
+				}
+
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		require.Eventually(t, func() bool {
+			return len(statuses(t, db)) == 2 && statuses(t, db)[1] == EventProcessingStateStatusSuccess
+		}, 2*time.Second, 10*time.Millisecond)
+
+		require.Equal(t, []EventProcessingStateStatus{
+			EventProcessingStateStatusFailed,
+			EventProcessingStateStatusSuccess,
+		}, statuses(t, db))
+	})
+
+	t.Run("delays the event until ProduceAt", func(t *testing.T) {
+		store, db := newTestSqliteEventStore(t)
+
+		consumed := make(chan time.Time, 1)
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions(),
+			consume: func(ctx context.Context, event *testEvent) error {
+				consumed <- time.Now()
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+
+		produceAt := time.Now().Add(200 * time.Millisecond)
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1},
+			kitevent.NewProducerOptions().WithProduceAt(produceAt)))
+
+		select {
+		case at := <-consumed:
+			require.False(t, at.Before(produceAt))
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not consumed")
+		}
+
+		require.Eventually(t, func() bool {
+			return statuses(t, db)[0] == EventProcessingStateStatusSuccess
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("fails the event when the consumer timeout is reached", func(t *testing.T) {
+		store, db := newTestSqliteEventStore(t)
+
+		store.AddConsumer(testEvent{}.EventName(), testConsumer{
+			name: "test_consumer",
+			opts: kitevent.NewConsumerOptions().WithTimeout(50 * time.Millisecond),
+			consume: func(ctx context.Context, event *testEvent) error {
+				time.Sleep(time.Second)
+				return nil
+			},
+		})
+
+		require.NoError(t, store.OnStart(context.Background()))
+		require.NoError(t, store.Produce(context.Background(), &testEvent{ID: 1}, nil))
+
+		var state EventProcessingState
+		require.Eventually(t, func() bool {
+			require.NoError(t, db.First(&state).Error)
+			return state.Error != nil
+		}, 2*time.Second, 10*time.Millisecond)
+
+		require.Equal(t, EventProcessingStateStatusFailed, state.Status)
+		require.Equal(t, "consumer timeout reached", *state.Error)
+	})
+	t.Run("calls every consumer in ProduceSync", func(t *testing.T) {
+		store, _ := newTestSqliteEventStore(t)
+
+		errFirst := errors.New("first consumer fails")
+		var called []string
+
+		for _, name := range []string{"first", "second"} {
+			name := name
+			store.AddConsumer(testEvent{}.EventName(), testConsumer{
+				name: name,
+				opts: kitevent.NewConsumerOptions(),
+				consume: func(ctx context.Context, event *testEvent) error {
+					called = append(called, name)
+					if name == "first" {
+						return errFirst
+					}
+
+					return nil
+				},
+			})
+		}
+
+		err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+		require.ErrorIs(t, err, errFirst)
+		require.Equal(t, []string{"first", "second"}, called)
+	})
+
+	t.Run("retries each consumer on its own in ProduceSync", func(t *testing.T) {
+		store, _ := newTestSqliteEventStore(t)
+
+		errFirst := errors.New("first consumer fails")
+		calls := map[string]int{}
+
+		for _, name := range []string{"first", "second"} {
+			name := name
+			store.AddConsumer(testEvent{}.EventName(), testConsumer{
+				name: name,
+				opts: kitevent.NewConsumerOptions().WithMaxRetry(3),
+				consume: func(ctx context.Context, event *testEvent) error {
+					calls[name]++
+					if name == "first" {
+						return errFirst
+					}
+
+					return nil
+				},
+			})
+		}
+
+		err := store.ProduceSync(context.Background(), &testEvent{ID: 1}, nil)
+		require.ErrorIs(t, err, errFirst)
+		require.Equal(t, map[string]int{"first": 4, "second": 1}, calls)
+	})
+}
+
+func TestNew_DefaultsWorkersAndBatchSize(t *testing.T) {
+	config := &SqliteEventStoreConfig{}
+	store := New(nil, slog.Default(), config)
+
+	require.Equal(t, defaultWorkers, cap(store.workerSlots))
+	require.Equal(t, defaultBatchSize, store.config.BatchSize)
+	require.Zero(t, config.Workers)
+}
@@ -0,0 +1,38 @@
+create table if not exists kitevent_events
+(
+    id         integer primary key autoincrement,
+    payload    text,
+    metadata   text,
+    event_name varchar(255),
+    created_at datetime,
+    updated_at datetime
+);
+
+create table if not exists kitevent_event_processing_states
+(
+    id                                integer primary key autoincrement,
+    consumer_name                     varchar(255),
+    event_id                          integer references kitevent_events (id),
+    status                            varchar(255),
+    error                             text,
+    consumer_option_max_retries       integer,
+    consumer_option_retry_interval_ms integer,
+    consumer_option_timeout_ms        integer,
+    created_at                        datetime,
+    updated_at                        datetime,
+    processable_at                    datetime,
+    run_at                            datetime,
+    retry_number                      integer,
+    duration_ms                       integer,
+    timeout_at                        datetime,
+    failed_at                         datetime,
+    success_at                        datetime,
+    pending_at                        datetime
+);
+
+create index if not exists kitevent_event_processing_states_claim_idx
+    on kitevent_event_processing_states (status, consumer_name, processable_at);
+create index if not exists kitevent_event_processing_states_timeout_at_idx
+    on kitevent_event_processing_states (status, timeout_at);
+create index if not exists kitevent_event_processing_states_event_id_idx
+    on kitevent_event_processing_states (event_id);
@@ -0,0 +1,177 @@
+package kiteventsqlite
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/kitcat-framework/kitcat/pkg/kittx"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"time"
+)
+
+//go:embed schema.sql
+var schema string
+
+type EventStoreStorage interface {
+	AddEvent(ctx context.Context, event Event, processor []*EventProcessingState) error
+	FindAvailableEvents(ctx context.Context, consumerName string, limit int) ([]*EventProcessingState, error)
+	FindPendingTimeoutEvent(ctx context.Context) (*EventProcessingState, error)
+	SaveEventHandlers(ctx context.Context, handler []*EventProcessingState) error
+}
+
+type SqliteStorage struct {
+	db *gorm.DB
+}
+
+func NewSqliteStorage(db *gorm.DB) *SqliteStorage {
+	return &SqliteStorage{db: db}
+}
+
+// Migrate creates the tables of the store if they do not exist.
+func Migrate(db *gorm.DB) error {
+	if err := db.Exec(schema).Error; err != nil {
+		return fmt.Errorf("failed to create kitevent tables: %w", err)
+	}
+
+	return nil
+}
+
+// AddEvent inserts the event and its processors in a single transaction.
+// If the context carries a kittx transaction, the rows are inserted in it, so the event is
+// only visible to the consumers once the business data is committed.
+func (s SqliteStorage) AddEvent(ctx context.Context, event Event, processors []*EventProcessingState) error {
+	return kittx.MayTx(ctx, s.db).WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return fmt.Errorf("failed to create event: %w", err)
+		}
+
+		for _, processor := range processors {
+			processor.EventID = event.ID
+		}
+
+		if err := tx.Create(processors).Error; err != nil {
+			return fmt.Errorf("failed to create event processors: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (s SqliteStorage) SaveEventHandlers(ctx context.Context, handlers []*EventProcessingState) error {
+	err := s.db.WithContext(ctx).Omit("Event").Save(handlers).Error
+	if err != nil {
+		return fmt.Errorf("failed to update event handlers: %w", err)
+	}
+
+	return nil
+}
+
+// FindAvailableEvents claims at most limit available handler results of the consumer, with their event.
+//
+// SQLite has no row locks, the handler results are claimed by a single UPDATE ... RETURNING statement,
+// which runs under the write lock of the database: two stores sharing the file never claim the same
+// handler result. Use a busy timeout (e.g. `db.sqlite?_busy_timeout=5000`) to wait for the lock.
+func (s SqliteStorage) FindAvailableEvents(
+	ctx context.Context,
+	consumerName string,
+	limit int,
+) ([]*EventProcessingState, error) {
+	const query = `
+		update kitevent_event_processing_states
+		set status = ?,
+			pending_at = ?,
+			updated_at = ?,
+			timeout_at = strftime('%Y-%m-%d %H:%M:%f', ?, (consumer_option_timeout_ms / 1000.0) || ' seconds')
+		where id in (
+		  select id
+		  from kitevent_event_processing_states
+		  where status = ?
+			and consumer_name = ?
+			and processable_at <= ?
+		  order by id
+		  limit ?
+		)
+		returning *;
+	`
+
+	now := time.Now().UTC()
+
+	var handlers []*EventProcessingState
+
+	err := s.db.WithContext(ctx).Raw(query, EventProcessingStateStatusPending, now, now, now,
+		EventProcessingStateStatusAvailable, consumerName, now, limit).Scan(&handlers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event handlers: %w", err)
+	}
+
+	if err := s.withEvents(ctx, handlers); err != nil {
+		return nil, err
+	}
+
+	return handlers, nil
+}
+
+// FindPendingTimeoutEvent claims a pending handler result whose timeout is reached, with its event.
+// It returns nil if there is none.
+func (s SqliteStorage) FindPendingTimeoutEvent(ctx context.Context) (*EventProcessingState, error) {
+	const query = `
+		update kitevent_event_processing_states
+		set status = ?,
+			updated_at = ?
+		where id = (
+		  select id
+		  from kitevent_event_processing_states
+		  where status = ? and timeout_at <= ?
+		  order by id
+		  limit 1
+		)
+		returning *;
+	`
+
+	now := time.Now().UTC()
+
+	var handlers []*EventProcessingState
+
+	err := s.db.WithContext(ctx).Raw(query, EventProcessingStateStatusFailed, now,
+		EventProcessingStateStatusPending, now).Scan(&handlers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event handler: %w", err)
+	}
+
+	if len(handlers) == 0 {
+		return nil, nil
+	}
+
+	if err := s.withEvents(ctx, handlers); err != nil {
+		return nil, err
+	}
+
+	return handlers[0], nil
+}
+
+// withEvents loads the events of the handler results, RETURNING cannot join them.
+func (s SqliteStorage) withEvents(ctx context.Context, handlers []*EventProcessingState) error {
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	eventIDs := lo.Uniq(lo.Map(handlers, func(handler *EventProcessingState, _ int) int32 {
+		return handler.EventID
+	}))
+
+	var events []*Event
+	if err := s.db.WithContext(ctx).Where("id in ?", eventIDs).Find(&events).Error; err != nil {
+		return fmt.Errorf("failed to get events: %w", err)
+	}
+
+	eventsByID := lo.KeyBy(events, func(event *Event) int32 {
+		return event.ID
+	})
+
+	for _, handler := range handlers {
+		handler.Event = eventsByID[handler.EventID]
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package kiteventsqlite
+
+import (
+	"encoding/json"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"time"
+)
+
+type Event struct {
+	ID       int32
+	Payload  []byte
+	Metadata []byte
+
+	EventName string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (Event) TableName() string {
+	return "kitevent_events"
+}
+
+// Envelope returns the raw representation of the event, as consumed by the consumers
+func (e *Event) Envelope() *kitevent.Envelope {
+	var metadata map[string]any
+	_ = json.Unmarshal(e.Metadata, &metadata)
+
+	return &kitevent.Envelope{
+		Name:     kitevent.NewEventName(e.EventName),
+		Payload:  json.RawMessage(e.Payload),
+		Metadata: metadata,
+	}
+}
+
+// EventProcessingStateStatus is the status of a handler result, the statuses are the same as the postgres store
+type EventProcessingStateStatus string
+
+const (
+	EventProcessingStateStatusFailed    EventProcessingStateStatus = "FAILED"
+	EventProcessingStateStatusSuccess   EventProcessingStateStatus = "SUCCESS"
+	EventProcessingStateStatusPending   EventProcessingStateStatus = "PENDING"
+	EventProcessingStateStatusAvailable EventProcessingStateStatus = "AVAILABLE"
+
+	// EventProcessingStateStatusDeadLetter is set when the event cannot be decoded for the consumer,
+	// for instance with an unknown version (see kitevent.ErrUnknownEventVersion). It is never retried.
+	EventProcessingStateStatusDeadLetter EventProcessingStateStatus = "DEAD_LETTER"
+)
+
+// EventProcessingState is the result of an attempt of a consumer to process an event, a retry is a new row.
+// The dates are stored in UTC.
+type EventProcessingState struct {
+	ID           int32
+	ConsumerName string
+
+	EventID int32
+	Event   *Event
+
+	Status EventProcessingStateStatus
+	Error  *string
+
+	ConsumerOptionMaxRetries      int32
+	ConsumerOptionRetryIntervalMs int64
+	ConsumerOptionTimeoutMs       int64
+
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	ProcessableAt time.Time
+
+	RunAt       *time.Time
+	RetryNumber int32
+	DurationMs  int64
+
+	// TimeoutAt is set when the handler result is claimed, from PendingAt and ConsumerOptionTimeoutMs
+	TimeoutAt *time.Time
+
+	FailedAt  *time.Time
+	SuccessAt *time.Time
+	PendingAt *time.Time
+}
+
+func (EventProcessingState) TableName() string {
+	return "kitevent_event_processing_states"
+}
+
+// Next returns the retry of the handler result: a new AVAILABLE handler result of the same event
+// and consumer, processable now.
+func (c *EventProcessingState) Next() *EventProcessingState {
+	now := time.Now().UTC()
+
+	return &EventProcessingState{
+		ConsumerName:                  c.ConsumerName,
+		EventID:                       c.EventID,
+		Status:                        EventProcessingStateStatusAvailable,
+		ConsumerOptionMaxRetries:      c.ConsumerOptionMaxRetries,
+		ConsumerOptionRetryIntervalMs: c.ConsumerOptionRetryIntervalMs,
+		ConsumerOptionTimeoutMs:       c.ConsumerOptionTimeoutMs,
+		CreatedAt:                     now,
+		UpdatedAt:                     now,
+		ProcessableAt:                 now,
+		RetryNumber:                   c.RetryNumber,
+	}
+}
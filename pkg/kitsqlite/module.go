@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/kitcat-framework/kitcat"
 	"github.com/kitcat-framework/kitcat/kitdi"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"github.com/kitcat-framework/kitcat/pkg/kitsqlite/kiteventsqlite"
 	"github.com/spf13/viper"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -31,6 +33,7 @@ func (c *Config) InitConfig(prefix string) kitcat.ConfigUnmarshal {
 
 func Init() {
 	kitcat.RegisterConfig(new(Config))
+	kitcat.RegisterConfig(new(kiteventsqlite.SqliteEventStoreConfig))
 }
 
 type Module struct {
@@ -43,6 +46,7 @@ func New(_ kitdi.Invokable, config *Config, app *kitcat.App) {
 
 	app.Provides(
 		kitcat.ProvideConfigurableModule(m),
+		kitevent.ProvideStore(kiteventsqlite.New),
 	)
 }
 
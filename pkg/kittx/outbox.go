@@ -0,0 +1,121 @@
+package kittx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kitcat-framework/kitcat/kitevent"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// OutboxMessage is the row of a kitevent.OutboxMessage
+type OutboxMessage struct {
+	ID        string `gorm:"primaryKey"`
+	EventName string
+	Payload   []byte
+	Metadata  []byte
+	ProduceAt *time.Time
+	CreatedAt time.Time  `gorm:"index"`
+	RelayedAt *time.Time `gorm:"index"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "kitevent_outbox_messages"
+}
+
+// OutboxStorage is a kitevent.OutboxStorage saving the messages with gorm, in the transaction
+// of the context if any (see Tx).
+//
+// Provide it with kitevent.ProvideOutboxStorage(kittx.NewOutboxStorage) to enable the outbox.
+type OutboxStorage struct {
+	db *gorm.DB
+}
+
+func NewOutboxStorage(db *gorm.DB) (*OutboxStorage, error) {
+	if err := db.AutoMigrate(&OutboxMessage{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate outbox table: %w", err)
+	}
+
+	return &OutboxStorage{db: db}, nil
+}
+
+func (s OutboxStorage) Save(ctx context.Context, message *kitevent.OutboxMessage) error {
+	metadata, err := json.Marshal(message.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox message metadata: %w", err)
+	}
+
+	err = MayTx(ctx, s.db).WithContext(ctx).Create(&OutboxMessage{
+		ID:        message.ID,
+		EventName: message.EventName.Name,
+		Payload:   message.Payload,
+		Metadata:  metadata,
+		ProduceAt: message.ProduceAt,
+		CreatedAt: message.CreatedAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save outbox message: %w", err)
+	}
+
+	return nil
+}
+
+// RelayTx calls the function in a transaction, see Tx.
+func (s OutboxStorage) RelayTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, ctxKeyTx, tx))
+	})
+}
+
+// FindUnrelayed locks the messages until the end of the transaction of the context, skipping the
+// ones locked by another relay.
+func (s OutboxStorage) FindUnrelayed(ctx context.Context, limit int) ([]*kitevent.OutboxMessage, error) {
+	var rows []OutboxMessage
+
+	err := MayTx(ctx, s.db).WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("relayed_at is null").
+		Order("created_at, id").
+		Limit(limit).
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find outbox messages: %w", err)
+	}
+
+	messages := make([]*kitevent.OutboxMessage, len(rows))
+	for i, row := range rows {
+		var metadata map[string]any
+		if err := json.Unmarshal(row.Metadata, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal outbox message metadata: %w", err)
+		}
+
+		messages[i] = &kitevent.OutboxMessage{
+			ID:        row.ID,
+			EventName: kitevent.NewEventName(row.EventName),
+			Payload:   row.Payload,
+			Metadata:  metadata,
+			ProduceAt: row.ProduceAt,
+			CreatedAt: row.CreatedAt,
+		}
+	}
+
+	return messages, nil
+}
+
+func (s OutboxStorage) MarkRelayed(ctx context.Context, ids []string) error {
+	err := MayTx(ctx, s.db).WithContext(ctx).
+		Model(&OutboxMessage{}).
+		Where("id in ?", ids).
+		Update("relayed_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox messages as relayed: %w", err)
+	}
+
+	return nil
+}
+
+func (s OutboxStorage) Name() string {
+	return "kittx"
+}